@@ -1,3 +1,9 @@
 module github.com/drone/liteproto
 
-go 1.12
+// github.com/klauspost/compress, which provides zstd, requires Go 1.21.
+go 1.21
+
+require (
+	github.com/gorilla/websocket v1.5.3
+	github.com/klauspost/compress v1.17.11
+)
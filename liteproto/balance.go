@@ -0,0 +1,42 @@
+package liteproto
+
+import "time"
+
+// BalancePolicy selects how a balancing client spreads calls across remote endpoints.
+type BalancePolicy int
+
+const (
+	// BalanceRoundRobin sends calls to healthy endpoints in turn.
+	BalanceRoundRobin BalancePolicy = iota
+
+	// BalanceLeastInFlight sends a call to the healthy endpoint with the fewest calls in progress.
+	BalanceLeastInFlight
+
+	// BalanceConsistentHash sends calls with the same key to the same endpoint, as long as it's healthy.
+	// The key is the request ID, or a metadata value if BalanceOptions.HashKey is set.
+	BalanceConsistentHash
+)
+
+// BalanceOptions configures a balancing client.
+type BalanceOptions struct {
+	// Policy is the balancing policy.
+	Policy BalancePolicy
+
+	// HashKey is the metadata key used by BalanceConsistentHash policy. If empty, the request ID is used.
+	HashKey string
+
+	// CheckInterval is the time between two active health checks of an endpoint.
+	// Health checks are TypePing requests. Zero value disables the health checks.
+	CheckInterval time.Duration
+
+	// CheckTimeout is the timeout of a single health check. Zero value means CheckInterval.
+	CheckTimeout time.Duration
+
+	// FailThreshold is the number of consecutive failures after which an endpoint is ejected.
+	// Failed calls are counted too. Zero value means 3.
+	FailThreshold int
+
+	// RecoverThreshold is the number of consecutive successful health checks after which
+	// an ejected endpoint is added back. Zero value means 2.
+	RecoverThreshold int
+}
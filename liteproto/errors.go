@@ -1,6 +1,9 @@
 package liteproto
 
-import "errors"
+import (
+	"errors"
+	"strings"
+)
 
 // ErrUnknownType is returned when the system encounters an unregistered type.
 var ErrUnknownType = errors.New("unrecognized type")
@@ -10,3 +13,67 @@ var ErrAlreadySubscribed = errors.New("subscription already exists for ID")
 
 // ErrNotSubscribed is returned by ResponseHandler when there is no subscription to an ID.
 var ErrNotSubscribed = errors.New("no subscription for ID")
+
+// ErrNoResults is returned by ResultStore when there are no stored responses for an ID.
+var ErrNoResults = errors.New("no stored responses for ID")
+
+// ErrResponseOverflow is reported in the last response of a stream that was terminated
+// because the subscriber didn't keep up with the responses and OverflowFail policy was used.
+var ErrResponseOverflow = errors.New("response buffer overflow")
+
+// ErrUnknownPeer is returned by calls to a peer that isn't registered.
+var ErrUnknownPeer = errors.New("unknown peer")
+
+// ErrNoHealthyEndpoint is returned by a balancing client when all remote endpoints are ejected or none are known.
+var ErrNoHealthyEndpoint = errors.New("no healthy endpoint")
+
+// ErrNoPeers is returned by ScatterGather when there are no peers to call.
+var ErrNoPeers = errors.New("no peers")
+
+// ErrGatherN is returned by ScatterGather when GatherOptions.N is out of range with GatherFirstN mode.
+var ErrGatherN = errors.New("number of peers to gather out of range")
+
+// ValidationError is returned when the payload of a message doesn't match the schema of its task type.
+// Requests with invalid payload are rejected before they reach the execer, responses before they are sent.
+type ValidationError struct {
+	// Type is the task type of the message.
+	Type string `json:"type,omitempty"`
+
+	// Errors lists the problems found in the payload.
+	Errors []FieldError `json:"errors"`
+}
+
+// FieldError is a problem found in a payload by a Schema.
+type FieldError struct {
+	// Path is a JSON Pointer to the invalid value, empty for the payload itself.
+	Path string `json:"path"`
+
+	// Message describes the problem.
+	Message string `json:"message"`
+}
+
+func (e *ValidationError) Error() string {
+	var sb strings.Builder
+
+	sb.WriteString("invalid payload")
+	if e.Type != "" {
+		sb.WriteString(" of type ")
+		sb.WriteString(e.Type)
+	}
+
+	for i, fe := range e.Errors {
+		if i == 0 {
+			sb.WriteString(": ")
+		} else {
+			sb.WriteString("; ")
+		}
+
+		if fe.Path != "" {
+			sb.WriteString(fe.Path)
+			sb.WriteString(" ")
+		}
+		sb.WriteString(fe.Message)
+	}
+
+	return sb.String()
+}
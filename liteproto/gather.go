@@ -0,0 +1,241 @@
+package liteproto
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// GatherMode tells how many peers must answer a scatter-gather call. A peer is counted
+// as answered when the first response from it arrives, when its response stream ends or when the call to it fails.
+// Responses of peers that answer after enough peers are counted are dropped. The call is complete
+// when each counted peer finished: sent a response with StatusSuccess or StatusError, its response
+// stream ended or the call to it failed.
+type GatherMode int
+
+const (
+	// GatherAll completes when all peers answered.
+	GatherAll GatherMode = iota
+
+	// GatherQuorum completes when a majority of peers answered.
+	GatherQuorum
+
+	// GatherFirstN completes when GatherOptions.N peers answered.
+	GatherFirstN
+)
+
+// GatherOptions configures a scatter-gather call.
+type GatherOptions struct {
+	// Mode is the completion mode.
+	Mode GatherMode
+
+	// N is the number of peers that must answer with GatherFirstN mode,
+	// from one up to the number of peers.
+	N int
+
+	// Deadline is shared by the calls to all peers. Zero value means no deadline.
+	Deadline time.Time
+}
+
+// PeerResponse is a response received from a peer in a scatter-gather call.
+type PeerResponse struct {
+	// Peer is the name of the peer that sent the response.
+	Peer string
+
+	TaskResponse
+}
+
+// ScatterGather sends the same request to all peers and merges their response streams into one.
+// Each peer receives the request with its own ID, made of the request ID and the peer name,
+// but the merged responses have the original request ID and are tagged with the peer name.
+// If the call to a peer fails, a response with StatusError and the error message as JSON string
+// in Data is merged instead. It returns an error only if there are no peers, if GatherOptions.N
+// is out of range with GatherFirstN mode or if all calls fail.
+//
+// The response channel is closed when the call is complete according to the GatherMode,
+// when all response streams end, when the deadline expires or when the context is done.
+// The stop channel should be closed by the caller when no further responses are expected.
+func ScatterGather(ctx context.Context, peers map[string]Client, request TaskRequest, opts GatherOptions) (response <-chan PeerResponse, stop chan<- struct{}, err error) {
+	if len(peers) == 0 {
+		return nil, nil, ErrNoPeers
+	}
+
+	required := len(peers)
+	switch opts.Mode {
+	case GatherQuorum:
+		required = len(peers)/2 + 1
+	case GatherFirstN:
+		if opts.N <= 0 || opts.N > len(peers) {
+			return nil, nil, ErrGatherN
+		}
+		required = opts.N
+	}
+
+	type stream struct {
+		peer     string
+		response <-chan TaskResponse
+		stop     chan<- struct{}
+		err      error
+	}
+
+	streams := make([]*stream, 0, len(peers))
+	wg := sync.WaitGroup{}
+	for peer, client := range peers {
+		s := &stream{peer: peer}
+		streams = append(streams, s)
+
+		wg.Add(1)
+		go func(s *stream, client Client) {
+			defer wg.Done()
+
+			r := request
+			r.ID = request.ID + "/" + s.peer
+
+			if opts.Deadline.IsZero() {
+				s.response, s.stop, s.err = client.CallWithResponse(ctx, r)
+			} else {
+				s.response, s.stop, s.err = client.CallWithDeadline(ctx, r, opts.Deadline)
+			}
+		}(s, client)
+	}
+	wg.Wait()
+
+	var failed []PeerResponse
+	for _, s := range streams {
+		if s.err != nil {
+			data, _ := json.Marshal(s.err.Error())
+			failed = append(failed, PeerResponse{
+				Peer:         s.peer,
+				TaskResponse: TaskResponse{ID: request.ID, Type: request.Type, Status: StatusError, Data: data},
+			})
+			err = s.err
+		}
+	}
+
+	if len(failed) == len(streams) {
+		return nil, nil, err
+	}
+
+	err = nil
+
+	ctxJob, cancelFunc := ctx, func() {}
+	if !opts.Deadline.IsZero() {
+		ctxJob, cancelFunc = context.WithDeadline(ctx, opts.Deadline)
+	}
+
+	responseChan := make(chan PeerResponse)
+	stopChan := make(chan struct{})
+	mergedChan := make(chan PeerResponse)
+	finishedChan := make(chan string)
+	quitChan := make(chan struct{})
+
+	forwarders := sync.WaitGroup{}
+	for _, s := range streams {
+		if s.err != nil {
+			continue
+		}
+
+		forwarders.Add(1)
+		go func(s *stream) {
+			defer forwarders.Done()
+
+			for r := range s.response {
+				r.ID = request.ID
+				select {
+				case mergedChan <- PeerResponse{Peer: s.peer, TaskResponse: r}:
+				case <-quitChan:
+					return
+				}
+			}
+
+			select {
+			case finishedChan <- s.peer:
+			case <-quitChan:
+			}
+		}(s)
+	}
+
+	go func(ctx context.Context) {
+		defer func() {
+			close(quitChan)
+			for _, s := range streams {
+				if s.err == nil {
+					close(s.stop)
+				}
+			}
+			forwarders.Wait()
+			close(responseChan)
+			cancelFunc()
+		}()
+
+		// counted are the peers that answered first, true once they finished: sent a response
+		// with StatusSuccess or StatusError, their response streams ended or the calls to them failed
+		counted := map[string]bool{}
+		open := len(streams) - len(failed)
+
+		// count adds the peer to the counted ones if it's not full yet. It reports whether the peer is counted.
+		count := func(peer string, finished bool) bool {
+			done, ok := counted[peer]
+			if !ok && len(counted) >= required {
+				return false
+			}
+			counted[peer] = done || finished
+			return true
+		}
+
+		complete := func() bool {
+			if len(counted) < required {
+				return false
+			}
+			for _, finished := range counted {
+				if !finished {
+					return false
+				}
+			}
+			return true
+		}
+
+		deliver := func(r PeerResponse) bool {
+			select {
+			case responseChan <- r:
+				return true
+			case <-ctx.Done():
+				return false
+			case <-stopChan:
+				return false
+			}
+		}
+
+		for _, r := range failed {
+			if count(r.Peer, true) && !deliver(r) {
+				return
+			}
+		}
+
+		for open > 0 && !complete() {
+			select {
+			case <-ctx.Done():
+				return
+			case <-stopChan:
+				return
+			case peer := <-finishedChan:
+				count(peer, true)
+				open--
+			case r := <-mergedChan:
+				// drop responses of peers that aren't counted and of counted peers that finished
+				if counted[r.Peer] {
+					continue
+				}
+				if !count(r.Peer, r.Status == StatusSuccess || r.Status == StatusError) {
+					continue
+				}
+				if !deliver(r) {
+					return
+				}
+			}
+		}
+	}(ctxJob)
+
+	return responseChan, stopChan, nil
+}
@@ -0,0 +1,170 @@
+package liteproto_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/drone/liteproto/liteproto"
+	"github.com/drone/liteproto/liteproto/liteprotomem"
+)
+
+// execerFunc is a liteproto.ExecerWithResponder implemented by a function.
+type execerFunc func(ctx context.Context, r liteproto.TaskRequest, rc liteproto.ResponderClient)
+
+func (f execerFunc) Exec(ctx context.Context, r liteproto.TaskRequest, rc liteproto.ResponderClient) {
+	f(ctx, r, rc)
+}
+
+// newPeers joins a caller and peers to a bus. Each peer responds with StatusOK and StatusSuccess
+// once its release channel is closed, a nil channel means right away.
+func newPeers(t *testing.T, release map[string]chan struct{}) map[string]liteproto.Client {
+	bus := liteprotomem.NewBus()
+	caller := bus.Join("caller", "", nil)
+
+	peers := map[string]liteproto.Client{}
+	for name, ch := range release {
+		ch := ch
+		peer := bus.Join(name, "caller", nil)
+		peer.RegisterWithResponder("build", execerFunc(func(ctx context.Context, r liteproto.TaskRequest, rc liteproto.ResponderClient) {
+			if ch != nil {
+				<-ch
+			}
+			_ = rc.Respond(ctx, liteproto.StatusOK, nil)
+			_ = rc.Respond(ctx, liteproto.StatusSuccess, nil)
+		}))
+		peers[name] = caller.Client(name)
+	}
+
+	return peers
+}
+
+// gather reads all responses of a scatter-gather call, it fails if they don't end within a second.
+func gather(t *testing.T, response <-chan liteproto.PeerResponse) []liteproto.PeerResponse {
+	t.Helper()
+
+	timeout := time.After(time.Second)
+
+	var responses []liteproto.PeerResponse
+	for {
+		select {
+		case r, ok := <-response:
+			if !ok {
+				return responses
+			}
+			responses = append(responses, r)
+		case <-timeout:
+			t.Fatalf("responses didn't end, got %+v", responses)
+		}
+	}
+}
+
+func TestScatterGatherN(t *testing.T) {
+	tests := []struct {
+		n   int
+		err error
+	}{
+		{-1, liteproto.ErrGatherN},
+		{0, liteproto.ErrGatherN},
+		{1, nil},
+		{2, nil},
+		{3, liteproto.ErrGatherN},
+	}
+
+	for _, test := range tests {
+		peers := newPeers(t, map[string]chan struct{}{"a": nil, "b": nil})
+
+		_, stop, err := liteproto.ScatterGather(context.Background(), peers, liteproto.TaskRequest{ID: "1", Type: "build"}, liteproto.GatherOptions{Mode: liteproto.GatherFirstN, N: test.n})
+		if err != test.err {
+			t.Errorf("N=%d: expected error %v, got %v", test.n, test.err, err)
+		}
+		if stop != nil {
+			close(stop)
+		}
+	}
+}
+
+func TestScatterGather(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     liteproto.GatherOptions
+		expected int // the number of responses
+	}{
+		{"all", liteproto.GatherOptions{Mode: liteproto.GatherAll}, 6},
+		{"all with a deadline", liteproto.GatherOptions{Mode: liteproto.GatherAll, Deadline: time.Now().Add(time.Minute)}, 6},
+		{"quorum", liteproto.GatherOptions{Mode: liteproto.GatherQuorum}, 4},
+		{"first", liteproto.GatherOptions{Mode: liteproto.GatherFirstN, N: 1}, 2},
+		{"first with a deadline", liteproto.GatherOptions{Mode: liteproto.GatherFirstN, N: 1, Deadline: time.Now().Add(time.Minute)}, 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// the first peers answer right away, the last one when the call is complete
+			last := make(chan struct{})
+			release := map[string]chan struct{}{"a": nil, "b": nil, "c": last}
+			if test.opts.Mode == liteproto.GatherFirstN {
+				release["b"] = last
+			}
+			if test.opts.Mode != liteproto.GatherAll {
+				defer close(last)
+			} else {
+				close(last)
+			}
+
+			response, stop, err := liteproto.ScatterGather(context.Background(), newPeers(t, release), liteproto.TaskRequest{ID: "1", Type: "build"}, test.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer close(stop)
+
+			responses := gather(t, response)
+			if len(responses) != test.expected {
+				t.Fatalf("expected %d responses, got %+v", test.expected, responses)
+			}
+
+			finished := map[string]bool{}
+			for _, r := range responses {
+				if r.ID != "1" {
+					t.Errorf("expected the request ID, got %s", r.ID)
+				}
+				if finished[r.Peer] {
+					t.Errorf("response from %s after its final response", r.Peer)
+				}
+				finished[r.Peer] = r.Status == liteproto.StatusSuccess
+			}
+			if test.opts.Mode == liteproto.GatherFirstN && !finished["a"] {
+				t.Errorf("expected the responses of the first peer, got %+v", responses)
+			}
+		})
+	}
+}
+
+func TestScatterGatherFailed(t *testing.T) {
+	bus := liteprotomem.NewBus()
+	caller := bus.Join("caller", "", nil)
+
+	// calls to peers that aren't on the bus fail
+	peers := map[string]liteproto.Client{"a": caller.Client("a"), "b": caller.Client("b")}
+
+	if _, _, err := liteproto.ScatterGather(context.Background(), peers, liteproto.TaskRequest{ID: "1", Type: "build"}, liteproto.GatherOptions{}); err != liteproto.ErrUnknownPeer {
+		t.Errorf("expected ErrUnknownPeer, got %v", err)
+	}
+
+	// a peer that answers, called from a bus of its own
+	peers["c"] = newPeers(t, map[string]chan struct{}{"c": nil})["c"]
+
+	response, stop, err := liteproto.ScatterGather(context.Background(), peers, liteproto.TaskRequest{ID: "1", Type: "build"}, liteproto.GatherOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer close(stop)
+
+	statuses := map[string][]string{}
+	for _, r := range gather(t, response) {
+		statuses[r.Peer] = append(statuses[r.Peer], r.Status)
+	}
+
+	if len(statuses["a"]) != 1 || statuses["a"][0] != liteproto.StatusError || len(statuses["c"]) != 2 {
+		t.Errorf("expected an error of a and b and the responses of c, got %v", statuses)
+	}
+}
@@ -23,6 +23,15 @@ type Server interface {
 	RegisterCatchAll(execer ExecerWithResponder)
 }
 
+// SchemaServer allows schemas to be attached to task types. It's implemented by the servers of this module.
+type SchemaServer interface {
+	// RegisterSchema attaches schemas to a task type. Requests of the type with payload that doesn't match
+	// the request schema are rejected with a *ValidationError before they reach the execer. Responses that
+	// don't match the response schema are not sent, Respond returns a *ValidationError instead.
+	// The schemas are available to remote peers with TypeSchema requests.
+	RegisterSchema(t string, schemas TypeSchemas)
+}
+
 // Client allows calls to a remote server.
 type Client interface {
 	// Call executes a task on a remote server.
@@ -39,6 +48,21 @@ type Client interface {
 	CallWithDeadline(ctx context.Context, request TaskRequest, deadline time.Time) (response <-chan TaskResponse, stop chan<- struct{}, err error)
 }
 
+// Resubscriber allows a caller to receive responses of a task that it stopped listening to.
+// The remote server must keep responses in a ResultStore for this to work.
+type Resubscriber interface {
+	// Resubscribe subscribes to responses of the task with the provided ID and asks the remote server
+	// to replay the stored responses, skipping the first 'from' responses the task sent.
+	// Replayed responses arrive before any response the server sends afterwards.
+	Resubscribe(ctx context.Context, id string, from int, deadline time.Time) (response <-chan TaskResponse, stop chan<- struct{}, err error)
+}
+
+// Resolver discovers addresses of remote endpoints, for example URLs of replicas of a remote server.
+// Balancing clients call it periodically to update the set of endpoints at runtime.
+type Resolver interface {
+	Resolve(ctx context.Context) (addrs []string, err error)
+}
+
 // Responder is a simple interface to send a response. Value of the parameter status must not be an empty string.
 type Responder interface {
 	Respond(ctx context.Context, status string, data []byte) error
@@ -0,0 +1,21 @@
+package liteprotohttp
+
+import (
+	"net/http"
+
+	"github.com/drone/liteproto/liteproto"
+)
+
+// endpointError filters out errors that don't indicate a problem with the remote endpoint,
+// i.e. requests rejected by the endpoint because of their content.
+func endpointError(err error) error {
+	if e, ok := err.(CallFailedError); ok && e.StatusCode < http.StatusInternalServerError {
+		return nil
+	}
+
+	if _, ok := err.(*liteproto.ValidationError); ok {
+		return nil
+	}
+
+	return err
+}
@@ -2,25 +2,27 @@ package liteprotohttp
 
 import (
 	"bytes"
-	"compress/gzip"
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"strconv"
 	"sync"
-	"time"
 
 	"github.com/drone/liteproto/liteproto"
+	"github.com/drone/liteproto/liteproto/transport"
 )
 
-// newCaller creates a new Caller that calls a remote server with using HTTP/HTTPS protocol.
-func newCaller(client *http.Client, marshaller messageMarshaller, url string, compress bool) *caller {
+// newCaller creates a new transport.Delivery that sends messages to a remote server with using HTTP/HTTPS protocol.
+// Messages addressed to the default (empty) address are sent to the provided URL.
+func newCaller(client *http.Client, codec Codec, url string, comp compression) *caller {
 	return &caller{
-		client:     client,
-		marshaller: marshaller,
-		url:        url,
-		compress:   compress,
+		client:      client,
+		codec:       codec,
+		url:         url,
+		compression: comp,
+		encodings:   &encodings{},
 		bufferPool: &sync.Pool{
 			New: func() interface{} {
 				return bytes.NewBuffer(nil)
@@ -30,85 +32,137 @@ func newCaller(client *http.Client, marshaller messageMarshaller, url string, co
 }
 
 type caller struct {
-	client     *http.Client
-	marshaller messageMarshaller
-	url        string
-	compress   bool
-	bufferPool *sync.Pool
+	client      *http.Client
+	codec       Codec
+	url         string
+	compression compression
+	encodings   *encodings
+	relay       *relaySigner // set for callers that relay responses to other replicas
+	bufferPool  *sync.Pool
 }
 
-func (c *caller) Call(ctx context.Context, r liteproto.TaskRequest, deadline time.Time) error {
-	return c.do(ctx, r.ID, r.Type, "", r.Data, &deadline)
+// Deliver sends an envelope to the URL in parameter addr, or to the caller's URL if addr is empty.
+// This method implements transport.Delivery interface.
+func (c *caller) Deliver(ctx context.Context, addr string, e *transport.Envelope) error {
+	if addr == "" {
+		addr = c.url
+	}
+
+	if e.Payload != nil {
+		return c.stream(ctx, addr, e, e.Payload)
+	}
+
+	return c.do(ctx, addr, e)
 }
 
-func (c *caller) do(ctx context.Context, id, t, status string, data []byte, deadline *time.Time) (err error) {
+func (c *caller) do(ctx context.Context, url string, e *transport.Envelope) (err error) {
+	// the responses to a request, and the calls made by its execer, are sent in the encoding of the request
+
+	codec := c.codec
+	if e.Encoding != "" {
+		if replyCodec := LookupCodec(e.Encoding); replyCodec != nil {
+			codec = replyCodec
+		}
+	}
 
 	buf := c.bufferPool.Get().(*bytes.Buffer)
 	buf.Reset()
 	buf.Grow(512)
 	defer c.bufferPool.Put(buf)
 
-	var wc io.WriteCloser
-	if c.compress {
-		wc = gzip.NewWriter(buf)
-	} else {
-		wc = nopCloseWriter{buf}
+	err = codec.Encode(buf, e)
+	if err != nil {
+		return
 	}
 
-	if deadline != nil && deadline.IsZero() {
-		deadline = nil
+	// compress the body with the best encoding the remote handler accepts,
+	// if it's a handler that doesn't accept it after all, send the body uncompressed
+
+	encoding := c.encodings.choose(url, c.compression.preferred)
+	if buf.Len() < c.compression.minSize {
+		encoding = CompressionNone
 	}
-	m := &message{
-		ID:       id,
-		Type:     t,
-		Status:   status,
-		Data:     data,
-		Deadline: deadline,
+
+	body := buf
+	if encoding != CompressionNone {
+		body = c.bufferPool.Get().(*bytes.Buffer)
+		body.Reset()
+		defer c.bufferPool.Put(body)
+
+		if err = compress(body, buf.Bytes(), encoding, c.compression.level); err != nil {
+			return
+		}
 	}
 
-	err = wrapWriter(wc, func(writer io.Writer) error {
-		return c.marshaller.messageMarshal(writer, m)
-	})
+	resp, err := c.post(ctx, url, e.ID, codec, body.Bytes(), encoding)
+	if err == nil && resp.StatusCode == http.StatusUnsupportedMediaType && encoding != CompressionNone {
+		resp.Body.Close()
+		resp, err = c.post(ctx, url, e.ID, codec, buf.Bytes(), CompressionNone)
+	}
+	if resp != nil && resp.Body != nil {
+		defer resp.Body.Close()
+	}
 	if err != nil {
 		return
 	}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, buf)
+	return checkResponse(resp)
+}
+
+func (c *caller) post(ctx context.Context, url, id string, codec Codec, body []byte, encoding Compression) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
 	if err != nil {
-		return
+		return nil, err
 	}
 
-	req.Header.Set("Content-Type", "application/json; charset=utf-8")
-	req.Header.Set("Content-Length", strconv.Itoa(buf.Len()))
-	if c.compress {
-		req.Header.Set("Content-Encoding", "gzip")
+	req.Header.Set("Content-Type", codec.ContentType())
+	req.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	if encoding != CompressionNone {
+		req.Header.Set("Content-Encoding", string(encoding))
+	}
+	if c.relay != nil {
+		req.Header.Set(relayedHeader, c.relay.sign(id))
 	}
 
 	resp, err := c.client.Do(req)
-	if resp != nil && resp.Body != nil {
-		defer resp.Body.Close()
-	}
 	if err != nil {
-		return
+		return resp, err
 	}
 
+	c.encodings.learn(url, resp.Header)
+
+	return resp, nil
+}
+
+// checkResponse returns CallFailedError if the remote server responded with an error,
+// or *liteproto.ValidationError if it rejected the payload.
+// maxErrorBody limits the body of an error response kept in CallFailedError.
+const maxErrorBody = 1 << 20
+
+func checkResponse(resp *http.Response) error {
 	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated &&
 		resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusNoContent {
 
 		var body []byte
 
 		if resp.StatusCode >= http.StatusBadRequest {
-			body, _ = io.ReadAll(resp.Body)
+			body, _ = io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+		}
+
+		if resp.StatusCode == http.StatusUnprocessableEntity {
+			validationErr := &liteproto.ValidationError{}
+			if err := json.Unmarshal(body, validationErr); err == nil && len(validationErr.Errors) > 0 {
+				return validationErr
+			}
 		}
 
-		err = CallFailedError{
+		return CallFailedError{
 			StatusCode: resp.StatusCode,
 			Body:       body,
 		}
-		return
 	}
 
-	return
+	return nil
 }
 
 // CallFailedError is returned by Caller when a remote server returns an error.
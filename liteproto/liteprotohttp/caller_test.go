@@ -0,0 +1,78 @@
+package liteprotohttp
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/drone/liteproto/liteproto"
+)
+
+type nopExecer struct{}
+
+func (nopExecer) Exec(context.Context, liteproto.TaskRequest, liteproto.ResponderClient) {}
+
+func TestCallValidationError(t *testing.T) {
+	server := New("http://unused", false, nil, nil)
+	server.RegisterWithResponder("build", nopExecer{})
+	server.RegisterSchema("build", liteproto.TypeSchemas{Request: liteproto.SchemaFor(struct {
+		Name string `json:"name"`
+	}{})})
+
+	srv := httptest.NewServer(server.Handler())
+	defer srv.Close()
+
+	caller := New(srv.URL, false, nil, nil)
+
+	err := caller.Call(context.Background(), liteproto.TaskRequest{ID: "1", Type: "build", Data: []byte(`{"name":1}`)})
+
+	validationErr, ok := err.(*liteproto.ValidationError)
+	if !ok {
+		t.Fatalf("expected *ValidationError, got %T: %v", err, err)
+	}
+	if validationErr.Type != "build" || len(validationErr.Errors) != 1 || validationErr.Errors[0].Path != "/name" {
+		t.Errorf("unexpected validation error: %+v", validationErr)
+	}
+
+	if err = caller.Call(context.Background(), liteproto.TaskRequest{ID: "2", Type: "build", Data: []byte(`{"name":"a"}`)}); err != nil {
+		t.Errorf("expected valid request to pass, got %v", err)
+	}
+}
+
+func TestCheckResponse(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		body       string
+		validation bool
+	}{
+		{name: "no content", status: http.StatusNoContent},
+		{name: "validation error", status: http.StatusUnprocessableEntity, body: `{"type":"t","errors":[{"path":"","message":"m"}]}`, validation: true},
+		{name: "422 without errors", status: http.StatusUnprocessableEntity, body: `{"type":"t"}`},
+		{name: "422 not json", status: http.StatusUnprocessableEntity, body: `unprocessable`},
+		{name: "bad request", status: http.StatusBadRequest, body: `missing id`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := checkResponse(&http.Response{
+				StatusCode: test.status,
+				Body:       io.NopCloser(bytes.NewBufferString(test.body)),
+			})
+
+			switch _, isValidation := err.(*liteproto.ValidationError); {
+			case test.status == http.StatusNoContent && err != nil:
+				t.Errorf("expected no error, got %v", err)
+			case test.validation && !isValidation:
+				t.Errorf("expected *ValidationError, got %T: %v", err, err)
+			case !test.validation && test.status != http.StatusNoContent:
+				if failed, ok := err.(CallFailedError); !ok || failed.StatusCode != test.status || string(failed.Body) != test.body {
+					t.Errorf("expected CallFailedError, got %T: %v", err, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,388 @@
+package liteprotohttp
+
+import (
+	"errors"
+	"io"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/drone/liteproto/liteproto/transport"
+)
+
+// cborer encodes messages as application/cbor: a map with the same keys as JSON messages,
+// data as a byte string and deadline as a standard date/time string (tag 0).
+type cborer struct{}
+
+var errInvalidCBOR = errors.New("invalid cbor message")
+
+// CBOR major types
+const (
+	cborUint   = 0
+	cborNegInt = 1
+	cborBytes  = 2
+	cborText   = 3
+	cborArray  = 4
+	cborMap    = 5
+	cborTag    = 6
+	cborSimple = 7
+)
+
+func (cborer) ContentType() string {
+	return "application/cbor"
+}
+
+func (cborer) Encode(w io.Writer, e *transport.Envelope) error {
+	var b cborWriter
+
+	b.head(cborMap, uint64(fieldCount(e)))
+
+	b.text("id")
+	b.text(e.ID)
+	b.text("type")
+	b.text(e.Type)
+
+	if e.Status != "" {
+		b.text("status")
+		b.text(e.Status)
+	}
+
+	if len(e.Data) > 0 {
+		b.text("data")
+		b.head(cborBytes, uint64(len(e.Data)))
+		b = append(b, e.Data...)
+	}
+
+	if !e.Deadline.IsZero() {
+		b.text("deadline")
+		b.head(cborTag, 0)
+		b.text(e.Deadline.Format(time.RFC3339Nano))
+	}
+
+	if e.ReplyTo != "" {
+		b.text("reply_to")
+		b.text(e.ReplyTo)
+	}
+
+	if len(e.Metadata) > 0 {
+		keys := make([]string, 0, len(e.Metadata))
+		for k := range e.Metadata {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		b.text("metadata")
+		b.head(cborMap, uint64(len(keys)))
+		for _, k := range keys {
+			b.text(k)
+			b.text(e.Metadata[k])
+		}
+	}
+
+	_, err := w.Write(b)
+	return err
+}
+
+func (cborer) Decode(r io.Reader) (*transport.Envelope, error) {
+	data, err := readAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	cr := cborReader(data)
+
+	v, err := cr.value(0)
+	if err != nil {
+		return nil, err
+	}
+
+	fields, ok := v.(map[string]interface{})
+	if !ok || len(cr) > 0 {
+		return nil, errInvalidCBOR
+	}
+
+	return envelopeFields(fields).envelope()
+}
+
+type cborWriter []byte
+
+func (w *cborWriter) head(major byte, n uint64) {
+	m := major << 5
+	switch {
+	case n < 24:
+		*w = append(*w, m|byte(n))
+	case n <= math.MaxUint8:
+		*w = append(*w, m|24, byte(n))
+	case n <= math.MaxUint16:
+		*w = append(*w, m|25, byte(n>>8), byte(n))
+	case n <= math.MaxUint32:
+		*w = appendUint32(append(*w, m|26), uint32(n))
+	default:
+		*w = appendUint64(append(*w, m|27), n)
+	}
+}
+
+func (w *cborWriter) text(s string) {
+	w.head(cborText, uint64(len(s)))
+	*w = append(*w, s...)
+}
+
+type cborReader []byte
+
+// cborBreak is the stop code of indefinite length items.
+const cborBreak = 0xff
+
+// indefinite is the additional information of indefinite length items.
+const indefinite = 31
+
+func (r *cborReader) take(n uint64) ([]byte, error) {
+	if n > uint64(len(*r)) {
+		return nil, errInvalidCBOR
+	}
+
+	b := (*r)[:n]
+	*r = (*r)[n:]
+
+	return b, nil
+}
+
+// head reads the initial byte and the argument of the next item.
+func (r *cborReader) head() (major byte, info byte, arg uint64, err error) {
+	b, err := r.take(1)
+	if err != nil {
+		return
+	}
+
+	major, info = b[0]>>5, b[0]&0x1f
+
+	switch {
+	case info < 24:
+		arg = uint64(info)
+	case info <= 27:
+		var data []byte
+		if data, err = r.take(1 << (info - 24)); err != nil {
+			return
+		}
+		for _, c := range data {
+			arg = arg<<8 | uint64(c)
+		}
+	case info == indefinite && major >= cborBytes && major <= cborMap || info == indefinite && major == cborSimple:
+	default:
+		err = errInvalidCBOR
+	}
+
+	return
+}
+
+// atBreak consumes the stop code if it's next.
+func (r *cborReader) atBreak() (bool, error) {
+	if len(*r) == 0 {
+		return false, errInvalidCBOR
+	}
+
+	if (*r)[0] == cborBreak {
+		*r = (*r)[1:]
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// value decodes the next item. Maps with other than string keys are decoded as nil.
+func (r *cborReader) value(depth int) (interface{}, error) {
+	if depth > maxNesting {
+		return nil, errInvalidNesting
+	}
+
+	major, info, arg, err := r.head()
+	if err != nil {
+		return nil, err
+	}
+
+	switch major {
+	case cborUint:
+		return arg, nil
+
+	case cborNegInt:
+		return -1 - int64(arg), nil
+
+	case cborBytes, cborText:
+		var data []byte
+
+		if info == indefinite {
+			for {
+				end, err := r.atBreak()
+				if err != nil {
+					return nil, err
+				}
+				if end {
+					break
+				}
+
+				chunkMajor, chunkInfo, n, err := r.head()
+				if err != nil {
+					return nil, err
+				}
+				if chunkMajor != major || chunkInfo == indefinite {
+					return nil, errInvalidCBOR
+				}
+
+				chunk, err := r.take(n)
+				if err != nil {
+					return nil, err
+				}
+				data = append(data, chunk...)
+			}
+		} else {
+			chunk, err := r.take(arg)
+			if err != nil {
+				return nil, err
+			}
+			data = append([]byte(nil), chunk...)
+		}
+
+		if major == cborText {
+			return string(data), nil
+		}
+		return data, nil
+
+	case cborArray:
+		var values []interface{}
+
+		for i := uint64(0); info == indefinite || i < arg; i++ {
+			if info == indefinite {
+				end, err := r.atBreak()
+				if err != nil {
+					return nil, err
+				}
+				if end {
+					break
+				}
+			} else if arg > uint64(len(*r)) {
+				return nil, errInvalidCBOR
+			}
+
+			v, err := r.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, v)
+		}
+
+		return values, nil
+
+	case cborMap:
+		m := map[string]interface{}{}
+		stringKeys := true
+
+		for i := uint64(0); info == indefinite || i < arg; i++ {
+			if info == indefinite {
+				end, err := r.atBreak()
+				if err != nil {
+					return nil, err
+				}
+				if end {
+					break
+				}
+			} else if arg > uint64(len(*r)) {
+				return nil, errInvalidCBOR
+			}
+
+			k, err := r.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+
+			v, err := r.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+
+			if s, ok := k.(string); ok {
+				m[s] = v
+			} else {
+				stringKeys = false
+			}
+		}
+
+		if !stringKeys {
+			return nil, nil
+		}
+
+		return m, nil
+
+	case cborTag:
+		v, err := r.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+
+		switch arg {
+		case 0: // date/time string
+			if s, ok := v.(string); ok {
+				return time.Parse(time.RFC3339Nano, s)
+			}
+			return nil, errInvalidCBOR
+		case 1: // epoch-based date/time
+			switch secs := v.(type) {
+			case uint64:
+				return time.Unix(int64(secs), 0), nil
+			case int64:
+				return time.Unix(secs, 0), nil
+			case float64:
+				whole, frac := math.Modf(secs)
+				return time.Unix(int64(whole), int64(frac*1e9)), nil
+			}
+			return nil, errInvalidCBOR
+		}
+
+		return v, nil
+
+	default: // cborSimple
+		switch info {
+		case 20:
+			return false, nil
+		case 21:
+			return true, nil
+		case 22, 23:
+			return nil, nil
+		case 25:
+			return halfFloat(uint16(arg)), nil
+		case 26:
+			return float64(math.Float32frombits(uint32(arg))), nil
+		case 27:
+			return math.Float64frombits(arg), nil
+		case indefinite:
+			// a break outside of an indefinite length item
+			return nil, errInvalidCBOR
+		}
+
+		return nil, nil
+	}
+}
+
+// halfFloat converts an IEEE 754 half-precision number.
+func halfFloat(h uint16) float64 {
+	exp := int(h>>10) & 0x1f
+	mant := float64(h & 0x3ff)
+
+	var v float64
+	switch exp {
+	case 0:
+		v = math.Ldexp(mant, -24)
+	case 31:
+		if mant == 0 {
+			v = math.Inf(1)
+		} else {
+			v = math.NaN()
+		}
+	default:
+		v = math.Ldexp(mant+1024, exp-25)
+	}
+
+	if h&0x8000 != 0 {
+		v = -v
+	}
+
+	return v
+}
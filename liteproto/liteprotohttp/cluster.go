@@ -0,0 +1,226 @@
+package liteprotohttp
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/drone/liteproto/liteproto"
+	"github.com/drone/liteproto/liteproto/transport"
+)
+
+const (
+	// ownerParam is the query parameter of a reply-to URL that names the replica awaiting the responses.
+	ownerParam = "owner"
+
+	// relayedHeader marks responses relayed from another replica. Its value is the signature
+	// of the response ID if the replicas share a secret (see WithClusterSecret).
+	relayedHeader = "Liteproto-Relayed"
+)
+
+// relaySigner signs the IDs of relayed responses with the cluster secret.
+type relaySigner struct {
+	secret []byte
+}
+
+// sign returns the value of the relayed header for the response with the ID.
+func (s *relaySigner) sign(id string) string {
+	if s.secret == nil {
+		return "true"
+	}
+
+	mac := hmac.New(sha256.New, s.secret)
+	_, _ = mac.Write([]byte(id))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// memberRefreshInterval is the time between two resolutions of the host names of the cluster members.
+const memberRefreshInterval = 30 * time.Second
+
+// clusterTrust decides whether a response marked with the relayed header comes from another replica.
+// Without a cluster secret it compares the remote address with the addresses of the members' hosts.
+// Host names are resolved in the background, not for every request.
+type clusterTrust struct {
+	signer relaySigner
+	names  []string            // host names of the members
+	ips    []net.IP            // literal addresses of the members
+	addrs  map[string][]net.IP // resolved addresses by host name
+	mx     sync.RWMutex
+
+	lookup func(ctx context.Context, host string) ([]net.IPAddr, error)
+	done   chan struct{}
+	once   sync.Once
+}
+
+func newClusterTrust(secret []byte, members map[string]string) *clusterTrust {
+	t := &clusterTrust{
+		signer: relaySigner{secret: secret},
+		lookup: net.DefaultResolver.LookupIPAddr,
+		done:   make(chan struct{}),
+	}
+
+	for _, member := range members {
+		u, err := url.Parse(member)
+		if err != nil || u.Hostname() == "" {
+			continue
+		}
+
+		if ip := net.ParseIP(u.Hostname()); ip != nil {
+			t.ips = append(t.ips, ip)
+		} else {
+			t.names = append(t.names, u.Hostname())
+		}
+	}
+
+	return t
+}
+
+// start resolves the host names of the members right away and then every interval, until stop is called.
+// With a cluster secret the addresses aren't needed and nothing is resolved.
+func (t *clusterTrust) start(interval time.Duration) {
+	if t.signer.secret != nil || len(t.names) == 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			t.resolve()
+
+			select {
+			case <-ticker.C:
+			case <-t.done:
+				return
+			}
+		}
+	}()
+}
+
+func (t *clusterTrust) stop() {
+	t.once.Do(func() { close(t.done) })
+}
+
+// resolve looks up the host names of the members. The addresses of a name that fails to resolve are kept.
+func (t *clusterTrust) resolve() {
+	ctx, cancel := context.WithTimeout(context.Background(), transport.ResolveTimeout)
+	defer cancel()
+
+	for _, name := range t.names {
+		resolved, err := t.lookup(ctx, name)
+		if err != nil {
+			continue
+		}
+
+		addrs := make([]net.IP, len(resolved))
+		for i, addr := range resolved {
+			addrs[i] = addr.IP
+		}
+
+		t.mx.Lock()
+		if t.addrs == nil {
+			t.addrs = map[string][]net.IP{}
+		}
+		t.addrs[name] = addrs
+		t.mx.Unlock()
+	}
+}
+
+// relayed reports whether the request carries a response with the ID relayed by another replica.
+// With a cluster secret the header must hold the signature of the ID, otherwise the request
+// must come from an address of one of the members' hosts.
+func (t *clusterTrust) relayed(r *http.Request, id string) bool {
+	value := r.Header.Get(relayedHeader)
+	if t == nil || value == "" {
+		return false
+	}
+
+	if t.signer.secret != nil {
+		return hmac.Equal([]byte(value), []byte(t.signer.sign(id)))
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+
+	remote := net.ParseIP(host)
+	if remote == nil {
+		return false
+	}
+
+	for _, ip := range t.ips {
+		if ip.Equal(remote) {
+			return true
+		}
+	}
+
+	t.mx.RLock()
+	defer t.mx.RUnlock()
+
+	for _, addrs := range t.addrs {
+		for _, ip := range addrs {
+			if ip.Equal(remote) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// httpRelay forwards responses to other replicas with HTTP requests to their handlers.
+type httpRelay struct {
+	caller  *caller
+	members map[string]string
+}
+
+func newRelay(c *caller, members map[string]string, secret []byte) *httpRelay {
+	relayCaller := *c
+	relayCaller.relay = &relaySigner{secret: secret}
+
+	return &httpRelay{
+		caller:  &relayCaller,
+		members: members,
+	}
+}
+
+func (r *httpRelay) Relay(ctx context.Context, member string, response liteproto.TaskResponse) error {
+	u, ok := r.members[member]
+	if !ok {
+		return liteproto.ErrNotSubscribed
+	}
+
+	err := r.caller.Deliver(ctx, u, transport.ResponseEnvelope(response))
+	if e, ok := err.(CallFailedError); ok && e.StatusCode == http.StatusNotFound {
+		return liteproto.ErrNotSubscribed
+	}
+
+	return err
+}
+
+// withOwner adds the owner query parameter to a reply-to URL.
+func withOwner(replyTo, owner string) string {
+	if replyTo == "" || owner == "" {
+		return replyTo
+	}
+
+	u, err := url.Parse(replyTo)
+	if err != nil {
+		return replyTo
+	}
+
+	q := u.Query()
+	q.Set(ownerParam, owner)
+	u.RawQuery = q.Encode()
+
+	return u.String()
+}
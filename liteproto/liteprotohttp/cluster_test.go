@@ -0,0 +1,224 @@
+package liteprotohttp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/drone/liteproto/liteproto"
+	"github.com/drone/liteproto/liteproto/transport"
+)
+
+type repeatExecer struct {
+	n int
+}
+
+func (e repeatExecer) Exec(ctx context.Context, r liteproto.TaskRequest, rc liteproto.ResponderClient) {
+	for i := 0; i < e.n; i++ {
+		_ = rc.Respond(ctx, liteproto.StatusOK, []byte(`1`))
+	}
+}
+
+// cluster is a set of in-process replicas behind a "load balancer" that sends everything to replica b.
+type cluster struct {
+	replicas map[string]*ServerClient
+	servers  map[string]*httptest.Server
+	lb       *httptest.Server
+	worker   *ServerClient
+}
+
+func newCluster(t *testing.T, secret []byte) *cluster {
+	c := &cluster{
+		replicas: map[string]*ServerClient{},
+		servers:  map[string]*httptest.Server{},
+	}
+
+	members := map[string]string{}
+	for _, name := range []string{"a", "b", "c"} {
+		c.servers[name] = httptest.NewServer(nil)
+		members[name] = c.servers[name].URL
+	}
+
+	c.lb = httptest.NewServer(nil)
+
+	worker := httptest.NewServer(nil)
+	c.worker = New(c.lb.URL, false, nil, nil)
+	c.worker.RegisterWithResponder("build", repeatExecer{n: 3})
+	c.worker.RegisterWithResponder("hold", repeatExecer{})
+	worker.Config.Handler = c.worker.Handler()
+
+	for name, srv := range c.servers {
+		opts := []Option{WithCluster(name, members), WithClusterSecret(secret)}
+		if name == "a" {
+			opts = append(opts, WithReplyTo(c.lb.URL))
+		}
+		c.replicas[name] = New(worker.URL, false, nil, nil, opts...)
+		srv.Config.Handler = c.replicas[name].Handler()
+	}
+
+	c.lb.Config.Handler = c.replicas["b"].Handler()
+
+	t.Cleanup(func() {
+		for _, srv := range c.servers {
+			srv.Close()
+		}
+		c.lb.Close()
+		worker.Close()
+		for _, sc := range c.replicas {
+			_ = sc.Close()
+		}
+		_ = c.worker.Close()
+	})
+
+	return c
+}
+
+func TestClusterRelay(t *testing.T) {
+	for _, secret := range [][]byte{nil, []byte("cluster secret")} {
+		c := newCluster(t, secret)
+
+		// replica a advertises its name in the reply-to URL, so b relays the responses right to it,
+		// replica c doesn't, so b offers the responses to all replicas
+
+		for _, name := range []string{"a", "c"} {
+			response, stop, err := c.replicas[name].CallWithDeadline(context.Background(),
+				liteproto.TaskRequest{ID: "build-" + name, Type: "build"}, time.Now().Add(2*time.Second))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i := 0; i < 3; i++ {
+				if r, ok := <-response; !ok || r.Status != liteproto.StatusOK {
+					t.Fatalf("replica %s, secret %q: expected response %d, got %+v", name, secret, i, r)
+				}
+			}
+			close(stop)
+		}
+	}
+}
+
+// TestClusterForgedRelay checks that a response marked as relayed by someone who doesn't know
+// the cluster secret is still relayed to the replica that awaits it.
+func TestClusterForgedRelay(t *testing.T) {
+	secret := []byte("cluster secret")
+	c := newCluster(t, secret)
+
+	response, stop, err := c.replicas["c"].CallWithDeadline(context.Background(),
+		liteproto.TaskRequest{ID: "held", Type: "hold"}, time.Now().Add(2*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer close(stop)
+
+	post := func(relayed string) int {
+		var body bytes.Buffer
+		_ = jsoner{}.Encode(&body, transport.ResponseEnvelope(liteproto.TaskResponse{ID: "held", Type: "hold", Status: relayed}))
+
+		req, _ := http.NewRequest(http.MethodPost, c.servers["b"].URL, &body)
+		req.Header.Set("Content-Type", jsoner{}.ContentType())
+		req.Header.Set(relayedHeader, relayed)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+
+		return resp.StatusCode
+	}
+
+	forged := []string{"true", (&relaySigner{secret: []byte("other secret")}).sign("held")}
+	for _, relayed := range forged {
+		if status := post(relayed); status != http.StatusNoContent {
+			t.Errorf("%s: expected the response to be relayed, got status %d", relayed, status)
+		}
+	}
+
+	// a response relayed by another replica isn't relayed again
+	if status := post((&relaySigner{secret: secret}).sign("held")); status != http.StatusNotFound {
+		t.Errorf("expected a signed response to be refused, got status %d", status)
+	}
+
+	for _, relayed := range forged {
+		if r := <-response; r.Status != relayed {
+			t.Errorf("expected the forged response %s, got %+v", relayed, r)
+		}
+	}
+
+	select {
+	case r := <-response:
+		t.Errorf("unexpected response %+v", r)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// TestClusterTrustResolve checks that the host names of the members are resolved in the background,
+// not for every relayed response.
+func TestClusterTrustResolve(t *testing.T) {
+	var lookups int32
+
+	trust := newClusterTrust(nil, map[string]string{"a": "http://10.0.0.1:8080", "b": "http://replica-b:8080"})
+	trust.lookup = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		atomic.AddInt32(&lookups, 1)
+		if host != "replica-b" {
+			return nil, errors.New("unknown host")
+		}
+		return []net.IPAddr{{IP: net.ParseIP("10.0.0.2")}}, nil
+	}
+	trust.start(time.Hour)
+	defer trust.stop()
+
+	request := func(remote string) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/", nil)
+		r.RemoteAddr = remote + ":1234"
+		r.Header.Set(relayedHeader, "true")
+		return r
+	}
+
+	// wait for the first resolution
+	deadline := time.Now().Add(time.Second)
+	for !trust.relayed(request("10.0.0.2"), "1") {
+		if time.Now().After(deadline) {
+			t.Fatal("the member wasn't resolved")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	tests := []struct {
+		remote  string
+		relayed bool
+	}{
+		{"10.0.0.1", true},
+		{"10.0.0.2", true},
+		{"10.0.0.3", false},
+	}
+
+	for i := 0; i < 3; i++ {
+		for _, test := range tests {
+			if relayed := trust.relayed(request(test.remote), "1"); relayed != test.relayed {
+				t.Errorf("%s: expected relayed=%t, got %t", test.remote, test.relayed, relayed)
+			}
+		}
+	}
+
+	if n := atomic.LoadInt32(&lookups); n != 1 {
+		t.Errorf("expected the member to be resolved once, got %d lookups", n)
+	}
+
+	// with a secret nothing is resolved
+	signed := newClusterTrust([]byte("secret"), map[string]string{"b": "http://replica-b:8080"})
+	signed.lookup = trust.lookup
+	signed.start(time.Millisecond)
+	defer signed.stop()
+
+	time.Sleep(10 * time.Millisecond)
+	if n := atomic.LoadInt32(&lookups); n != 1 {
+		t.Errorf("expected no lookups with a secret, got %d", n-1)
+	}
+}
@@ -0,0 +1,171 @@
+package liteprotohttp
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/drone/liteproto/liteproto/transport"
+)
+
+// Codec encodes messages into HTTP request bodies and decodes them. Codecs are selected by the Content-Type
+// header: the handler decodes every request with the registered codec for its media type,
+// and a ServerClient encodes its messages with the codec passed to WithCodec, JSON by default.
+type Codec interface {
+	// ContentType returns the value of the Content-Type header of the encoded messages.
+	ContentType() string
+
+	// Encode writes the encoded envelope.
+	Encode(w io.Writer, e *transport.Envelope) error
+
+	// Decode reads an encoded envelope.
+	Decode(r io.Reader) (*transport.Envelope, error)
+}
+
+var (
+	codecs = map[string]Codec{
+		"application/json":         jsoner{},
+		"application/octet-stream": binaryer{},
+		"application/x-protobuf":   protobufer{},
+		"application/msgpack":      msgpacker{},
+		"application/x-msgpack":    msgpacker{},
+		"application/cbor":         cborer{},
+	}
+	codecsMx sync.RWMutex
+)
+
+// builtinCodecs are the media types of the codecs in codecs before any RegisterCodec call, they can't be replaced.
+var builtinCodecs = func() map[string]bool {
+	builtin := make(map[string]bool, len(codecs))
+	for t := range codecs {
+		builtin[t] = true
+	}
+	return builtin
+}()
+
+// RegisterCodec makes the handlers of all ServerClients accept messages encoded with the codec.
+// It's registered for the media type of its content type, replacing the codec registered before, if any.
+// It panics if the media type is one of the built-in codecs.
+func RegisterCodec(c Codec) {
+	t := mediaType(c.ContentType())
+	if builtinCodecs[t] {
+		panic("liteprotohttp: can't replace the built-in codec for " + t)
+	}
+
+	codecsMx.Lock()
+	codecs[t] = c
+	codecsMx.Unlock()
+}
+
+// LookupCodec returns the codec registered for the media type of the content type, or nil if there is none.
+// An empty content type means JSON, the built-in codecs are always used for their media types.
+func LookupCodec(contentType string) Codec {
+	if contentType == "" {
+		return jsoner{}
+	}
+
+	codecsMx.RLock()
+	defer codecsMx.RUnlock()
+
+	return codecs[mediaType(contentType)]
+}
+
+// mediaType returns the lower case media type of a content type, without parameters.
+func mediaType(contentType string) string {
+	if t, _, err := mime.ParseMediaType(contentType); err == nil {
+		return t
+	}
+
+	return strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+}
+
+// maxNesting limits nesting of values decoded by the schemaless codecs.
+const maxNesting = 32
+
+var errInvalidNesting = errors.New("values nested too deep")
+
+// envelopeFields is the map of fields of a message as decoded by the schemaless codecs,
+// MessagePack and CBOR. The keys are the same as the keys of JSON messages.
+type envelopeFields map[string]interface{}
+
+// envelope converts decoded fields to an envelope. Unknown fields are ignored.
+func (f envelopeFields) envelope() (*transport.Envelope, error) {
+	var e transport.Envelope
+	var ok bool
+
+	str := func(key string, dst *string) {
+		if v, exists := f[key]; exists && ok {
+			*dst, ok = v.(string)
+		}
+	}
+
+	ok = true
+	str("id", &e.ID)
+	str("type", &e.Type)
+	str("status", &e.Status)
+	str("reply_to", &e.ReplyTo)
+	if !ok {
+		return nil, errors.New("invalid message field")
+	}
+
+	switch data := f["data"].(type) {
+	case nil:
+	case []byte:
+		e.Data = data
+	case string:
+		e.Data = []byte(data)
+	default:
+		return nil, errors.New("invalid message data")
+	}
+
+	switch deadline := f["deadline"].(type) {
+	case nil:
+	case time.Time:
+		e.Deadline = deadline
+	default:
+		return nil, errors.New("invalid message deadline")
+	}
+
+	switch metadata := f["metadata"].(type) {
+	case nil:
+	case map[string]interface{}:
+		e.Metadata = make(map[string]string, len(metadata))
+		for k, v := range metadata {
+			s, ok := v.(string)
+			if !ok {
+				return nil, errors.New("invalid message metadata")
+			}
+			e.Metadata[k] = s
+		}
+	default:
+		return nil, errors.New("invalid message metadata")
+	}
+
+	return &e, nil
+}
+
+// fieldCount returns the number of fields of the envelope that are encoded by the schemaless codecs.
+func fieldCount(e *transport.Envelope) int {
+	n := 2 // id and type
+
+	for _, present := range []bool{e.Status != "", len(e.Data) > 0, !e.Deadline.IsZero(), e.ReplyTo != "", len(e.Metadata) > 0} {
+		if present {
+			n++
+		}
+	}
+
+	return n
+}
+
+// readAll reads the whole body of a message.
+func readAll(r io.Reader) ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(r); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,294 @@
+package liteprotohttp
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/drone/liteproto/liteproto"
+	"github.com/drone/liteproto/liteproto/transport"
+)
+
+var schemalessCodecs = []Codec{msgpacker{}, cborer{}}
+
+func testEnvelopes() []*transport.Envelope {
+	return []*transport.Envelope{
+		{ID: "a", Type: "t"},
+		{
+			ID:       "build-1",
+			Type:     "build",
+			Data:     []byte(`{"repo":"drone"}`),
+			Deadline: time.Date(2030, 1, 2, 3, 4, 5, 600, time.UTC),
+			ReplyTo:  "http://caller/" + strings.Repeat("p", 300),
+			Metadata: map[string]string{"trace": "1", "": "empty"},
+		},
+		{ID: "build-1", Type: "build", Status: liteproto.StatusOK, Data: bytes.Repeat([]byte{0, 0xff}, 70000)},
+	}
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	for _, codec := range append(schemalessCodecs, jsoner{}, binaryer{}, protobufer{}) {
+		for _, e := range testEnvelopes() {
+			var buf bytes.Buffer
+			if err := codec.Encode(&buf, e); err != nil {
+				t.Fatalf("%s: encode: %v", codec.ContentType(), err)
+			}
+
+			decoded, err := codec.Decode(&buf)
+			if err != nil {
+				t.Fatalf("%s: decode: %v", codec.ContentType(), err)
+			}
+
+			if !decoded.Deadline.Equal(e.Deadline) {
+				t.Errorf("%s: expected deadline %v, got %v", codec.ContentType(), e.Deadline, decoded.Deadline)
+			}
+			decoded.Deadline = e.Deadline
+
+			if !reflect.DeepEqual(decoded, e) {
+				t.Errorf("%s: expected %+v, got %+v", codec.ContentType(), e, decoded)
+			}
+		}
+	}
+}
+
+// TestCodecInterop decodes messages encoded by other implementations, with the encodings
+// that the codecs don't produce themselves.
+func TestCodecInterop(t *testing.T) {
+	tests := []struct {
+		codec    Codec
+		name     string
+		data     []byte
+		expected transport.Envelope
+	}{
+		{
+			codec:    msgpacker{},
+			name:     "fixmap",
+			data:     []byte("\x82\xa2id\xa1a\xa4type\xa1t"),
+			expected: transport.Envelope{ID: "a", Type: "t"},
+		},
+		{
+			codec:    msgpacker{},
+			name:     "map16, str8, data as str, timestamp 32",
+			data:     []byte("\xde\x00\x04\xd9\x02id\xa1a\xa4type\xa1t\xa4data\xa21}\xa8deadline\xd6\xff\x00\x00\x00\x01"),
+			expected: transport.Envelope{ID: "a", Type: "t", Data: []byte("1}"), Deadline: time.Unix(1, 0)},
+		},
+		{
+			codec:    msgpacker{},
+			name:     "timestamp 64, unknown fields",
+			data:     []byte("\x84\xa2id\xa1a\xa4type\xa1t\xa8deadline\xd7\xff\x00\x00\x00\x04\x00\x00\x00\x02\xa1x\x92\x01\xc0"),
+			expected: transport.Envelope{ID: "a", Type: "t", Deadline: time.Unix(2, 1)},
+		},
+		{
+			codec:    cborer{},
+			name:     "definite map, epoch deadline",
+			data:     []byte("\xa3\x62id\x61a\x64type\x61t\x68deadline\xc1\x01"),
+			expected: transport.Envelope{ID: "a", Type: "t", Deadline: time.Unix(1, 0)},
+		},
+		{
+			codec:    cborer{},
+			name:     "indefinite map and strings, float deadline",
+			data:     []byte("\xbf\x62id\x7f\x61a\x61b\xff\x64type\x61t\x64data\x5f\x41\x01\x41\x02\xff\x68deadline\xc1\xfb\x3f\xf8\x00\x00\x00\x00\x00\x00\xff"),
+			expected: transport.Envelope{ID: "ab", Type: "t", Data: []byte{1, 2}, Deadline: time.Unix(1, 5e8)},
+		},
+		{
+			codec:    cborer{},
+			name:     "string deadline, unknown fields",
+			data:     []byte("\xa4\x62id\x61a\x64type\x61t\x68deadline\xc0\x741970-01-01T00:00:03Z\x61x\xf9\x3c\x00"),
+			expected: transport.Envelope{ID: "a", Type: "t", Deadline: time.Unix(3, 0)},
+		},
+	}
+
+	for _, test := range tests {
+		e, err := test.codec.Decode(bytes.NewReader(test.data))
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		if !e.Deadline.Equal(test.expected.Deadline) {
+			t.Errorf("%s: expected deadline %v, got %v", test.name, test.expected.Deadline, e.Deadline)
+		}
+		e.Deadline = test.expected.Deadline
+
+		if !reflect.DeepEqual(*e, test.expected) {
+			t.Errorf("%s: expected %+v, got %+v", test.name, test.expected, *e)
+		}
+	}
+}
+
+func TestCodecTruncated(t *testing.T) {
+	for _, codec := range schemalessCodecs {
+		for _, e := range testEnvelopes() {
+			var buf bytes.Buffer
+			_ = codec.Encode(&buf, e)
+			data := buf.Bytes()
+
+			for n := 0; n < len(data); n += 1 + n/64 {
+				if _, err := codec.Decode(bytes.NewReader(data[:n])); err == nil {
+					t.Fatalf("%s: decoded %d of %d bytes", codec.ContentType(), n, len(data))
+				}
+			}
+		}
+	}
+}
+
+func TestCodecMalicious(t *testing.T) {
+	tests := []struct {
+		codec Codec
+		name  string
+		data  []byte
+	}{
+		{codec: msgpacker{}, name: "huge array", data: []byte("\xdd\xff\xff\xff\xff\xc0")},
+		{codec: msgpacker{}, name: "huge map", data: []byte("\xdf\xff\xff\xff\xff\xc0\xc0")},
+		{codec: msgpacker{}, name: "huge string", data: []byte("\xdb\xff\xff\xff\xffid")},
+		{codec: msgpacker{}, name: "deep nesting", data: append(bytes.Repeat([]byte{0x91}, 1000), 0xc0)},
+		{codec: msgpacker{}, name: "not a map", data: []byte("\x92\xa2id\xa1a")},
+		{codec: msgpacker{}, name: "trailing data", data: []byte("\x82\xa2id\xa1a\xa4type\xa1t\xc0")},
+		{codec: msgpacker{}, name: "invalid field type", data: []byte("\x82\xa2id\x01\xa4type\xa1t")},
+		{codec: msgpacker{}, name: "invalid timestamp", data: []byte("\x83\xa2id\xa1a\xa4type\xa1t\xa8deadline\xd5\xff\x00\x00")},
+		{codec: msgpacker{}, name: "unused byte", data: []byte("\xc1")},
+		{codec: cborer{}, name: "huge array", data: []byte("\x9b\xff\xff\xff\xff\xff\xff\xff\xff\xf6")},
+		{codec: cborer{}, name: "huge map", data: []byte("\xbb\xff\xff\xff\xff\xff\xff\xff\xff\xf6\xf6")},
+		{codec: cborer{}, name: "huge string", data: []byte("\x7b\xff\xff\xff\xff\xff\xff\xff\xffid")},
+		{codec: cborer{}, name: "deep nesting", data: append(bytes.Repeat([]byte{0x81}, 1000), 0xf6)},
+		{codec: cborer{}, name: "deep tags", data: append(bytes.Repeat([]byte{0xc6}, 1000), 0xf6)},
+		{codec: cborer{}, name: "unterminated indefinite map", data: []byte("\xbf\x62id\x61a")},
+		{codec: cborer{}, name: "nested indefinite string", data: []byte("\x7f\x7f\xff\xff")},
+		{codec: cborer{}, name: "mixed chunks", data: []byte("\xa1\x62id\x7f\x41a\xff")},
+		{codec: cborer{}, name: "stray break", data: []byte("\xff")},
+		{codec: cborer{}, name: "reserved info", data: []byte("\x1c")},
+		{codec: cborer{}, name: "invalid date", data: []byte("\xa3\x62id\x61a\x64type\x61t\x68deadline\xc0\x61x")},
+	}
+
+	for _, test := range tests {
+		if e, err := test.codec.Decode(bytes.NewReader(test.data)); err == nil {
+			t.Errorf("%s %s: expected an error, got %+v", test.codec.ContentType(), test.name, e)
+		}
+	}
+}
+
+func FuzzMsgpackDecode(f *testing.F) {
+	fuzzDecode(f, msgpacker{})
+}
+
+func FuzzCBORDecode(f *testing.F) {
+	fuzzDecode(f, cborer{})
+}
+
+// fuzzDecode checks that the decoder doesn't panic and that whatever it decodes survives a round trip.
+func fuzzDecode(f *testing.F, codec Codec) {
+	for _, e := range testEnvelopes()[:2] {
+		var buf bytes.Buffer
+		_ = codec.Encode(&buf, e)
+		f.Add(buf.Bytes())
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		e, err := codec.Decode(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+
+		var buf bytes.Buffer
+		if err = codec.Encode(&buf, e); err != nil {
+			t.Fatal(err)
+		}
+
+		decoded, err := codec.Decode(&buf)
+		if err != nil {
+			t.Fatalf("re-encoded message doesn't decode: %v", err)
+		}
+
+		if !decoded.Deadline.Equal(e.Deadline) {
+			t.Fatalf("expected deadline %v, got %v", e.Deadline, decoded.Deadline)
+		}
+		decoded.Deadline = e.Deadline
+
+		if len(decoded.Data) == 0 && len(e.Data) == 0 {
+			decoded.Data = e.Data
+		}
+		if len(decoded.Metadata) == 0 && len(e.Metadata) == 0 {
+			decoded.Metadata = e.Metadata
+		}
+
+		if !reflect.DeepEqual(decoded, e) {
+			t.Fatalf("expected %+v, got %+v", e, decoded)
+		}
+	})
+}
+
+func TestRegisterCodecBuiltin(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic")
+		}
+	}()
+
+	RegisterCodec(testCodec{jsoner{}, "application/json; charset=utf-8"})
+}
+
+type testCodec struct {
+	Codec
+	contentType string
+}
+
+func (c testCodec) ContentType() string {
+	return c.contentType
+}
+
+// TestReplyCodec checks that responses are sent in the encoding of the request
+// and that the reply-to address is left intact.
+func TestReplyCodec(t *testing.T) {
+	type received struct {
+		contentType string
+		url         string
+	}
+	responses := make(chan received, 10)
+
+	caller := httptest.NewServer(nil)
+	defer caller.Close()
+
+	server := New("http://unused", false, nil, nil, WithResultStore(liteproto.NewMemoryResultStore(10, 0, time.Minute)), WithReplyToValidator(AllowReplyTo(caller.URL)))
+	server.RegisterWithResponder("build", respondExecer{})
+	srv := httptest.NewServer(server.Handler())
+	defer srv.Close()
+
+	client := New(srv.URL, false, nil, nil, WithCodec(cborer{}), WithReplyTo(caller.URL+"/replies?x=1"))
+	handler := client.Handler()
+	caller.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		responses <- received{contentType: r.Header.Get("Content-Type"), url: r.URL.String()}
+		handler.ServeHTTP(w, r)
+	})
+
+	for _, call := range []liteproto.TaskRequest{
+		{ID: "1", Type: "build"},
+		{ID: "1", Type: liteproto.TypeReplay},
+	} {
+		response, stop, err := client.CallWithDeadline(context.Background(), call, time.Now().Add(time.Second))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r, ok := <-response; !ok || r.Status != liteproto.StatusOK {
+			t.Fatalf("%s: expected a response, got %+v", call.Type, r)
+		}
+		close(stop)
+		for range response {
+		}
+
+		got := <-responses
+		if got.contentType != (cborer{}).ContentType() || got.url != "/replies?x=1" {
+			t.Errorf("%s: expected a cbor response to /replies?x=1, got %+v", call.Type, got)
+		}
+	}
+}
+
+type respondExecer struct{}
+
+func (respondExecer) Exec(ctx context.Context, r liteproto.TaskRequest, rc liteproto.ResponderClient) {
+	_ = rc.Respond(ctx, liteproto.StatusOK, []byte(`1`))
+}
@@ -0,0 +1,264 @@
+package liteprotohttp
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression is a content encoding of HTTP request bodies.
+type Compression string
+
+const (
+	CompressionNone    Compression = ""
+	CompressionGzip    Compression = "gzip"
+	CompressionDeflate Compression = "deflate"
+	CompressionZstd    Compression = "zstd"
+)
+
+// supportedCompressions are the encodings the handler decodes, from the most preferred.
+// The handler advertises them in the Accept-Encoding header of every response.
+var supportedCompressions = []Compression{CompressionZstd, CompressionGzip, CompressionDeflate}
+
+var acceptEncoding = "zstd, gzip, deflate"
+
+var errUnsupportedEncoding = errors.New("unsupported content encoding")
+
+var errBodyTooLarge = errors.New("request body too large")
+
+// maxZstdWindow limits the memory a zstd stream can make the decoder allocate.
+const maxZstdWindow = 32 << 20
+
+// compression holds the compression setting of a caller.
+type compression struct {
+	preferred Compression
+	minSize   int
+	level     int
+}
+
+// encodings remembers the content encodings remote handlers accept, keyed by URL.
+// Until a remote handler responds, only gzip is assumed, since all versions of the handler accept it.
+type encodings struct {
+	accepted map[string][]Compression
+	mx       sync.RWMutex
+}
+
+// learn parses the Accept-Encoding header of a response of the remote handler.
+func (c *encodings) learn(url string, header http.Header) {
+	value := header.Get("Accept-Encoding")
+	if value == "" {
+		return
+	}
+
+	var accepted []Compression
+	for _, part := range strings.Split(value, ",") {
+		name := strings.ToLower(strings.TrimSpace(strings.Split(part, ";")[0]))
+		accepted = append(accepted, Compression(name))
+	}
+
+	c.mx.Lock()
+	if c.accepted == nil {
+		c.accepted = map[string][]Compression{}
+	}
+	c.accepted[url] = accepted
+	c.mx.Unlock()
+}
+
+// choose returns the encoding to use for a request to the URL: the preferred one if the remote handler
+// accepts it, otherwise the most preferred encoding both sides support.
+func (c *encodings) choose(url string, preferred Compression) Compression {
+	if preferred == CompressionNone {
+		return CompressionNone
+	}
+
+	c.mx.RLock()
+	accepted, known := c.accepted[url]
+	c.mx.RUnlock()
+
+	if !known {
+		return CompressionGzip
+	}
+
+	contains := func(e Compression) bool {
+		for _, a := range accepted {
+			if a == e {
+				return true
+			}
+		}
+		return false
+	}
+
+	if contains(preferred) {
+		return preferred
+	}
+
+	for _, e := range supportedCompressions {
+		if contains(e) {
+			return e
+		}
+	}
+
+	return CompressionNone
+}
+
+var (
+	zstdEncoders   = map[int]*zstd.Encoder{}
+	zstdEncodersMx sync.Mutex
+
+	zstdDecoders sync.Pool
+)
+
+// compress writes the compressed data to the buffer.
+func compress(dst *bytes.Buffer, data []byte, encoding Compression, level int) error {
+	if encoding == CompressionZstd {
+		zstdEncodersMx.Lock()
+		enc, ok := zstdEncoders[level]
+		if !ok {
+			var err error
+			if enc, err = zstd.NewWriter(nil, zstd.WithEncoderLevel(zstdLevel(level))); err != nil {
+				zstdEncodersMx.Unlock()
+				return err
+			}
+			zstdEncoders[level] = enc
+		}
+		zstdEncodersMx.Unlock()
+
+		dst.Write(enc.EncodeAll(data, nil))
+		return nil
+	}
+
+	w, err := compressWriter(dst, encoding, level)
+	if err != nil {
+		return err
+	}
+
+	if _, err = w.Write(data); err != nil {
+		return err
+	}
+
+	return w.Close()
+}
+
+// compressWriter returns a writer that compresses the data written to it, for streamed bodies.
+// The writer must be closed to flush the compressed data.
+func compressWriter(dst io.Writer, encoding Compression, level int) (io.WriteCloser, error) {
+	switch encoding {
+	case CompressionGzip:
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		return gzip.NewWriterLevel(dst, level)
+
+	case CompressionDeflate:
+		if level == 0 {
+			level = flate.DefaultCompression
+		}
+		return zlib.NewWriterLevel(dst, level)
+
+	case CompressionZstd:
+		return zstd.NewWriter(dst, zstd.WithEncoderLevel(zstdLevel(level)), zstd.WithEncoderConcurrency(1))
+	}
+
+	return nopWriteCloser{dst}, nil
+}
+
+func zstdLevel(level int) zstd.EncoderLevel {
+	if level == 0 {
+		return zstd.SpeedDefault
+	}
+	return zstd.EncoderLevelFromZstd(level)
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// decompress returns a reader of the decompressed request body.
+func decompress(body io.Reader, encoding string) (io.ReadCloser, error) {
+	switch Compression(strings.ToLower(strings.TrimSpace(encoding))) {
+	case CompressionNone, "identity":
+		return io.NopCloser(body), nil
+	case CompressionGzip:
+		return gzip.NewReader(body)
+	case CompressionDeflate:
+		return zlib.NewReader(body)
+	case CompressionZstd:
+		dec, _ := zstdDecoders.Get().(*zstd.Decoder)
+		if dec == nil {
+			var err error
+			if dec, err = zstd.NewReader(nil, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxWindow(maxZstdWindow)); err != nil {
+				return nil, err
+			}
+		}
+
+		if err := dec.Reset(body); err != nil {
+			zstdDecoders.Put(dec)
+			return nil, err
+		}
+
+		return &zstdReader{dec}, nil
+	}
+
+	return nil, errUnsupportedEncoding
+}
+
+// zstdReader returns the pooled decoder when closed.
+type zstdReader struct {
+	dec *zstd.Decoder
+}
+
+func (r *zstdReader) Read(p []byte) (int, error) {
+	if r.dec == nil {
+		return 0, io.ErrClosedPipe
+	}
+	return r.dec.Read(p)
+}
+
+func (r *zstdReader) Close() error {
+	if r.dec != nil {
+		_ = r.dec.Reset(nil)
+		zstdDecoders.Put(r.dec)
+		r.dec = nil
+	}
+	return nil
+}
+
+// limitedReader fails with errBodyTooLarge when more than n bytes are read.
+type limitedReader struct {
+	rc io.ReadCloser
+	n  int64
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	if r.n < 0 {
+		return 0, errBodyTooLarge
+	}
+
+	if int64(len(p)) > r.n+1 {
+		p = p[:r.n+1]
+	}
+
+	n, err := r.rc.Read(p)
+	r.n -= int64(n)
+	if r.n < 0 {
+		return n, errBodyTooLarge
+	}
+
+	return n, err
+}
+
+func (r *limitedReader) Close() error {
+	return r.rc.Close()
+}
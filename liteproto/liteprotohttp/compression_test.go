@@ -0,0 +1,100 @@
+package liteprotohttp
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/drone/liteproto/liteproto"
+)
+
+func TestChooseEncoding(t *testing.T) {
+	tests := []struct {
+		name      string
+		advertise string // the Accept-Encoding header of the remote handler; empty means not learned
+		preferred Compression
+		expected  Compression
+	}{
+		{"unknown handler", "", CompressionZstd, CompressionGzip},
+		{"no compression", "zstd, gzip, deflate", CompressionNone, CompressionNone},
+		{"preferred accepted", "zstd, gzip, deflate", CompressionDeflate, CompressionDeflate},
+		{"preferred not accepted", "gzip, deflate", CompressionZstd, CompressionGzip},
+		{"best common", "deflate, zstd", CompressionGzip, CompressionZstd},
+		{"parameters and case", "GZIP;q=1.0, Deflate;q=0.5", CompressionDeflate, CompressionDeflate},
+		{"nothing in common", "br", CompressionGzip, CompressionNone},
+	}
+
+	for _, test := range tests {
+		c := &encodings{}
+		if test.advertise != "" {
+			c.learn("http://remote", http.Header{"Accept-Encoding": {test.advertise}})
+		}
+
+		if got := c.choose("http://remote", test.preferred); got != test.expected {
+			t.Errorf("%s: expected %q, got %q", test.name, test.expected, got)
+		}
+	}
+}
+
+// TestEncodingNegotiation checks that a caller learns the encodings the remote handler accepts from its responses.
+func TestEncodingNegotiation(t *testing.T) {
+	tests := []struct {
+		name      string
+		advertise string // empty means the handler of this package
+		preferred Compression
+		minSize   int
+		expected  []Compression // content encodings of the consecutive calls
+	}{
+		{"handler accepts zstd", "", CompressionZstd, 0, []Compression{CompressionGzip, CompressionZstd, CompressionZstd}},
+		{"handler accepts deflate", "", CompressionDeflate, 0, []Compression{CompressionGzip, CompressionDeflate}},
+		{"other handler without zstd", "gzip, deflate", CompressionZstd, 0, []Compression{CompressionGzip, CompressionGzip}},
+		{"other handler with deflate only", "deflate", CompressionZstd, 0, []Compression{CompressionGzip, CompressionDeflate}},
+		{"small bodies", "", CompressionZstd, 1 << 20, []Compression{CompressionNone, CompressionNone}},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			received := make(chan Compression, len(test.expected))
+
+			var handler http.Handler
+			if test.advertise == "" {
+				server := New("http://unused", false, nil, nil)
+				server.RegisterWithResponder("build", nopExecer{})
+				handler = server.Handler()
+			} else {
+				handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.Header().Set("Accept-Encoding", test.advertise)
+					w.WriteHeader(http.StatusNoContent)
+				})
+			}
+
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				received <- Compression(r.Header.Get("Content-Encoding"))
+				handler.ServeHTTP(w, r)
+			}))
+			defer srv.Close()
+
+			client := New(srv.URL, false, nil, nil, WithCompression(test.preferred, test.minSize, 0))
+
+			var got []string
+			for range test.expected {
+				if err := client.Call(context.Background(), liteproto.TaskRequest{ID: "1", Type: "build", Data: []byte(`"payload"`)}); err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, string(<-received))
+			}
+
+			expected := make([]string, len(test.expected))
+			for i, e := range test.expected {
+				expected[i] = string(e)
+			}
+
+			if strings.Join(got, ",") != strings.Join(expected, ",") {
+				t.Errorf("expected encodings %q, got %q", expected, got)
+			}
+		})
+	}
+}
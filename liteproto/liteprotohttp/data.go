@@ -1,44 +1,146 @@
 package liteprotohttp
 
 import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"io"
 	"time"
+
+	"github.com/drone/liteproto/liteproto/transport"
 )
 
 // message is used to form request body for all HTTP requests.
 type message struct {
-	ID       string          `json:"id"`
-	Type     string          `json:"type"`
-	Status   string          `json:"status,omitempty"` // status is used only for response messages
-	Data     json.RawMessage `json:"data"`
-	Deadline *time.Time      `json:"deadline,omitempty"` // deadline is used only for request messages
-}
+	ID       string            `json:"id"`
+	Type     string            `json:"type"`
+	Status   string            `json:"status,omitempty"` // status is used only for response messages
+	Data     json.RawMessage   `json:"data"`
+	Deadline *time.Time        `json:"deadline,omitempty"` // deadline is used only for request messages
+	ReplyTo  string            `json:"reply_to,omitempty"` // reply-to is used only for request messages
+	Metadata map[string]string `json:"metadata,omitempty"` // metadata is used only for request messages
 
-type nopCloseWriter struct {
-	io.Writer
+	// DataEncoding is "base64" if Data isn't JSON, but a base64 encoded JSON string of the binary payload.
+	DataEncoding string `json:"data_encoding,omitempty"`
 }
 
-func (w nopCloseWriter) Write(data []byte) (int, error) {
-	return w.Writer.Write(data)
+// dataEncodingBase64 is the value of DataEncoding of messages with binary payload.
+const dataEncodingBase64 = "base64"
+
+// MarshalJSON encodes the message. Data is embedded as is only if encoding/json passes it
+// through unchanged, other Data is encoded as a base64 string. Empty Data is encoded as null.
+func (m *message) MarshalJSON() ([]byte, error) {
+	type plain message
+
+	c := *m
+
+	switch {
+	case len(m.Data) == 0:
+		c.Data = nil
+		return json.Marshal((*plain)(&c))
+	case embeddable(m.Data):
+		return json.Marshal((*plain)(&c))
+	}
+
+	c.Data = json.RawMessage(`"` + base64.StdEncoding.EncodeToString(m.Data) + `"`)
+	c.DataEncoding = dataEncodingBase64
+
+	return json.Marshal((*plain)(&c))
 }
 
-func (nopCloseWriter) Close() error {
-	return nil
+// embeddable reports whether the payload survives embedding in a JSON message unchanged:
+// encoding/json compacts embedded JSON and escapes HTML characters and line separators,
+// and null stands for empty Data.
+func embeddable(data []byte) bool {
+	if !json.Valid(data) || bytes.Equal(data, []byte("null")) {
+		return false
+	}
+
+	if bytes.ContainsAny(data, "<>&\u2028\u2029") {
+		return false
+	}
+
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, data); err != nil {
+		return false
+	}
+
+	return bytes.Equal(buf.Bytes(), data)
 }
 
-func wrapWriter(wc io.WriteCloser, f func(writer io.Writer) error) error {
-	if err := f(wc); err != nil {
+// UnmarshalJSON decodes the message and its base64 encoded binary payload.
+func (m *message) UnmarshalJSON(data []byte) error {
+	type plain message
+
+	if err := json.Unmarshal(data, (*plain)(m)); err != nil {
 		return err
 	}
-	if err := wc.Close(); err != nil {
-		return err
+
+	switch m.DataEncoding {
+	case "":
+		if bytes.Equal(m.Data, []byte("null")) {
+			m.Data = nil
+		}
+		return nil
+	case dataEncodingBase64:
+		var s string
+		if err := json.Unmarshal(m.Data, &s); err != nil {
+			return err
+		}
+
+		payload, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return err
+		}
+
+		m.Data = payload
+		m.DataEncoding = ""
+
+		return nil
+	default:
+		return errors.New("unknown data encoding: " + m.DataEncoding)
+	}
+}
+
+func newMessage(e *transport.Envelope) *message {
+	m := &message{
+		ID:       e.ID,
+		Type:     e.Type,
+		Status:   e.Status,
+		Data:     e.Data,
+		ReplyTo:  e.ReplyTo,
+		Metadata: e.Metadata,
+	}
+
+	if !e.Deadline.IsZero() {
+		deadline := e.Deadline
+		m.Deadline = &deadline
 	}
-	return nil
+
+	return m
+}
+
+func (m *message) envelope() *transport.Envelope {
+	e := &transport.Envelope{
+		ID:       m.ID,
+		Type:     m.Type,
+		Status:   m.Status,
+		Data:     m.Data,
+		ReplyTo:  m.ReplyTo,
+		Metadata: m.Metadata,
+	}
+
+	if m.Deadline != nil {
+		e.Deadline = *m.Deadline
+	}
+
+	return e
 }
 
-func wrapReader(rc io.ReadCloser, f func(reader io.Reader) (*message, error)) (m *message, err error) {
-	if m, err = f(rc); err != nil {
+func wrapReader(rc io.ReadCloser, f func(reader io.Reader) (*transport.Envelope, error)) (e *transport.Envelope, err error) {
+	if e, err = f(rc); err != nil {
 		return
 	}
 	if err = rc.Close(); err != nil {
@@ -47,18 +149,18 @@ func wrapReader(rc io.ReadCloser, f func(reader io.Reader) (*message, error)) (m
 	return
 }
 
-type messageMarshaller interface {
-	messageMarshal(writer io.Writer, m *message) error
-	messageUnmarshal(reader io.Reader) (*message, error)
-}
-
+// jsoner encodes messages as application/json, the default encoding.
 type jsoner struct{}
 
-func (jsoner) messageMarshal(w io.Writer, m *message) error {
-	return json.NewEncoder(w).Encode(m)
+func (jsoner) ContentType() string {
+	return "application/json; charset=utf-8"
 }
 
-func (jsoner) messageUnmarshal(r io.Reader) (*message, error) {
+func (jsoner) Encode(w io.Writer, e *transport.Envelope) error {
+	return json.NewEncoder(w).Encode(newMessage(e))
+}
+
+func (jsoner) Decode(r io.Reader) (*transport.Envelope, error) {
 	var m message
 
 	err := json.NewDecoder(r).Decode(&m)
@@ -66,5 +168,96 @@ func (jsoner) messageUnmarshal(r io.Reader) (*message, error) {
 		return nil, err
 	}
 
-	return &m, nil
+	return m.envelope(), nil
+}
+
+// binaryer encodes messages as application/octet-stream: a 4-byte big-endian length of a JSON header,
+// the header, which is the message without data, and then the raw data up to the end of the body.
+type binaryer struct{}
+
+// maxBinaryHeader is the maximum size of the JSON header of a binary message.
+const maxBinaryHeader = 1 << 20
+
+func (binaryer) ContentType() string {
+	return "application/octet-stream"
+}
+
+func (binaryer) Encode(w io.Writer, e *transport.Envelope) error {
+	if err := writeBinaryHeader(w, e); err != nil {
+		return err
+	}
+
+	_, err := w.Write(e.Data)
+	return err
+}
+
+func (binaryer) Decode(r io.Reader) (*transport.Envelope, error) {
+	e, err := readBinaryHeader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if _, err = buf.ReadFrom(r); err != nil {
+		return nil, err
+	}
+
+	if buf.Len() > 0 {
+		e.Data = buf.Bytes()
+	}
+
+	return e, nil
+}
+
+// writeBinaryHeader writes the length and the JSON header of a binary message.
+func writeBinaryHeader(w io.Writer, e *transport.Envelope) error {
+	type plain message
+
+	header := *newMessage(e)
+	header.Data = nil
+
+	data, err := json.Marshal((*plain)(&header))
+	if err != nil {
+		return err
+	}
+
+	var size [4]byte
+	binary.BigEndian.PutUint32(size[:], uint32(len(data)))
+
+	if _, err = w.Write(size[:]); err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+	return err
+}
+
+// readBinaryHeader reads the length and the JSON header of a binary message.
+// The returned envelope has no data, the rest of the reader is the data.
+func readBinaryHeader(r io.Reader) (*transport.Envelope, error) {
+	type plain message
+
+	var size [4]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return nil, err
+	}
+
+	n := binary.BigEndian.Uint32(size[:])
+	if n > maxBinaryHeader {
+		return nil, errors.New("binary message header too large")
+	}
+
+	header := make([]byte, n)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+
+	var m message
+	if err := json.Unmarshal(header, (*plain)(&m)); err != nil {
+		return nil, err
+	}
+
+	m.Data = nil
+
+	return m.envelope(), nil
 }
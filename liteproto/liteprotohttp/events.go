@@ -0,0 +1,474 @@
+package liteprotohttp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/drone/liteproto/liteproto/transport"
+)
+
+var (
+	errEventClientID       = errors.New("invalid event stream client ID")
+	errTooManyEventClients = errors.New("too many event stream clients")
+)
+
+const (
+	// eventStreamPrefix marks reply-to addresses of callers that receive responses over an SSE stream.
+	// The rest of the address is the client ID.
+	eventStreamPrefix = "sse:"
+
+	// clientParam is the query parameter of an SSE stream request with the client ID.
+	clientParam = "client"
+
+	defaultEventBuffer    = 1000
+	defaultEventRetention = 5 * time.Minute
+	defaultEventClients   = 10000
+
+	// maxEventClientID limits the length of a client ID.
+	maxEventClientID = 256
+
+	// eventHeartbeat is the interval of comments sent over idle streams, so proxies don't close them.
+	eventHeartbeat = 15 * time.Second
+)
+
+// eventHub buffers responses for callers that receive them over SSE streams.
+// Every client has its own sequence of event IDs, so a reconnecting stream can continue
+// after the last event it received (Last-Event-ID header).
+type eventHub struct {
+	size         int
+	retention    time.Duration
+	maxClients   int
+	authenticate func(r *http.Request, clientID string) error
+
+	clients map[string]*eventClient
+	mx      sync.Mutex
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+type eventClient struct {
+	events   []event
+	seq      uint64
+	changed  chan struct{} // closed and replaced whenever an event is added
+	streams  int
+	lastSeen time.Time
+}
+
+type event struct {
+	id   uint64
+	data []byte
+}
+
+func newEventHub(size int, retention time.Duration, maxClients int, authenticate func(r *http.Request, clientID string) error) *eventHub {
+	if size <= 0 {
+		size = defaultEventBuffer
+	}
+
+	if retention <= 0 {
+		retention = defaultEventRetention
+	}
+
+	if maxClients <= 0 {
+		maxClients = defaultEventClients
+	}
+
+	hub := &eventHub{
+		size:         size,
+		retention:    retention,
+		maxClients:   maxClients,
+		authenticate: authenticate,
+		clients:      map[string]*eventClient{},
+		stop:         make(chan struct{}),
+	}
+
+	// drop the buffers of clients that are gone even if no new clients come
+
+	hub.wg.Add(1)
+	go func() {
+		defer hub.wg.Done()
+
+		ticker := time.NewTicker(retention / 2)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-hub.stop:
+				return
+			case <-ticker.C:
+				hub.mx.Lock()
+				hub.expire(time.Now())
+				hub.mx.Unlock()
+			}
+		}
+	}()
+
+	return hub
+}
+
+// close stops dropping of expired buffers.
+func (hub *eventHub) close() {
+	hub.stopOnce.Do(func() { close(hub.stop) })
+	hub.wg.Wait()
+}
+
+// Deliver buffers the response for the client. This method implements transport.Delivery interface.
+func (hub *eventHub) Deliver(_ context.Context, addr string, e *transport.Envelope) error {
+	data, err := json.Marshal(newMessage(e))
+	if err != nil {
+		return err
+	}
+
+	hub.mx.Lock()
+	defer hub.mx.Unlock()
+
+	c, err := hub.client(strings.TrimPrefix(addr, eventStreamPrefix))
+	if err != nil {
+		return err
+	}
+
+	c.seq++
+	c.events = append(c.events, event{id: c.seq, data: data})
+	if len(c.events) > hub.size {
+		c.events = append(c.events[:0:0], c.events[len(c.events)-hub.size:]...)
+	}
+
+	close(c.changed)
+	c.changed = make(chan struct{})
+
+	return nil
+}
+
+// client returns the buffer of the client, creating it if needed. Buffers of clients
+// that had no stream open during the retention period are dropped on the way.
+// It fails if the ID is invalid or if there are too many clients. It must be called with the mutex locked.
+func (hub *eventHub) client(id string) (*eventClient, error) {
+	now := time.Now()
+
+	c, ok := hub.clients[id]
+	if !ok {
+		if id == "" || len(id) > maxEventClientID {
+			return nil, errEventClientID
+		}
+
+		hub.expire(now)
+		if len(hub.clients) >= hub.maxClients {
+			return nil, errTooManyEventClients
+		}
+
+		c = &eventClient{changed: make(chan struct{})}
+		hub.clients[id] = c
+	}
+
+	c.lastSeen = now
+
+	return c, nil
+}
+
+// expire drops buffers of clients that had no stream open during the retention period.
+// It must be called with the mutex locked.
+func (hub *eventHub) expire(now time.Time) {
+	for id, c := range hub.clients {
+		if c.streams == 0 && now.Sub(c.lastSeen) > hub.retention {
+			delete(hub.clients, id)
+		}
+	}
+}
+
+// serve streams the responses for the client until the request is canceled.
+// With an authenticator the request must pass it, without one a client can have only one stream open,
+// so nobody can read the responses of a connected client.
+func (hub *eventHub) serve(w http.ResponseWriter, r *http.Request, id string) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	last, _ := strconv.ParseUint(r.Header.Get("Last-Event-ID"), 10, 64)
+
+	if hub.authenticate != nil {
+		if err := hub.authenticate(r, id); err != nil {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	hub.mx.Lock()
+	c, err := hub.client(id)
+	if err == errTooManyEventClients {
+		hub.mx.Unlock()
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		hub.mx.Unlock()
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if hub.authenticate == nil && c.streams > 0 {
+		hub.mx.Unlock()
+		http.Error(w, "client already connected", http.StatusConflict)
+		return
+	}
+	c.streams++
+	hub.mx.Unlock()
+
+	// the buffer isn't dropped while the stream is open
+	defer func() {
+		hub.mx.Lock()
+		c.streams--
+		c.lastSeen = time.Now()
+		hub.mx.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(eventHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		hub.mx.Lock()
+		if last > c.seq {
+			// the hub was restarted, the sequence started over
+			last = 0
+		}
+		var events []event
+		for _, e := range c.events {
+			if e.id > last {
+				events = append(events, e)
+			}
+		}
+		changed := c.changed
+		hub.mx.Unlock()
+
+		for _, e := range events {
+			if _, err := fmt.Fprintf(w, "id: %d\ndata: %s\n\n", e.id, e.data); err != nil {
+				return
+			}
+			last = e.id
+		}
+		if len(events) > 0 {
+			flusher.Flush()
+		}
+
+		select {
+		case <-changed:
+		case <-heartbeat.C:
+			if _, err := io.WriteString(w, ":\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// streamDelivery sends responses for SSE clients to the hub and everything else with HTTP.
+type streamDelivery struct {
+	hub  *eventHub
+	http transport.Delivery
+}
+
+func (d streamDelivery) Deliver(ctx context.Context, addr string, e *transport.Envelope) error {
+	if !e.IsRequest() && strings.HasPrefix(addr, eventStreamPrefix) {
+		return d.hub.Deliver(ctx, addr, e)
+	}
+
+	return d.http.Deliver(ctx, addr, e)
+}
+
+// eventReceiver keeps an SSE stream open to the remote server and passes the received responses
+// to the ServerClient.
+type eventReceiver struct {
+	client *http.Client
+	url    string
+	id     string
+	header http.Header
+	max    int64 // limits the size of an event, zero or negative means no limit
+	sc     *transport.ServerClient
+	logger *log.Logger
+	last   string
+
+	cancel func()
+	wg     sync.WaitGroup
+}
+
+func (er *eventReceiver) start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	er.cancel = cancel
+
+	er.wg.Add(1)
+	go func() {
+		defer er.wg.Done()
+		er.run(ctx)
+	}()
+}
+
+func (er *eventReceiver) stop() {
+	er.cancel()
+	er.wg.Wait()
+}
+
+func (er *eventReceiver) run(ctx context.Context) {
+	const minDelay, maxDelay = 100 * time.Millisecond, 10 * time.Second
+
+	delay := minDelay
+
+	for {
+		received, err := er.stream(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if received {
+			delay = minDelay
+		}
+
+		if err != nil {
+			er.logger.Printf("event stream from %s failed: %s", er.url, err.Error())
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+
+		delay *= 2
+		if delay > maxDelay {
+			delay = maxDelay
+		}
+	}
+}
+
+// stream reads one SSE stream until it breaks. It reports whether the stream was established.
+func (er *eventReceiver) stream(ctx context.Context) (bool, error) {
+	q := url.Values{}
+	q.Set(clientParam, er.id)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, withQuery(er.url, q), nil)
+	if err != nil {
+		return false, err
+	}
+
+	for k, v := range er.header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	if er.last != "" {
+		req.Header.Set("Last-Event-ID", er.last)
+	}
+
+	resp, err := er.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return false, CallFailedError{StatusCode: resp.StatusCode}
+	}
+
+	r := bufio.NewReader(resp.Body)
+
+	var id string
+	var data []byte
+	var tooLarge bool
+
+	for {
+		line, err := er.readLine(r)
+		if err == errBodyTooLarge {
+			tooLarge = true
+			continue
+		}
+		if err != nil {
+			return true, err
+		}
+
+		if !tooLarge && er.max > 0 && int64(len(data)+len(line)) > er.max {
+			tooLarge = true
+		}
+
+		switch {
+		case line == "":
+			if tooLarge {
+				er.logger.Printf("event %s from %s dropped: %s", id, er.url, errBodyTooLarge.Error())
+			} else if len(data) > 0 {
+				er.receive(ctx, data)
+			}
+			if id != "" {
+				er.last = id
+			}
+			id, data, tooLarge = "", nil, false
+		case strings.HasPrefix(line, "id:"):
+			id = strings.TrimSpace(line[len("id:"):])
+		case tooLarge:
+			// the rest of the event is skipped
+		case strings.HasPrefix(line, ":"):
+			// comment
+		case strings.HasPrefix(line, "data:"):
+			if data != nil {
+				data = append(data, '\n')
+			}
+			data = append(data, strings.TrimPrefix(line[len("data:"):], " ")...)
+		}
+	}
+}
+
+// readLine reads a line of the stream without the line ending. A line longer than the event size limit
+// is skipped, readLine returns errBodyTooLarge for it.
+func (er *eventReceiver) readLine(r *bufio.Reader) (string, error) {
+	var line []byte
+	var tooLarge bool
+
+	for {
+		chunk, err := r.ReadSlice('\n')
+		if !tooLarge {
+			if er.max > 0 && int64(len(line)+len(chunk)) > er.max {
+				tooLarge, line = true, nil
+			} else {
+				line = append(line, chunk...)
+			}
+		}
+
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		if tooLarge {
+			return "", errBodyTooLarge
+		}
+
+		return strings.TrimRight(string(line), "\r\n"), nil
+	}
+}
+
+func (er *eventReceiver) receive(ctx context.Context, data []byte) {
+	var m message
+	if err := json.Unmarshal(data, &m); err != nil {
+		er.logger.Printf("invalid event from %s: %s", er.url, err.Error())
+		return
+	}
+
+	// responses that nobody awaits are passed to the orphan handler by Receive
+	_ = er.sc.Receive(ctx, m.envelope())
+}
@@ -0,0 +1,175 @@
+package liteprotohttp
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/drone/liteproto/liteproto"
+	"github.com/drone/liteproto/liteproto/transport"
+)
+
+func openStream(t *testing.T, url, id string, header http.Header) *http.Response {
+	t.Helper()
+
+	req, _ := http.NewRequest(http.MethodGet, url+"?"+clientParam+"="+id, nil)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = resp.Body.Close() })
+
+	return resp
+}
+
+func TestEventStreamSingle(t *testing.T) {
+	server := New("http://unused", false, nil, nil, WithEventStreams(0, 0))
+	defer server.Close()
+
+	srv := httptest.NewServer(server.Handler())
+	t.Cleanup(srv.Close)
+
+	if resp := openStream(t, srv.URL, "caller", nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected the stream to open, got status %d", resp.StatusCode)
+	}
+
+	// nobody else can read the responses of a connected caller
+	if resp := openStream(t, srv.URL, "caller", nil); resp.StatusCode != http.StatusConflict {
+		t.Errorf("expected the second stream to be rejected, got status %d", resp.StatusCode)
+	}
+}
+
+func TestEventAuthenticator(t *testing.T) {
+	server := New("http://unused", false, nil, nil, WithEventStreams(0, 0),
+		WithEventAuthenticator(func(r *http.Request, clientID string) error {
+			if r.Header.Get("Authorization") != "Bearer "+clientID {
+				return errors.New("invalid token")
+			}
+			return nil
+		}))
+	defer server.Close()
+	server.RegisterWithResponder("build", respondExecer{})
+
+	srv := httptest.NewServer(server.Handler())
+	t.Cleanup(srv.Close)
+
+	if resp := openStream(t, srv.URL, "caller", nil); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected an unauthenticated stream to be rejected, got status %d", resp.StatusCode)
+	}
+
+	caller := New(srv.URL, false, nil, nil, WithEventStream("caller"),
+		WithEventStreamHeader(http.Header{"Authorization": {"Bearer caller"}}))
+	defer caller.Close()
+
+	response, stop, err := caller.CallWithDeadline(context.Background(), liteproto.TaskRequest{ID: "1", Type: "build"}, time.Now().Add(2*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer close(stop)
+
+	if r, ok := <-response; !ok || r.Status != liteproto.StatusOK {
+		t.Errorf("expected a response over the authenticated stream, got %+v", r)
+	}
+}
+
+func TestEventHubExpiry(t *testing.T) {
+	hub := newEventHub(0, 20*time.Millisecond, 0, nil)
+	defer hub.close()
+
+	_ = hub.Deliver(context.Background(), eventStreamPrefix+"gone", &transport.Envelope{ID: "1", Type: "t", Status: liteproto.StatusOK})
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		hub.mx.Lock()
+		n := len(hub.clients)
+		hub.mx.Unlock()
+
+		if n == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the buffer of a client without streams wasn't dropped")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestEventHubMaxClients(t *testing.T) {
+	server := New("http://unused", false, nil, nil, WithEventStreams(0, time.Minute), WithMaxEventClients(2))
+	defer server.Close()
+
+	srv := httptest.NewServer(server.Handler())
+	t.Cleanup(srv.Close)
+
+	tests := []struct {
+		id     string
+		status int
+	}{
+		{"a", http.StatusOK},
+		{strings.Repeat("x", maxEventClientID+1), http.StatusBadRequest},
+		{"b", http.StatusOK},
+		{"c", http.StatusServiceUnavailable},
+	}
+
+	for _, test := range tests {
+		if resp := openStream(t, srv.URL, test.id, nil); resp.StatusCode != test.status {
+			t.Errorf("%.10s: expected status %d, got %d", test.id, test.status, resp.StatusCode)
+		}
+	}
+
+	// responses to new callers are dropped as well
+	response := &transport.Envelope{ID: "1", Type: "t", Status: liteproto.StatusOK}
+	if err := server.hub.Deliver(context.Background(), eventStreamPrefix+"a", response); err != nil {
+		t.Errorf("expected a response to a buffered caller to be accepted, got %v", err)
+	}
+	if err := server.hub.Deliver(context.Background(), eventStreamPrefix+"c", response); err != errTooManyEventClients {
+		t.Errorf("expected errTooManyEventClients, got %v", err)
+	}
+}
+
+func TestEventReplyToValidator(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    []Option
+		allowed bool
+	}{
+		{"default", nil, true},
+		{"validator", []Option{WithReplyToValidator(func(replyTo string) error {
+			if replyTo != eventStreamPrefix+"caller" {
+				return errReplyToNotAllowed
+			}
+			return nil
+		})}, true},
+		{"validator rejects", []Option{WithReplyToValidator(AllowReplyTo("http://callers"))}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := New("http://unused", false, nil, nil, append(test.opts, WithEventStreams(0, 0))...)
+			defer server.Close()
+			server.RegisterWithResponder("build", nopExecer{})
+
+			srv := httptest.NewServer(server.Handler())
+			t.Cleanup(srv.Close)
+
+			caller := New(srv.URL, false, nil, nil, WithEventStream("caller"))
+			defer caller.Close()
+
+			err := caller.Call(context.Background(), liteproto.TaskRequest{ID: "1", Type: "build"})
+			if test.allowed && err != nil {
+				t.Errorf("expected no error, got %v", err)
+			}
+			if failed, ok := err.(CallFailedError); !test.allowed && (!ok || failed.StatusCode != http.StatusForbidden) {
+				t.Errorf("expected status %d, got %v", http.StatusForbidden, err)
+			}
+		})
+	}
+}
@@ -1,57 +1,106 @@
 package liteprotohttp
 
 import (
-	"compress/gzip"
-	"context"
+	"encoding/json"
+	"errors"
 	"io"
 	"net/http"
-	"strings"
-	"time"
+	"sync/atomic"
 
 	"github.com/drone/liteproto/liteproto"
-	"github.com/drone/liteproto/liteproto/internal"
+	"github.com/drone/liteproto/liteproto/transport"
 )
 
-func handler(f internal.Feeder, respPub internal.ResponsePub) http.Handler {
+func handler(h *ServerClient) http.Handler {
+	sc, o, queue, hub := h.sc, &h.opts, h.queue, h.hub
+
+	tooLarge := func(w http.ResponseWriter) {
+		atomic.AddUint64(&h.rejected, 1)
+		http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+	}
+
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		var err error
 
-		// select unmarshaler based on the Content-Type header
+		// tell the callers which content encodings the handler accepts
 
-		var unmarshaler messageMarshaller
-		contentType := r.Header.Get("Content-Type")
+		w.Header().Set("Accept-Encoding", acceptEncoding)
 
-		if contentType == "" || strings.HasPrefix(contentType, "application/json") {
-			unmarshaler = jsoner{}
-			// TODO Implement support for other content types.
-			//} else if strings.HasPrefix(contentType, "application/octet-stream") {
-			//	unmarshaler = nil
-		} else {
+		// callers open event streams and workers in pull mode fetch requests with GET,
+		// workers acknowledge the fetched requests with POST
+
+		if id := r.URL.Query().Get(clientParam); r.Method == http.MethodGet && id != "" {
+			if hub == nil {
+				http.Error(w, "event streams not enabled", http.StatusMethodNotAllowed)
+				return
+			}
+			hub.serve(w, r, id)
+			return
+		}
+
+		if r.Method == http.MethodGet {
+			fetch(queue, o.queueAuthorize, w, r)
+			return
+		}
+
+		if id := r.URL.Query().Get(leaseParam); id != "" {
+			ack(queue, o.queueAuthorize, w, r, id, r.URL.Query().Get(extendParam) != "")
+			return
+		}
+
+		// select codec based on the Content-Type header
+
+		codec := LookupCodec(r.Header.Get("Content-Type"))
+		if codec == nil {
 			w.WriteHeader(http.StatusNotAcceptable)
 			return
 		}
 
 		// handle compressed request body based on the Content-Encoding header
 
-		var rc io.ReadCloser
-		encoding := r.Header.Get("Content-Encoding")
+		// limit the size of the body before and after decompression
 
-		compressed := encoding == "gzip"
-		if compressed {
-			rc, err = gzip.NewReader(r.Body)
-			if err != nil {
-				http.Error(w, "invalid gzip header", http.StatusBadRequest)
+		if o.maxBody > 0 {
+			if r.ContentLength > o.maxBody {
+				tooLarge(w)
 				return
 			}
-		} else {
-			rc = io.NopCloser(r.Body)
+			r.Body = http.MaxBytesReader(w, r.Body, o.maxBody)
 		}
 
-		// read message
+		rc, err := decompress(r.Body, r.Header.Get("Content-Encoding"))
+		if err == errUnsupportedEncoding {
+			http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+			return
+		}
+		if isTooLarge(err) {
+			tooLarge(w)
+			return
+		}
+		if err != nil {
+			http.Error(w, "invalid compressed body", http.StatusBadRequest)
+			return
+		}
+
+		if o.maxDecoded > 0 {
+			rc = &limitedReader{rc: rc, n: o.maxDecoded}
+		}
+
+		// read message, the payload of a streamed message is read by the execer or the caller
 
-		m, err := wrapReader(rc, func(reader io.Reader) (*message, error) {
-			return unmarshaler.messageUnmarshal(reader)
-		})
+		streamed := r.Header.Get(streamHeader) != ""
+
+		var m *transport.Envelope
+		if streamed {
+			defer rc.Close()
+			m, err = readBinaryHeader(rc)
+		} else {
+			m, err = wrapReader(rc, codec.Decode)
+		}
+		if isTooLarge(err) {
+			tooLarge(w)
+			return
+		}
 		if err != nil {
 			http.Error(w, "invalid request body", http.StatusBadRequest)
 			return
@@ -67,22 +116,91 @@ func handler(f internal.Feeder, respPub internal.ResponsePub) http.Handler {
 			return
 		}
 
-		// process either task request or task response...
-		// field Status tells us if it's one or the other.
+		if m.IsRequest() {
+			if err = h.checkReplyTo(m.ReplyTo); err != nil {
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
+			}
+		}
 
-		if isRequest := m.Status == ""; isRequest {
-			var deadline time.Time
-			if m.Deadline != nil {
-				deadline = *m.Deadline
+		if limit, ok := o.typeLimits[m.Type]; ok && int64(len(m.Data)) > limit {
+			tooLarge(w)
+			return
+		}
+
+		var p *payload
+		if streamed {
+			var body io.ReadCloser = rc
+			if limit, ok := o.typeLimits[m.Type]; ok {
+				body = &limitedReader{rc: rc, n: limit}
 			}
 
-			err = f.Feed(context.Background(), liteproto.TaskRequest{ID: m.ID, Type: m.Type, Data: m.Data}, deadline)
-			if err == liteproto.ErrUnknownType {
-				http.Error(w, err.Error(), http.StatusBadRequest)
-				return
+			if m.IsRequest() && !h.streams.isStreamType(m.Type) {
+				// the execer expects the whole payload in Data
+				m.Data, err = io.ReadAll(body)
+				if isTooLarge(err) {
+					tooLarge(w)
+					return
+				}
+				if err != nil {
+					http.Error(w, "invalid request body", http.StatusBadRequest)
+					return
+				}
+			} else {
+				p = newPayload(body)
+				if !m.IsRequest() {
+					h.streams.putResponse(m.ID, p)
+				} else if !h.streams.putRequest(m.ID, p) {
+					http.Error(w, "duplicate streamed request", http.StatusConflict)
+					return
+				}
 			}
-		} else {
-			err = respPub.Publish(liteproto.TaskResponse{ID: m.ID, Type: m.Type, Status: m.Status, Data: m.Data})
+		}
+
+		// process either task request or task response...
+		// the owner and relayed hints are used only for responses.
+
+		relayed := !m.IsRequest() && h.cluster.relayed(r, m.ID)
+		if !m.IsRequest() {
+			m.Owner = r.URL.Query().Get(ownerParam)
+			m.Relayed = relayed
+		}
+
+		// responses to a request are sent in the encoding of the request
+
+		if m.IsRequest() && mediaType(codec.ContentType()) != mediaType(o.codec().ContentType()) {
+			m.Encoding = codec.ContentType()
+		}
+
+		err = sc.Receive(r.Context(), m)
+		if p != nil && err != nil {
+			h.streams.discard(m.ID, p)
+			p = nil
+		}
+
+		var validationErr *liteproto.ValidationError
+
+		switch {
+		case errors.As(err, &validationErr):
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			_ = json.NewEncoder(w).Encode(validationErr)
+			return
+		case err == liteproto.ErrUnknownType:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		case err == liteproto.ErrNoResults:
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		case err == liteproto.ErrNotSubscribed && relayed:
+			// the replica that relayed the response handles it as an orphan
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		case err == liteproto.ErrNotSubscribed && o.orphanStatus != 0:
+			http.Error(w, err.Error(), o.orphanStatus)
+			return
+		case err == liteproto.ErrNotSubscribed:
+			err = nil
 		}
 		if err != nil {
 			// TODO: Log the error
@@ -90,6 +208,27 @@ func handler(f internal.Feeder, respPub internal.ResponsePub) http.Handler {
 			return
 		}
 
+		if p != nil {
+			err = h.awaitPayload(r.Context(), m.ID, p)
+			switch {
+			case isTooLarge(err):
+				tooLarge(w)
+				return
+			case err == errPayloadNotRead:
+				http.Error(w, err.Error(), http.StatusRequestTimeout)
+				return
+			case err != nil:
+				http.Error(w, "invalid request body", http.StatusBadRequest)
+				return
+			}
+		}
+
 		w.WriteHeader(http.StatusNoContent)
 	})
 }
+
+// isTooLarge reports whether reading of the request body failed because of a size limit.
+func isTooLarge(err error) bool {
+	var maxBytesErr *http.MaxBytesError
+	return errors.As(err, &maxBytesErr) || errors.Is(err, errBodyTooLarge)
+}
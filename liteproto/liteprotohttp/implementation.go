@@ -4,27 +4,55 @@ import (
 	"context"
 	"log"
 	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/drone/liteproto/liteproto"
-	"github.com/drone/liteproto/liteproto/internal"
+	"github.com/drone/liteproto/liteproto/transport"
 )
 
 // ServerClient can function as a server and as a client. Requests and responses to a remote server
-// are sent with HTTP protocol. Message payload 'Data' must be JSON encoded (json.RawMessage).
+// are sent with HTTP protocol. Message payload 'Data' can be any byte slice: JSON payload is embedded
+// in the JSON message as is, other payload is base64 encoded. Other encodings can be selected with WithCodec.
+// It's built on top of transport.ServerClient, this package provides only HTTP framing and delivery.
 type ServerClient struct {
-	caller internal.Caller
-	pubsub internal.ResponsePubSub
-	runner *internal.Runner
-	sf     *internal.ServerFeeder
+	sc       *transport.ServerClient
+	codec    Codec
+	pubsub   *transport.PubSub
+	balancer *transport.Balancer
+	queue    *transport.Queue
+	hub      *eventHub
+	puller   *puller
+	events   *eventReceiver
+	streams  *streams
+	cluster  *clusterTrust
+	opts     options
+
+	allowReplyTo func(replyTo string) error // the default reply-to validator
+
+	peers   map[string]liteproto.Client
+	peersMx sync.RWMutex
+
+	rejected uint64
 }
 
 // New creates a new ServerClient. Parameter 'url' is a full URL to which client calls and server responses
-// will be directed. If bool parameter 'compress' is true, all HTTP request bodies will be gzipped and
-// "Content-Encoding: gzip" header will be added. The library automatically handles gzipped HTTP requests.
+// will be directed. Responses to requests that carry a reply-to URL (see WithReplyTo) are sent to that URL
+// instead. If bool parameter 'compress' is true, all HTTP request bodies will be gzipped and
+// "Content-Encoding: gzip" header will be added, other settings are available with WithCompression.
+// The library automatically handles compressed HTTP requests.
 // Parameters 'httpClient' and 'logger' can be nil. Default implementations will be used for those in that case.
 // Logger is used only for logging panics that occur during execution of tasks.
-func New(url string, compress bool, httpClient *http.Client, logger *log.Logger) *ServerClient {
+// Optional features can be enabled with the variadic 'opts' parameter.
+// Additional remote servers can be registered with AddPeer.
+func New(url string, compress bool, httpClient *http.Client, logger *log.Logger, opts ...Option) *ServerClient {
+	o := options{maxBody: DefaultMaxBody, maxDecoded: DefaultMaxDecoded}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
 	if httpClient == nil {
 		httpClient = http.DefaultClient
 	}
@@ -33,53 +61,239 @@ func New(url string, compress bool, httpClient *http.Client, logger *log.Logger)
 		logger = log.Default()
 	}
 
-	h := &ServerClient{}
+	h := &ServerClient{opts: o, streams: newStreams(), allowReplyTo: AllowReplyTo(o.configuredURLs(url)...)}
+
+	c := newCaller(httpClient, o.codec(), url, o.compressionFor(compress))
+
+	var delivery transport.Delivery = c
+
+	var balancer *transport.Balancer
+	if len(o.balanceURLs) > 0 || o.resolver != nil {
+		balancer = transport.NewBalancer(o.balanceURLs, o.balance, transport.PingDelivery(c))
+		if o.resolver != nil {
+			interval := o.resolveInterval
+			if interval <= 0 {
+				interval = 30 * time.Second
+			}
+			balancer.Watch(o.resolver, interval, logger)
+		}
+		balancer.Start()
+
+		delivery = &transport.BalancedDelivery{
+			Delivery:      c,
+			Balancer:      balancer,
+			EndpointError: endpointError,
+		}
+	}
+
+	var queue *transport.Queue
+	if o.queueLease > 0 {
+		queue = transport.NewQueue(o.queueLease)
+		delivery = pullDelivery{queue: queue, http: delivery}
+	}
+
+	var hub *eventHub
+	if o.eventStreams {
+		hub = newEventHub(o.eventBuffer, o.eventRetention, o.eventClients, o.eventAuthenticate)
+		delivery = streamDelivery{hub: hub, http: delivery}
+	}
 
-	marshaller := jsoner{}
+	pubsub := &transport.PubSub{
+		BufferSize:   o.bufferSize,
+		Overflow:     o.overflow,
+		BlockTimeout: o.blockTimeout,
+	}
 
-	c := newCaller(httpClient, marshaller, url, compress)
-	f := newResponderFactory(h, c)
+	var respPubSub transport.ResponsePubSub = pubsub
+	if o.clusterSelf != "" {
+		members := make([]string, 0, len(o.clusterMembers))
+		for member := range o.clusterMembers {
+			members = append(members, member)
+		}
+		sort.Strings(members)
 
-	sf := internal.NewServerFeeder(f, logger)
-	pubsub := &internal.PubSub{}
-	runner := internal.NewRunner(c, pubsub)
+		respPubSub = transport.NewClusterPubSub(pubsub, o.clusterSelf, members, newRelay(c, o.clusterMembers, o.clusterSecret))
+		h.cluster = newClusterTrust(o.clusterSecret, o.clusterMembers)
+		h.cluster.start(memberRefreshInterval)
+	}
 
-	h.caller = c
+	replyTo := withOwner(o.replyTo, o.clusterSelf)
+	if o.eventClientID != "" {
+		replyTo = eventStreamPrefix + o.eventClientID
+	}
+
+	h.sc = transport.NewServerClient(transport.Config{
+		Delivery:      delivery,
+		ReplyTo:       replyTo,
+		PubSub:        respPubSub,
+		ResultStore:   o.resultStore,
+		OrphanHandler: o.orphanHandler,
+		Logger:        logger,
+	})
+
+	h.codec = o.codec()
 	h.pubsub = pubsub
-	h.runner = runner
-	h.sf = sf
+	h.balancer = balancer
+	h.queue = queue
+	h.hub = hub
+
+	if o.pull {
+		wait := o.pullWait
+		if wait <= 0 {
+			wait = defaultPullWait
+		}
 
-	// make sure it implements ServerClient interface
+		h.puller = &puller{
+			client: httpClient,
+			url:    url,
+			queue:  o.pullQueue,
+			wait:   wait,
+			header: o.pullHeader,
+			sc:     h.sc,
+			logger: logger,
+
+			maxDecoded:   o.maxDecoded,
+			checkReplyTo: h.checkReplyTo,
+		}
+		h.puller.start()
+	}
+
+	if o.eventClientID != "" {
+		h.events = &eventReceiver{
+			client: httpClient,
+			url:    url,
+			id:     o.eventClientID,
+			header: o.eventHeader,
+			max:    o.maxDecoded,
+			sc:     h.sc,
+			logger: logger,
+		}
+		h.events.start()
+	}
+
+	// make sure it implements ServerClient, Resubscriber and SchemaServer interfaces
 	var e liteproto.ServerClient = h
-	_ = e
+	var rs liteproto.Resubscriber = h
+	var ss liteproto.SchemaServer = h
+	_, _, _ = e, rs, ss
 
 	return h
 }
 
 func (h *ServerClient) Register(t string, execer liteproto.Execer) {
-	h.sf.Register(t, execer)
+	h.sc.Register(t, execer)
 }
 
 func (h *ServerClient) RegisterWithResponder(t string, execer liteproto.ExecerWithResponder) {
-	h.sf.RegisterWithResponder(t, execer)
+	h.sc.RegisterWithResponder(t, execer)
+}
+
+// RegisterSchema attaches schemas to a task type. Requests with invalid payload are rejected
+// with 422 (Unprocessable Entity) and the JSON encoded liteproto.ValidationError in the body,
+// calls to a ServerClient of this package return the *liteproto.ValidationError.
+// It panics if a request schema is attached to a type registered with RegisterStream.
+func (h *ServerClient) RegisterSchema(t string, schemas liteproto.TypeSchemas) {
+	if schemas.Request != nil && !h.streams.addValidated(t) {
+		panic("liteprotohttp: can't validate requests of stream type " + t)
+	}
+
+	h.sc.RegisterSchema(t, schemas)
 }
 
 func (h *ServerClient) RegisterCatchAll(execer liteproto.ExecerWithResponder) {
-	h.sf.RegisterCatchAll(execer)
+	h.sc.RegisterCatchAll(execer)
 }
 
 func (h *ServerClient) CallWithResponse(ctx context.Context, r liteproto.TaskRequest) (response <-chan liteproto.TaskResponse, stop chan<- struct{}, err error) {
-	return h.runner.Run(ctx, r, time.Time{})
+	return h.sc.CallWithResponse(ctx, r)
 }
 
 func (h *ServerClient) CallWithDeadline(ctx context.Context, r liteproto.TaskRequest, deadline time.Time) (response <-chan liteproto.TaskResponse, stop chan<- struct{}, err error) {
-	return h.runner.Run(ctx, r, deadline)
+	return h.sc.CallWithDeadline(ctx, r, deadline)
 }
 
 func (h *ServerClient) Call(ctx context.Context, r liteproto.TaskRequest) (err error) {
-	return h.caller.Call(ctx, r, time.Time{})
+	return h.sc.Call(ctx, r)
+}
+
+// Resubscribe asks the remote server to replay the responses of the task with the provided ID.
+// The remote server must be created with WithResultStore option.
+func (h *ServerClient) Resubscribe(ctx context.Context, id string, from int, deadline time.Time) (response <-chan liteproto.TaskResponse, stop chan<- struct{}, err error) {
+	return h.sc.Resubscribe(ctx, id, from, deadline)
+}
+
+// DroppedResponses returns the number of received responses that were dropped
+// because the callers didn't read them fast enough.
+func (h *ServerClient) DroppedResponses() uint64 {
+	return h.pubsub.Dropped()
+}
+
+// Queue returns a client for calling workers that pull requests from the named queue.
+// The ServerClient must be created with WithQueue option, otherwise calls fail with liteproto.ErrUnknownPeer.
+func (h *ServerClient) Queue(name string) liteproto.Client {
+	if h.queue == nil {
+		return unknownPeer{}
+	}
+
+	return h.sc.ClientFor(h.queue, name)
+}
+
+// QueueLen returns the number of requests waiting in the named queue.
+func (h *ServerClient) QueueLen(name string) int {
+	if h.queue == nil {
+		return 0
+	}
+
+	return h.queue.Len(name)
+}
+
+// ResolveErr returns the error of the last call of the resolver set with WithResolver, nil if it succeeded
+// or if there is no resolver.
+func (h *ServerClient) ResolveErr() error {
+	if h.balancer == nil {
+		return nil
+	}
+
+	return h.balancer.ResolveErr()
+}
+
+// RejectedRequests returns the number of requests the handler rejected because they exceeded
+// the size limits (see WithBodyLimits and WithTypeLimit).
+func (h *ServerClient) RejectedRequests() uint64 {
+	return atomic.LoadUint64(&h.rejected)
+}
+
+// Close stops background activities of the ServerClient, such as health checks, endpoint discovery,
+// resolution of cluster members, fetching of requests and receiving of responses over an event stream.
+// Queued requests are dropped.
+func (h *ServerClient) Close() error {
+	if h.balancer != nil {
+		h.balancer.Close()
+	}
+
+	if h.puller != nil {
+		h.puller.stop()
+	}
+
+	if h.events != nil {
+		h.events.stop()
+	}
+
+	if h.queue != nil {
+		h.queue.Close()
+	}
+
+	if h.hub != nil {
+		h.hub.close()
+	}
+
+	if h.cluster != nil {
+		h.cluster.stop()
+	}
+
+	return nil
 }
 
 func (h *ServerClient) Handler() http.Handler {
-	return handler(h.sf, h.pubsub)
+	return handler(h)
 }
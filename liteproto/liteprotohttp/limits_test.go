@@ -0,0 +1,129 @@
+package liteprotohttp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/drone/liteproto/liteproto"
+	"github.com/drone/liteproto/liteproto/transport"
+)
+
+func TestDefaultBodyLimits(t *testing.T) {
+	if h := New("http://unused", false, nil, nil); h.opts.maxBody != DefaultMaxBody || h.opts.maxDecoded != DefaultMaxDecoded {
+		t.Errorf("expected the default limits, got %d and %d", h.opts.maxBody, h.opts.maxDecoded)
+	}
+
+	if h := New("http://unused", false, nil, nil, WithBodyLimits(0, 0)); h.opts.maxBody != 0 || h.opts.maxDecoded != 0 {
+		t.Errorf("expected no limits, got %d and %d", h.opts.maxBody, h.opts.maxDecoded)
+	}
+}
+
+func TestBodyLimits(t *testing.T) {
+	server := New("http://unused", false, nil, nil, WithBodyLimits(1<<10, 2<<10))
+	server.RegisterWithResponder("build", nopExecer{})
+
+	srv := httptest.NewServer(server.Handler())
+	defer srv.Close()
+
+	tests := []struct {
+		name     string
+		compress bool
+		data     []byte
+		status   int
+	}{
+		{name: "small", data: []byte(`"small"`)},
+		{name: "large", data: bytes.Repeat([]byte("x"), 2<<10), status: http.StatusRequestEntityTooLarge},
+		{name: "compressed under the decoded limit", compress: true, data: bytes.Repeat([]byte("x"), 1<<10)},
+		{name: "decompression bomb", compress: true, data: bytes.Repeat([]byte("x"), 1<<20), status: http.StatusRequestEntityTooLarge},
+	}
+
+	for _, test := range tests {
+		caller := New(srv.URL, test.compress, nil, nil, WithBinaryEncoding())
+
+		err := caller.Call(context.Background(), liteproto.TaskRequest{ID: "1", Type: "build", Data: test.data})
+		if failed, ok := err.(CallFailedError); test.status != 0 && (!ok || failed.StatusCode != test.status) {
+			t.Errorf("%s: expected status %d, got %v", test.name, test.status, err)
+		} else if test.status == 0 && err != nil {
+			t.Errorf("%s: expected no error, got %v", test.name, err)
+		}
+	}
+
+	if n := server.RejectedRequests(); n != 2 {
+		t.Errorf("expected 2 rejected requests, got %d", n)
+	}
+}
+
+func TestPullFetchLimit(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintf(w, `[{"lease":"1","message":{"id":"1","type":"t","data":%q}}]`, strings.Repeat("x", 4<<10))
+	}))
+	defer srv.Close()
+
+	p := &puller{client: http.DefaultClient, url: srv.URL, batch: maxPullBatch, maxDecoded: 1 << 10}
+	if _, _, err := p.fetch(context.Background()); !isTooLarge(err) {
+		t.Errorf("expected the fetch to fail on the limit, got %v", err)
+	}
+
+	p.maxDecoded = 8 << 10
+	if items, _, err := p.fetch(context.Background()); err != nil || len(items) != 1 {
+		t.Errorf("expected one item, got %v, %v", items, err)
+	}
+}
+
+func TestEventSizeLimit(t *testing.T) {
+	large := strings.Repeat("x", 4<<10)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintf(w, "id: 1\ndata: {\"id\":\"a\",\"type\":\"t\",\"status\":\"ok\",\"data\":%q}\n\n", large)
+		fmt.Fprintf(w, "id: 2\ndata: {\"id\":\"a\",\"type\":\"t\",\n")
+		fmt.Fprintf(w, "data: \"status\":\"ok\",\"data\":%q}\n\n", large)
+		fmt.Fprint(w, "id: 3\ndata: {\"id\":\"a\",\"type\":\"t\",\"status\":\"ok\"}\n\n")
+	}))
+	defer srv.Close()
+
+	pubsub := &transport.PubSub{}
+	sc := transport.NewServerClient(transport.Config{Delivery: deliveryFunc(nil), PubSub: pubsub})
+
+	responses, _ := pubsub.Subscribe("a")
+
+	er := &eventReceiver{client: http.DefaultClient, url: srv.URL, id: "c", max: 1 << 10, sc: sc, logger: log.New(io.Discard, "", 0)}
+	if _, err := er.stream(context.Background()); err != io.EOF {
+		t.Fatalf("expected the stream to end, got %v", err)
+	}
+
+	if er.last != "3" {
+		t.Errorf("expected the last event ID 3, got %q", er.last)
+	}
+
+	select {
+	case r := <-responses:
+		if len(r.Data) != 0 {
+			t.Errorf("expected only the small event, got %d bytes", len(r.Data))
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the small event")
+	}
+
+	select {
+	case r := <-responses:
+		t.Errorf("unexpected response %+v", r)
+	default:
+	}
+}
+
+// deliveryFunc is a transport.Delivery implemented by a function.
+type deliveryFunc func(ctx context.Context, addr string, e *transport.Envelope) error
+
+func (f deliveryFunc) Deliver(ctx context.Context, addr string, e *transport.Envelope) error {
+	return f(ctx, addr, e)
+}
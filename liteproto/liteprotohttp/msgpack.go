@@ -0,0 +1,347 @@
+package liteprotohttp
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/drone/liteproto/liteproto/transport"
+)
+
+// msgpacker encodes messages as application/msgpack: a map with the same keys as JSON messages,
+// data as bin and deadline as the timestamp extension type.
+type msgpacker struct{}
+
+var errInvalidMsgpack = errors.New("invalid msgpack message")
+
+func (msgpacker) ContentType() string {
+	return "application/msgpack"
+}
+
+func (msgpacker) Encode(w io.Writer, e *transport.Envelope) error {
+	var b mpWriter
+
+	b.mapHeader(fieldCount(e))
+
+	b.str("id")
+	b.str(e.ID)
+	b.str("type")
+	b.str(e.Type)
+
+	if e.Status != "" {
+		b.str("status")
+		b.str(e.Status)
+	}
+
+	if len(e.Data) > 0 {
+		b.str("data")
+		b.bin(e.Data)
+	}
+
+	if !e.Deadline.IsZero() {
+		b.str("deadline")
+		b.timestamp(e.Deadline)
+	}
+
+	if e.ReplyTo != "" {
+		b.str("reply_to")
+		b.str(e.ReplyTo)
+	}
+
+	if len(e.Metadata) > 0 {
+		keys := make([]string, 0, len(e.Metadata))
+		for k := range e.Metadata {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		b.str("metadata")
+		b.mapHeader(len(keys))
+		for _, k := range keys {
+			b.str(k)
+			b.str(e.Metadata[k])
+		}
+	}
+
+	_, err := w.Write(b)
+	return err
+}
+
+func (msgpacker) Decode(r io.Reader) (*transport.Envelope, error) {
+	data, err := readAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	mr := mpReader(data)
+
+	v, err := mr.value(0)
+	if err != nil {
+		return nil, err
+	}
+
+	fields, ok := v.(map[string]interface{})
+	if !ok || len(mr) > 0 {
+		return nil, errInvalidMsgpack
+	}
+
+	return envelopeFields(fields).envelope()
+}
+
+type mpWriter []byte
+
+func (w *mpWriter) mapHeader(n int) {
+	switch {
+	case n < 16:
+		*w = append(*w, 0x80|byte(n))
+	case n <= math.MaxUint16:
+		*w = append(*w, 0xde, byte(n>>8), byte(n))
+	default:
+		*w = append(*w, 0xdf)
+		*w = appendUint32(*w, uint32(n))
+	}
+}
+
+func (w *mpWriter) str(s string) {
+	n := len(s)
+	switch {
+	case n < 32:
+		*w = append(*w, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		*w = append(*w, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		*w = append(*w, 0xda, byte(n>>8), byte(n))
+	default:
+		*w = append(*w, 0xdb)
+		*w = appendUint32(*w, uint32(n))
+	}
+	*w = append(*w, s...)
+}
+
+func (w *mpWriter) bin(data []byte) {
+	n := len(data)
+	switch {
+	case n <= math.MaxUint8:
+		*w = append(*w, 0xc4, byte(n))
+	case n <= math.MaxUint16:
+		*w = append(*w, 0xc5, byte(n>>8), byte(n))
+	default:
+		*w = append(*w, 0xc6)
+		*w = appendUint32(*w, uint32(n))
+	}
+	*w = append(*w, data...)
+}
+
+// timestamp writes the 96-bit format of the timestamp extension type.
+func (w *mpWriter) timestamp(t time.Time) {
+	*w = append(*w, 0xc7, 12, 0xff)
+	*w = appendUint32(*w, uint32(t.Nanosecond()))
+	*w = appendUint64(*w, uint64(t.Unix()))
+}
+
+type mpReader []byte
+
+func (r *mpReader) take(n uint64) ([]byte, error) {
+	if n > uint64(len(*r)) {
+		return nil, errInvalidMsgpack
+	}
+
+	b := (*r)[:n]
+	*r = (*r)[n:]
+
+	return b, nil
+}
+
+func (r *mpReader) uint(size int) (uint64, error) {
+	b, err := r.take(uint64(size))
+	if err != nil {
+		return 0, err
+	}
+
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+
+	return v, nil
+}
+
+// value decodes the next value. Maps with other than string keys are decoded as nil.
+func (r *mpReader) value(depth int) (interface{}, error) {
+	if depth > maxNesting {
+		return nil, errInvalidNesting
+	}
+
+	b, err := r.take(1)
+	if err != nil {
+		return nil, err
+	}
+
+	c := b[0]
+
+	switch {
+	case c <= 0x7f:
+		return int64(c), nil
+	case c >= 0xe0:
+		return int64(int8(c)), nil
+	case c&0xf0 == 0x80:
+		return r.mapValue(uint64(c&0x0f), depth)
+	case c&0xf0 == 0x90:
+		return r.array(uint64(c&0x0f), depth)
+	case c&0xe0 == 0xa0:
+		s, err := r.take(uint64(c & 0x1f))
+		return string(s), err
+	}
+
+	switch c {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6, 0xd9, 0xda, 0xdb:
+		size := 1 << (c - 0xc4)
+		if c >= 0xd9 {
+			size = 1 << (c - 0xd9)
+		}
+		n, err := r.uint(size)
+		if err != nil {
+			return nil, err
+		}
+		data, err := r.take(n)
+		if err != nil {
+			return nil, err
+		}
+		if c >= 0xd9 {
+			return string(data), nil
+		}
+		return append([]byte(nil), data...), nil
+	case 0xc7, 0xc8, 0xc9:
+		n, err := r.uint(1 << (c - 0xc7))
+		if err != nil {
+			return nil, err
+		}
+		return r.ext(n)
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		return r.ext(1 << (c - 0xd4))
+	case 0xca:
+		v, err := r.uint(4)
+		return float64(math.Float32frombits(uint32(v))), err
+	case 0xcb:
+		v, err := r.uint(8)
+		return math.Float64frombits(v), err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		v, err := r.uint(1 << (c - 0xcc))
+		return v, err
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (c - 0xd0)
+		v, err := r.uint(size)
+		shift := uint(64 - 8*size)
+		return int64(v<<shift) >> shift, err
+	case 0xdc, 0xdd:
+		n, err := r.uint(2 << (c - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return r.array(n, depth)
+	case 0xde, 0xdf:
+		n, err := r.uint(2 << (c - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return r.mapValue(n, depth)
+	}
+
+	return nil, errInvalidMsgpack
+}
+
+func (r *mpReader) array(n uint64, depth int) (interface{}, error) {
+	if n > uint64(len(*r)) {
+		return nil, errInvalidMsgpack
+	}
+
+	values := make([]interface{}, n)
+	for i := range values {
+		v, err := r.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = v
+	}
+
+	return values, nil
+}
+
+func (r *mpReader) mapValue(n uint64, depth int) (interface{}, error) {
+	if n > uint64(len(*r)) {
+		return nil, errInvalidMsgpack
+	}
+
+	m := make(map[string]interface{}, n)
+	stringKeys := true
+
+	for i := uint64(0); i < n; i++ {
+		k, err := r.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+
+		v, err := r.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+
+		if s, ok := k.(string); ok {
+			m[s] = v
+		} else {
+			stringKeys = false
+		}
+	}
+
+	if !stringKeys {
+		return nil, nil
+	}
+
+	return m, nil
+}
+
+// ext decodes an extension value. Only the timestamp type is supported, other types are decoded as nil.
+func (r *mpReader) ext(n uint64) (interface{}, error) {
+	t, err := r.take(1)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := r.take(n)
+	if err != nil {
+		return nil, err
+	}
+
+	if int8(t[0]) != -1 {
+		return nil, nil
+	}
+
+	switch n {
+	case 4:
+		return time.Unix(int64(binary.BigEndian.Uint32(data)), 0), nil
+	case 8:
+		v := binary.BigEndian.Uint64(data)
+		return time.Unix(int64(v&(1<<34-1)), int64(v>>34)), nil
+	case 12:
+		return time.Unix(int64(binary.BigEndian.Uint64(data[4:])), int64(binary.BigEndian.Uint32(data))), nil
+	}
+
+	return nil, errInvalidMsgpack
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	return appendUint32(appendUint32(b, uint32(v>>32)), uint32(v))
+}
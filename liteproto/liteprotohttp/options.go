@@ -0,0 +1,425 @@
+package liteprotohttp
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/drone/liteproto/liteproto"
+)
+
+// Option configures an optional feature of a ServerClient. Options are passed to New.
+type Option func(*options)
+
+type options struct {
+	resultStore liteproto.ResultStore
+
+	bufferSize   int
+	overflow     liteproto.OverflowPolicy
+	blockTimeout time.Duration
+
+	orphanHandler func(response liteproto.TaskResponse)
+	orphanStatus  int
+
+	replyTo          string
+	replyToValidator func(replyTo string) error
+
+	balanceURLs []string
+	balance     liteproto.BalanceOptions
+
+	resolver        liteproto.Resolver
+	resolveInterval time.Duration
+
+	clusterSelf    string
+	clusterMembers map[string]string
+	clusterSecret  []byte
+
+	queueLease     time.Duration
+	queueAuthorize func(r *http.Request, queue string) error
+
+	pull       bool
+	pullQueue  string
+	pullWait   time.Duration
+	pullHeader http.Header
+
+	eventStreams      bool
+	eventBuffer       int
+	eventRetention    time.Duration
+	eventClients      int
+	eventAuthenticate func(r *http.Request, clientID string) error
+	eventClientID     string
+	eventHeader       http.Header
+
+	encoding Codec
+
+	compression *compression
+
+	maxBody    int64
+	maxDecoded int64
+	typeLimits map[string]int64
+
+	streamTimeout time.Duration
+}
+
+// compressionFor returns the compression setting of a caller. Parameter compress is the setting
+// passed to New or AddPeer, it's ignored if the compression is configured with WithCompression.
+func (o *options) compressionFor(compress bool) compression {
+	if o.compression != nil {
+		return *o.compression
+	}
+
+	if compress {
+		return compression{preferred: CompressionGzip}
+	}
+
+	return compression{}
+}
+
+// codec returns the codec of the messages sent by the ServerClient.
+func (o *options) codec() Codec {
+	if o.encoding == nil {
+		return jsoner{}
+	}
+	return o.encoding
+}
+
+// configuredURLs returns the remote URLs and the reply-to URL the ServerClient is configured with.
+// Parameter url is the URL passed to New.
+func (o *options) configuredURLs(url string) []string {
+	urls := append([]string{url}, o.balanceURLs...)
+
+	for _, member := range o.clusterMembers {
+		urls = append(urls, member)
+	}
+
+	if o.replyTo != "" {
+		urls = append(urls, o.replyTo)
+	}
+
+	return urls
+}
+
+// WithResultStore makes the server keep all responses sent by its execers in the provided store.
+// Callers can then fetch the responses they missed with Resubscribe.
+func WithResultStore(store liteproto.ResultStore) Option {
+	return func(o *options) {
+		o.resultStore = store
+	}
+}
+
+// WithResponseBuffer sets the number of responses buffered for each call awaiting responses
+// and the policy that is applied when a caller doesn't read the responses fast enough.
+// Parameter blockTimeout is used only with liteproto.OverflowBlock policy, zero value means no timeout.
+func WithResponseBuffer(size int, policy liteproto.OverflowPolicy, blockTimeout time.Duration) Option {
+	return func(o *options) {
+		o.bufferSize = size
+		o.overflow = policy
+		o.blockTimeout = blockTimeout
+	}
+}
+
+// WithOrphanHandler sets a function that is called with every received response that nobody awaits,
+// for example a response that arrived after the deadline or a response to a request sent with Call.
+// The function is called synchronously by the HTTP handler, so it shouldn't block for long.
+func WithOrphanHandler(f func(response liteproto.TaskResponse)) Option {
+	return func(o *options) {
+		o.orphanHandler = f
+	}
+}
+
+// WithOrphanStatus sets the HTTP status code with which the handler rejects responses that nobody awaits.
+// By default such responses are accepted with 204 (No Content). The responder will get CallFailedError
+// with the provided status code.
+func WithOrphanStatus(status int) Option {
+	return func(o *options) {
+		o.orphanStatus = status
+	}
+}
+
+// WithReplyTo sets the URL of this ServerClient's handler that is advertised to remote servers in every request.
+// Remote servers send responses for the requests to that URL, instead to the URL they were created with,
+// so a single server can answer many clients.
+//
+// A server accepts only the reply-to URLs allowed by its validator (see WithReplyToValidator),
+// by default the URLs it's configured with, so a server that answers many clients needs a validator.
+func WithReplyTo(url string) Option {
+	return func(o *options) {
+		o.replyTo = url
+	}
+}
+
+// WithReplyToValidator makes the handler reject requests whose reply-to URL fails the validator
+// with status 403 Forbidden, and the puller (see WithPull) drop such requests. Responses are sent
+// to the reply-to URLs, so accepting any URL lets any caller make the ServerClient POST to any URL it can reach.
+// See AllowReplyTo for a validator that accepts a list of URLs and AllowAnyReplyTo for one that accepts all.
+//
+// Without a validator, only URLs under the ones the ServerClient is configured with are accepted:
+// the URL passed to New and the URLs of WithReplyTo, WithBalancer and WithCluster, and the addresses
+// of event stream callers if WithEventStreams is used. A validator gets the event stream addresses
+// too, they are "sse:" followed by the client ID.
+func WithReplyToValidator(validate func(replyTo string) error) Option {
+	return func(o *options) {
+		o.replyToValidator = validate
+	}
+}
+
+// WithBalancer makes the ServerClient spread its calls across several remote URLs, instead of sending them
+// to the single URL passed to New. Only requests are balanced, responses go to the reply-to address
+// of the request, or to the URL passed to New if there is none. If health checks are enabled
+// in the BalanceOptions, URLs that fail are ejected until they recover. The health checks
+// stop when the ServerClient is closed.
+func WithBalancer(urls []string, opts liteproto.BalanceOptions) Option {
+	return func(o *options) {
+		o.balanceURLs = urls
+		o.balance = opts
+	}
+}
+
+// WithResolver makes the ServerClient spread its calls across remote URLs returned by the resolver.
+// Like with WithBalancer, only requests are balanced.
+// The resolver is called in the background when the ServerClient is created and then every 'interval'
+// until the ServerClient is closed, each call is limited to transport.ResolveTimeout. Balancing is configured
+// with WithBalancer option, URLs passed to it are used until the resolver returns some URLs. If there are none,
+// calls wait for the first resolution. Its errors are logged and returned by ResolveErr.
+// Zero value for interval means 30 seconds.
+func WithResolver(resolver liteproto.Resolver, interval time.Duration) Option {
+	return func(o *options) {
+		o.resolver = resolver
+		o.resolveInterval = interval
+	}
+}
+
+// WithCluster makes the ServerClient a member of a cluster of replicas behind a load balancer.
+// Parameter self is the name of this replica and members maps names of all replicas to URLs of their
+// handlers, which must be reachable directly, not through the load balancer. A response that arrives
+// at a replica that doesn't await it is relayed to the replica that does.
+//
+// The name of the replica is added to the reply-to URL (see WithReplyTo), so remote servers send
+// the name back with the responses, and they can be relayed directly to the right replica.
+// Without the reply-to URL responses are offered to all replicas in turn.
+//
+// Relayed responses are marked, so they are not relayed again. The mark is trusted only if the secret
+// set with WithClusterSecret signs it, or without a secret, if the response comes from an address
+// of a member's host. Host names of the members are resolved when the ServerClient is created
+// and then every 30 seconds, until it's closed.
+func WithCluster(self string, members map[string]string) Option {
+	return func(o *options) {
+		o.clusterSelf = self
+		o.clusterMembers = members
+	}
+}
+
+// WithClusterSecret sets a secret shared by the replicas of a cluster (see WithCluster).
+// The replicas sign the responses they relay to each other with it.
+func WithClusterSecret(secret []byte) Option {
+	return func(o *options) {
+		o.clusterSecret = secret
+	}
+}
+
+// WithQueue enables pull mode on the calling side, for workers that can't accept inbound connections.
+// Requests are not POSTed to the remote URL, they are held in named queues until workers fetch them
+// from this ServerClient's handler (see WithPull). Calls made with the ServerClient go to the queue
+// with empty name, other queues can be called with Queue method.
+//
+// A fetched request is leased to the worker. The worker extends the lease while the execer runs
+// and acknowledges it when the execer returns. If the worker neither extends nor acknowledges it
+// within the lease timeout, for example because it died, the request is queued again, so it can be
+// executed more than once. Workers send responses through the normal handler, so the remote URL
+// of workers must be the URL of this ServerClient's handler.
+//
+// Anybody who can reach the handler can fetch the requests, unless workers are authorized
+// with WithQueueAuthorizer.
+func WithQueue(leaseTimeout time.Duration) Option {
+	return func(o *options) {
+		o.queueLease = leaseTimeout
+	}
+}
+
+// WithQueueAuthorizer sets a function that verifies that the worker fetching requests from a queue
+// (see WithQueue) or acknowledging a lease of its request is allowed to use the queue, for example
+// with the headers of the request. Requests it returns an error for are rejected with 403 (Forbidden).
+func WithQueueAuthorizer(f func(r *http.Request, queue string) error) Option {
+	return func(o *options) {
+		o.queueAuthorize = f
+	}
+}
+
+// WithPull makes the ServerClient a worker that fetches requests from the named queue of the remote
+// ServerClient created with WithQueue, instead of accepting them with its handler. The requests
+// are fetched with long polling, 'wait' is the longest time a fetch waits for a request;
+// zero value means 30 seconds. The http.Client passed to New must not time out sooner.
+// Fetching stops when the ServerClient is closed.
+func WithPull(queue string, wait time.Duration) Option {
+	return func(o *options) {
+		o.pull = true
+		o.pullQueue = queue
+		o.pullWait = wait
+	}
+}
+
+// WithPullHeader sets additional HTTP headers that a worker (see WithPull) sends when it fetches
+// and acknowledges requests, for example for authentication (see WithQueueAuthorizer).
+func WithPullHeader(header http.Header) Option {
+	return func(o *options) {
+		o.pullHeader = header
+	}
+}
+
+// WithEventStreams lets callers receive responses over Server-Sent Events streams (see WithEventStream),
+// which is useful for browser-based callers and callers behind firewalls. A caller opens the stream
+// with a GET request to this ServerClient's handler with query parameter "client" set to its ID.
+//
+// Up to bufferSize responses are kept for each caller, so a caller that reconnects with
+// Last-Event-ID header gets the responses it missed. Buffers of callers that had no stream open
+// for the retention period are dropped. Zero values mean 1000 responses and 5 minutes.
+//
+// Anybody who knows the ID of a caller can read its responses, so the IDs should be hard to guess,
+// or the callers should be authenticated with WithEventAuthenticator. Without an authenticator
+// a caller can have only one stream open, a stream with the ID of a connected caller is rejected
+// with 409 (Conflict).
+func WithEventStreams(bufferSize int, retention time.Duration) Option {
+	return func(o *options) {
+		o.eventStreams = true
+		o.eventBuffer = bufferSize
+		o.eventRetention = retention
+	}
+}
+
+// WithMaxEventClients sets the maximum number of callers whose responses are buffered for event streams
+// (see WithEventStreams). When it's reached, new streams are rejected with 503 (Service Unavailable)
+// and responses to new callers are dropped, until buffers of callers without a stream expire.
+// Zero value means 10000.
+func WithMaxEventClients(n int) Option {
+	return func(o *options) {
+		o.eventClients = n
+	}
+}
+
+// WithEventAuthenticator sets a function that verifies that the caller opening an event stream
+// (see WithEventStreams) is allowed to use the client ID it presents, for example with the headers
+// set by WithEventStreamHeader. Streams it returns an error for are rejected with 401 (Unauthorized).
+func WithEventAuthenticator(f func(r *http.Request, clientID string) error) Option {
+	return func(o *options) {
+		o.eventAuthenticate = f
+	}
+}
+
+// WithEventStream makes the ServerClient receive responses over a Server-Sent Events stream opened
+// to the remote URL, instead of by its handler. The remote ServerClient must be created with
+// WithEventStreams option. The client ID must be unique among callers of the remote ServerClient,
+// it replaces the reply-to URL (see WithReplyTo). The stream is reopened when it breaks
+// and closed when the ServerClient is closed.
+func WithEventStream(clientID string) Option {
+	return func(o *options) {
+		o.eventClientID = clientID
+	}
+}
+
+// WithEventStreamHeader sets additional HTTP headers that the ServerClient sends when it opens
+// the event stream (see WithEventStream), for example for authentication.
+func WithEventStreamHeader(header http.Header) Option {
+	return func(o *options) {
+		o.eventHeader = header
+	}
+}
+
+// WithBinaryEncoding makes the ServerClient send messages as application/octet-stream: a small JSON header
+// followed by the raw payload. It avoids the overhead of base64 encoding of payloads that aren't JSON,
+// like archives or images. Handlers accept both encodings regardless of this option.
+func WithBinaryEncoding() Option {
+	return func(o *options) {
+		o.encoding = binaryer{}
+	}
+}
+
+// WithProtobufEncoding makes the ServerClient send messages as application/x-protobuf,
+// which is more compact and faster to process than JSON. The wire format is described by message.proto,
+// so peers written in other languages can use it. Handlers accept all encodings regardless of this option.
+func WithProtobufEncoding() Option {
+	return func(o *options) {
+		o.encoding = protobufer{}
+	}
+}
+
+// WithMessagePackEncoding makes the ServerClient send messages as application/msgpack.
+// Handlers accept all encodings regardless of this option.
+func WithMessagePackEncoding() Option {
+	return func(o *options) {
+		o.encoding = msgpacker{}
+	}
+}
+
+// WithCBOREncoding makes the ServerClient send messages as application/cbor.
+// Handlers accept all encodings regardless of this option.
+func WithCBOREncoding() Option {
+	return func(o *options) {
+		o.encoding = cborer{}
+	}
+}
+
+// WithCodec makes the ServerClient send messages encoded with the codec. The codec should be registered
+// with RegisterCodec, so that the handlers of remote ServerClients accept the messages.
+//
+// Responses to a request are always sent in the encoding the request arrived in, regardless of this option.
+func WithCodec(c Codec) Option {
+	return func(o *options) {
+		o.encoding = c
+	}
+}
+
+// WithCompression sets the compression of HTTP request bodies, it overrides the 'compress' parameter
+// of New and AddPeer. Bodies smaller than minSize bytes are not compressed. Level is the compression level
+// of the algorithm, zero means the default level: 1-9 for gzip and deflate, 1-22 for zstd.
+//
+// Handlers accept all the encodings and advertise them in the Accept-Encoding header of their responses.
+// The preferred encoding is used once the remote handler is known to accept it, until then,
+// or if the handler doesn't accept it, the best encoding both sides support is used.
+func WithCompression(preferred Compression, minSize, level int) Option {
+	return func(o *options) {
+		o.compression = &compression{
+			preferred: preferred,
+			minSize:   minSize,
+			level:     level,
+		}
+	}
+}
+
+// Default size limits of request bodies, see WithBodyLimits.
+const (
+	DefaultMaxBody    = 32 << 20
+	DefaultMaxDecoded = 64 << 20
+)
+
+// WithBodyLimits limits the size of request bodies the handler accepts. Parameter maxBody limits
+// the body as received, maxDecoded the body after decompression, which protects against decompression bombs.
+// Larger requests are rejected with 413 (Request Entity Too Large) before any execer is invoked.
+// The decoded limit also applies to the requests fetched in pull mode (see WithPull) and to the responses
+// received over an event stream (see WithEventStream). Zero or negative value means no limit.
+// Without this option the limits are DefaultMaxBody and DefaultMaxDecoded, streamed payloads
+// larger than that need higher limits.
+func WithBodyLimits(maxBody, maxDecoded int64) Option {
+	return func(o *options) {
+		o.maxBody = maxBody
+		o.maxDecoded = maxDecoded
+	}
+}
+
+// WithTypeLimit limits the size of the payload (Data) of messages of the task type the handler accepts.
+// Larger messages are rejected with 413 (Request Entity Too Large). The option can be used multiple times.
+func WithTypeLimit(t string, maxData int64) Option {
+	return func(o *options) {
+		if o.typeLimits == nil {
+			o.typeLimits = map[string]int64{}
+		}
+		o.typeLimits[t] = maxData
+	}
+}
+
+// WithStreamTimeout sets how long the handler holds the streamed payload of a response (see RespondStream)
+// until the caller claims it with ResponsePayload. Payloads not claimed in time are dropped and the responder
+// gets 408 (Request Timeout). The default is 30 seconds. Streamed requests aren't subject to the timeout.
+func WithStreamTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.streamTimeout = d
+	}
+}
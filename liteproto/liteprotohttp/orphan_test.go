@@ -0,0 +1,105 @@
+package liteprotohttp
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/drone/liteproto/liteproto"
+)
+
+// postResponse sends a response to the handler like a remote responder, it returns the status code.
+func postResponse(t *testing.T, srvURL, id string) int {
+	t.Helper()
+
+	body := `{"id":"` + id + `","type":"build","status":"` + liteproto.StatusOK + `","data":"x"}`
+	resp, err := http.Post(srvURL, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+
+	return resp.StatusCode
+}
+
+func TestOrphanResponse(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   []Option
+		status int
+	}{
+		{"accepted", nil, http.StatusNoContent},
+		{"rejected", []Option{WithOrphanStatus(http.StatusGone)}, http.StatusGone},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			orphans := make(chan liteproto.TaskResponse, 10)
+
+			// the remote server accepts the calls, the test sends the responses
+			remote := New("http://unused", false, nil, nil)
+			remote.RegisterWithResponder("build", nopExecer{})
+			remoteSrv := httptest.NewServer(remote.Handler())
+			defer remoteSrv.Close()
+
+			opts := append([]Option{WithOrphanHandler(func(r liteproto.TaskResponse) { orphans <- r })}, test.opts...)
+			client := New(remoteSrv.URL, false, nil, nil, opts...)
+			srv := httptest.NewServer(client.Handler())
+			defer srv.Close()
+
+			// a response that nobody awaits
+			if status := postResponse(t, srv.URL, "unknown"); status != test.status {
+				t.Errorf("expected status %d for an orphan, got %d", test.status, status)
+			}
+
+			select {
+			case r := <-orphans:
+				if r.ID != "unknown" || r.Status != liteproto.StatusOK || string(r.Data) != `"x"` {
+					t.Errorf("expected the orphan response, got %+v", r)
+				}
+			default:
+				t.Error("the orphan handler wasn't called")
+			}
+
+			// an awaited response isn't an orphan
+			response, stop, err := client.CallWithDeadline(context.Background(), liteproto.TaskRequest{ID: "awaited", Type: "build"}, time.Now().Add(time.Second))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if status := postResponse(t, srv.URL, "awaited"); status != http.StatusNoContent {
+				t.Errorf("expected status %d for an awaited response, got %d", http.StatusNoContent, status)
+			}
+			if r := <-response; r.ID != "awaited" {
+				t.Errorf("expected the awaited response, got %+v", r)
+			}
+			select {
+			case r := <-orphans:
+				t.Errorf("the awaited response was passed to the orphan handler: %+v", r)
+			default:
+			}
+
+			// a response that arrives after the caller stopped awaiting is an orphan
+			close(stop)
+			for range response {
+			}
+
+			if status := postResponse(t, srv.URL, "awaited"); status != test.status {
+				t.Errorf("expected status %d for a late response, got %d", test.status, status)
+			}
+
+			select {
+			case r := <-orphans:
+				if r.ID != "awaited" {
+					t.Errorf("expected the late response, got %+v", r)
+				}
+			default:
+				t.Error("the orphan handler wasn't called for the late response")
+			}
+		})
+	}
+}
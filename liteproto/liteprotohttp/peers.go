@@ -0,0 +1,98 @@
+package liteprotohttp
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/drone/liteproto/liteproto"
+)
+
+// AddPeer registers a named remote server. Calls made with the client returned by Peer method
+// are sent to the provided URL, using the provided compression setting and HTTP client.
+// Parameter 'httpClient' can be nil, in which case http.DefaultClient is used.
+// If a peer with the same name already exists it is replaced.
+//
+// All peers share the ServerClient's handler, so responses from any peer are routed
+// to the pending call with the same ID.
+func (h *ServerClient) AddPeer(name, url string, compress bool, httpClient *http.Client) {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	c := newCaller(httpClient, h.codec, url, h.opts.compressionFor(compress))
+
+	h.peersMx.Lock()
+	defer h.peersMx.Unlock()
+
+	if h.peers == nil {
+		h.peers = map[string]liteproto.Client{}
+	}
+
+	h.peers[name] = h.sc.ClientFor(c, url)
+}
+
+// RemovePeer removes a named remote server. Calls that are already awaiting responses are not affected.
+func (h *ServerClient) RemovePeer(name string) {
+	h.peersMx.Lock()
+	defer h.peersMx.Unlock()
+
+	delete(h.peers, name)
+}
+
+// Peer returns a client for calling the named remote server.
+// If there's no peer with the name, all calls made with the client fail with liteproto.ErrUnknownPeer.
+func (h *ServerClient) Peer(name string) liteproto.Client {
+	h.peersMx.RLock()
+	defer h.peersMx.RUnlock()
+
+	c, ok := h.peers[name]
+	if !ok {
+		return unknownPeer{}
+	}
+
+	return c
+}
+
+// Peers returns sorted names of all registered peers.
+func (h *ServerClient) Peers() []string {
+	h.peersMx.RLock()
+	defer h.peersMx.RUnlock()
+
+	names := make([]string, 0, len(h.peers))
+	for name := range h.peers {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+type unknownPeer struct{}
+
+func (unknownPeer) Call(context.Context, liteproto.TaskRequest) error {
+	return liteproto.ErrUnknownPeer
+}
+
+func (unknownPeer) CallWithResponse(context.Context, liteproto.TaskRequest) (<-chan liteproto.TaskResponse, chan<- struct{}, error) {
+	return nil, nil, liteproto.ErrUnknownPeer
+}
+
+func (unknownPeer) CallWithDeadline(context.Context, liteproto.TaskRequest, time.Time) (<-chan liteproto.TaskResponse, chan<- struct{}, error) {
+	return nil, nil, liteproto.ErrUnknownPeer
+}
+
+// Broadcast sends the same request to all registered peers and merges their responses.
+// See liteproto.ScatterGather for details.
+func (h *ServerClient) Broadcast(ctx context.Context, r liteproto.TaskRequest, opts liteproto.GatherOptions) (response <-chan liteproto.PeerResponse, stop chan<- struct{}, err error) {
+	h.peersMx.RLock()
+	peers := make(map[string]liteproto.Client, len(h.peers))
+	for name, c := range h.peers {
+		peers[name] = c
+	}
+	h.peersMx.RUnlock()
+
+	return liteproto.ScatterGather(ctx, peers, r, opts)
+}
@@ -0,0 +1,133 @@
+package liteprotohttp
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/drone/liteproto/liteproto"
+)
+
+// nameExecer responds with the name of the server.
+type nameExecer string
+
+func (e nameExecer) Exec(ctx context.Context, r liteproto.TaskRequest, rc liteproto.ResponderClient) {
+	_ = rc.Respond(ctx, liteproto.StatusSuccess, []byte(`"`+string(e)+`"`))
+}
+
+func TestPeers(t *testing.T) {
+	caller := httptest.NewServer(nil)
+	defer caller.Close()
+
+	urls := map[string]string{}
+	for _, name := range []string{"a", "b", "c"} {
+		server := New("http://unused", false, nil, nil, WithReplyToValidator(AllowReplyTo(caller.URL)))
+		server.RegisterWithResponder("name", nameExecer(name))
+		srv := httptest.NewServer(server.Handler())
+		defer srv.Close()
+
+		urls[name] = srv.URL
+	}
+
+	client := New("http://unused", false, nil, nil, WithReplyTo(caller.URL))
+	caller.Config.Handler = client.Handler()
+
+	client.AddPeer("scheduler", urls["a"], false, nil)
+	client.AddPeer("runner", urls["b"], true, nil)
+
+	call := func(peer, id string) string {
+		response, stop, err := client.Peer(peer).CallWithDeadline(context.Background(), liteproto.TaskRequest{ID: id, Type: "name"}, time.Now().Add(time.Second))
+		if err != nil {
+			return err.Error()
+		}
+		defer close(stop)
+
+		return string((<-response).Data)
+	}
+
+	tests := []struct {
+		name     string
+		change   func()
+		peers    string
+		expected map[string]string // responses of the peers
+	}{
+		{
+			name:     "added",
+			change:   func() {},
+			peers:    "runner,scheduler",
+			expected: map[string]string{"scheduler": `"a"`, "runner": `"b"`, "other": liteproto.ErrUnknownPeer.Error()},
+		},
+		{
+			name:     "replaced",
+			change:   func() { client.AddPeer("runner", urls["c"], false, nil) },
+			peers:    "runner,scheduler",
+			expected: map[string]string{"scheduler": `"a"`, "runner": `"c"`},
+		},
+		{
+			name:     "removed",
+			change:   func() { client.RemovePeer("scheduler") },
+			peers:    "runner",
+			expected: map[string]string{"scheduler": liteproto.ErrUnknownPeer.Error(), "runner": `"c"`},
+		},
+	}
+
+	for _, test := range tests {
+		test.change()
+
+		if peers := strings.Join(client.Peers(), ","); peers != test.peers {
+			t.Errorf("%s: expected peers %s, got %s", test.name, test.peers, peers)
+		}
+
+		for peer, expected := range test.expected {
+			if got := call(peer, test.name+"-"+peer); got != expected {
+				t.Errorf("%s: expected %s from peer %s, got %s", test.name, expected, peer, got)
+			}
+		}
+	}
+}
+
+// TestPeersConcurrent checks that the responses of several peers are routed to the right pending calls.
+func TestPeersConcurrent(t *testing.T) {
+	caller := httptest.NewServer(nil)
+	defer caller.Close()
+
+	client := New("http://unused", false, nil, nil, WithReplyTo(caller.URL))
+	caller.Config.Handler = client.Handler()
+
+	names := []string{"a", "b", "c"}
+	for _, name := range names {
+		server := New("http://unused", false, nil, nil, WithReplyToValidator(AllowReplyTo(caller.URL)))
+		server.RegisterWithResponder("name", nameExecer(name))
+		srv := httptest.NewServer(server.Handler())
+		defer srv.Close()
+
+		client.AddPeer(name, srv.URL, false, nil)
+	}
+
+	type result struct {
+		peer, response string
+	}
+	results := make(chan result, len(names))
+
+	for _, name := range names {
+		go func(name string) {
+			response, stop, err := client.Peer(name).CallWithDeadline(context.Background(), liteproto.TaskRequest{ID: "call-" + name, Type: "name"}, time.Now().Add(time.Second))
+			if err != nil {
+				results <- result{name, err.Error()}
+				return
+			}
+			defer close(stop)
+
+			results <- result{name, string((<-response).Data)}
+		}(name)
+	}
+
+	for range names {
+		r := <-results
+		if r.response != `"`+r.peer+`"` {
+			t.Errorf("expected the response of peer %s, got %s", r.peer, r.response)
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package liteprotohttp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"time"
+
+	"github.com/drone/liteproto/liteproto/transport"
+)
+
+// protobufer encodes messages as application/x-protobuf, see message.proto.
+// The encoding is implemented by hand, so the package doesn't depend on a protobuf runtime.
+type protobufer struct{}
+
+// field numbers of Message in message.proto
+const (
+	pbID       = 1
+	pbType     = 2
+	pbStatus   = 3
+	pbData     = 4
+	pbDeadline = 5
+	pbReplyTo  = 6
+	pbMetadata = 7
+)
+
+// protobuf wire types
+const (
+	pbVarint  = 0
+	pbFixed64 = 1
+	pbBytes   = 2
+	pbFixed32 = 5
+)
+
+var errInvalidProtobuf = errors.New("invalid protobuf message")
+
+func (protobufer) ContentType() string {
+	return "application/x-protobuf"
+}
+
+func (protobufer) Encode(w io.Writer, e *transport.Envelope) error {
+	m := newMessage(e)
+
+	var b []byte
+
+	b = appendString(b, pbID, m.ID)
+	b = appendString(b, pbType, m.Type)
+	b = appendString(b, pbStatus, m.Status)
+	b = appendBytes(b, pbData, m.Data)
+
+	if m.Deadline != nil {
+		var ts []byte
+		if secs := m.Deadline.Unix(); secs != 0 {
+			ts = appendTag(ts, 1, pbVarint)
+			ts = appendVarint(ts, uint64(secs))
+		}
+		if nanos := m.Deadline.Nanosecond(); nanos != 0 {
+			ts = appendTag(ts, 2, pbVarint)
+			ts = appendVarint(ts, uint64(nanos))
+		}
+		b = appendTag(b, pbDeadline, pbBytes)
+		b = appendVarint(b, uint64(len(ts)))
+		b = append(b, ts...)
+	}
+
+	b = appendString(b, pbReplyTo, m.ReplyTo)
+
+	for k, v := range m.Metadata {
+		var entry []byte
+		entry = appendString(entry, 1, k)
+		entry = appendString(entry, 2, v)
+		b = appendTag(b, pbMetadata, pbBytes)
+		b = appendVarint(b, uint64(len(entry)))
+		b = append(b, entry...)
+	}
+
+	_, err := w.Write(b)
+	return err
+}
+
+func (protobufer) Decode(r io.Reader) (*transport.Envelope, error) {
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(r); err != nil {
+		return nil, err
+	}
+
+	var m message
+
+	err := decodeFields(buf.Bytes(), func(num int, typ int, v uint64, data []byte) error {
+		switch {
+		case num == pbID && typ == pbBytes:
+			m.ID = string(data)
+		case num == pbType && typ == pbBytes:
+			m.Type = string(data)
+		case num == pbStatus && typ == pbBytes:
+			m.Status = string(data)
+		case num == pbData && typ == pbBytes:
+			m.Data = append([]byte(nil), data...)
+		case num == pbDeadline && typ == pbBytes:
+			var secs, nanos int64
+			err := decodeFields(data, func(num int, typ int, v uint64, _ []byte) error {
+				switch {
+				case num == 1 && typ == pbVarint:
+					secs = int64(v)
+				case num == 2 && typ == pbVarint:
+					nanos = int64(int32(v))
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			deadline := time.Unix(secs, nanos)
+			m.Deadline = &deadline
+		case num == pbReplyTo && typ == pbBytes:
+			m.ReplyTo = string(data)
+		case num == pbMetadata && typ == pbBytes:
+			var key, value string
+			err := decodeFields(data, func(num int, typ int, _ uint64, data []byte) error {
+				switch {
+				case num == 1 && typ == pbBytes:
+					key = string(data)
+				case num == 2 && typ == pbBytes:
+					value = string(data)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			if m.Metadata == nil {
+				m.Metadata = map[string]string{}
+			}
+			m.Metadata[key] = value
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return m.envelope(), nil
+}
+
+// decodeFields calls f for every field of an encoded protobuf message. Parameter v holds the value
+// of varint and fixed fields, data the value of length-delimited fields. Unknown fields are skipped
+// by simply ignoring them in f.
+func decodeFields(b []byte, f func(num int, typ int, v uint64, data []byte) error) error {
+	for len(b) > 0 {
+		tag, n := binary.Uvarint(b)
+		if n <= 0 {
+			return errInvalidProtobuf
+		}
+		b = b[n:]
+
+		num, typ := tag>>3, int(tag&7)
+		if num == 0 || num > math.MaxInt32 {
+			return errInvalidProtobuf
+		}
+
+		var v uint64
+		var data []byte
+
+		switch typ {
+		case pbVarint:
+			v, n = binary.Uvarint(b)
+			if n <= 0 {
+				return errInvalidProtobuf
+			}
+			b = b[n:]
+		case pbFixed64:
+			if len(b) < 8 {
+				return errInvalidProtobuf
+			}
+			v = binary.LittleEndian.Uint64(b)
+			b = b[8:]
+		case pbFixed32:
+			if len(b) < 4 {
+				return errInvalidProtobuf
+			}
+			v = uint64(binary.LittleEndian.Uint32(b))
+			b = b[4:]
+		case pbBytes:
+			size, n := binary.Uvarint(b)
+			if n <= 0 || size > uint64(len(b)-n) {
+				return errInvalidProtobuf
+			}
+			data = b[n : n+int(size)]
+			b = b[n+int(size):]
+		default:
+			return errInvalidProtobuf
+		}
+
+		if err := f(int(num), typ, v, data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func appendTag(b []byte, num, typ int) []byte {
+	return appendVarint(b, uint64(num)<<3|uint64(typ))
+}
+
+func appendVarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+	return append(b, buf[:n]...)
+}
+
+// appendString appends a string field, proto3 omits empty strings.
+func appendString(b []byte, num int, s string) []byte {
+	if s == "" {
+		return b
+	}
+
+	b = appendTag(b, num, pbBytes)
+	b = appendVarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+// appendBytes appends a bytes field, proto3 omits empty values.
+func appendBytes(b []byte, num int, data []byte) []byte {
+	if len(data) == 0 {
+		return b
+	}
+
+	b = appendTag(b, num, pbBytes)
+	b = appendVarint(b, uint64(len(data)))
+	return append(b, data...)
+}
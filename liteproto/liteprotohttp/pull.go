@@ -0,0 +1,388 @@
+package liteprotohttp
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/drone/liteproto/liteproto/transport"
+)
+
+const (
+	// queueParam is the query parameter of a fetch request that names the queue.
+	queueParam = "queue"
+
+	// waitParam is the query parameter of a fetch request with the longest wait time, a Go duration.
+	waitParam = "wait"
+
+	// maxParam is the query parameter of a fetch request with the maximum number of returned requests.
+	maxParam = "max"
+
+	// leaseParam is the query parameter of an acknowledgement of a lease.
+	leaseParam = "lease"
+
+	// extendParam is the query parameter that turns an acknowledgement into an extension of the lease.
+	extendParam = "extend"
+
+	// leaseTimeoutHeader is the header of a fetch response with the lease timeout, a Go duration.
+	leaseTimeoutHeader = "Liteproto-Lease-Timeout"
+
+	// defaultLeaseTimeout is the lease timeout assumed if a fetch response doesn't tell it.
+	defaultLeaseTimeout = 30 * time.Second
+
+	// minLeaseExtension is the shortest interval in which a worker extends a lease.
+	minLeaseExtension = 10 * time.Millisecond
+
+	defaultPullWait = 30 * time.Second
+	maxPullWait     = 5 * time.Minute
+	maxPullBatch    = 100
+)
+
+// pulled is an item of the fetch response body.
+type pulled struct {
+	Lease   string   `json:"lease"`
+	Message *message `json:"message"`
+}
+
+// pullDelivery queues requests and sends responses with HTTP.
+type pullDelivery struct {
+	queue *transport.Queue
+	http  transport.Delivery
+}
+
+func (d pullDelivery) Deliver(ctx context.Context, addr string, e *transport.Envelope) error {
+	if e.IsRequest() {
+		return d.queue.Deliver(ctx, addr, e)
+	}
+
+	return d.http.Deliver(ctx, addr, e)
+}
+
+// fetch handles a long-polling fetch request of a worker. Parameter authorize can be nil.
+func fetch(queue *transport.Queue, authorize func(r *http.Request, queue string) error, w http.ResponseWriter, r *http.Request) {
+	if queue == nil {
+		http.Error(w, "pull mode not enabled", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+
+	if authorize != nil {
+		if err := authorize(r, q.Get(queueParam)); err != nil {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+	}
+
+	wait := defaultPullWait
+	if s := q.Get(waitParam); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d < 0 {
+			http.Error(w, "invalid wait", http.StatusBadRequest)
+			return
+		}
+		if d > maxPullWait {
+			d = maxPullWait
+		}
+		wait = d
+	}
+
+	max := 1
+	if s := q.Get(maxParam); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			http.Error(w, "invalid max", http.StatusBadRequest)
+			return
+		}
+		if n > maxPullBatch {
+			n = maxPullBatch
+		}
+		max = n
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), wait)
+	defer cancel()
+
+	leases, err := queue.Fetch(ctx, q.Get(queueParam), max)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	if len(leases) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	items := make([]pulled, len(leases))
+	for i, l := range leases {
+		items[i] = pulled{Lease: l.ID, Message: newMessage(l.Envelope)}
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set(leaseTimeoutHeader, queue.LeaseTimeout().String())
+	_ = json.NewEncoder(w).Encode(items)
+}
+
+// ack handles an acknowledgement or an extension of a lease. Parameter authorize can be nil,
+// otherwise it's called with the queue of the lease.
+func ack(queue *transport.Queue, authorize func(r *http.Request, queue string) error, w http.ResponseWriter, r *http.Request, id string, extend bool) {
+	if queue == nil {
+		http.Error(w, "pull mode not enabled", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if authorize != nil {
+		name, ok := queue.LeaseQueue(id)
+		if !ok {
+			http.Error(w, transport.ErrUnknownLease.Error(), http.StatusNotFound)
+			return
+		}
+		if err := authorize(r, name); err != nil {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+	}
+
+	settle := queue.Ack
+	if extend {
+		settle = queue.Extend
+	}
+
+	if err := settle(id); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// puller fetches requests from a remote queue and passes them to the ServerClient.
+// A lease is extended while the execer runs and acknowledged when it returns,
+// so a request is queued again if the worker dies before it finishes.
+type puller struct {
+	client *http.Client
+	url    string
+	queue  string
+	wait   time.Duration
+	header http.Header
+	sc     *transport.ServerClient
+	logger *log.Logger
+
+	maxDecoded   int64 // limits the body of a fetch response, zero or negative means no limit
+	checkReplyTo func(replyTo string) error
+	batch        int // the number of requests to fetch at once
+
+	cancel func()
+	wg     sync.WaitGroup
+}
+
+func (p *puller) start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	p.cancel = cancel
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		p.run(ctx)
+	}()
+}
+
+func (p *puller) stop() {
+	p.cancel()
+	p.wg.Wait()
+}
+
+func (p *puller) run(ctx context.Context) {
+	const minDelay, maxDelay = time.Second, 30 * time.Second
+
+	delay := minDelay
+	p.batch = maxPullBatch
+
+	for ctx.Err() == nil {
+		items, leaseTimeout, err := p.fetch(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+
+			p.logger.Printf("fetching requests from %s failed: %s", p.url, err.Error())
+
+			// the fetched requests are requeued when their leases expire,
+			// fetch them one by one, so that only those over the limit fail
+			if isTooLarge(err) {
+				p.batch = 1
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(delay):
+			}
+
+			delay *= 2
+			if delay > maxDelay {
+				delay = maxDelay
+			}
+			continue
+		}
+
+		delay = minDelay
+		p.batch = maxPullBatch
+
+		for _, item := range items {
+			if item.Message == nil {
+				continue
+			}
+
+			if err = p.checkReplyTo(item.Message.ReplyTo); err != nil {
+				p.logger.Printf("pulled request %s rejected: %s", item.Message.ID, err.Error())
+				p.settle(ctx, item, false)
+				continue
+			}
+
+			finished := make(chan struct{})
+
+			e := item.Message.envelope()
+			e.Finish = func() { close(finished) }
+
+			if err = p.sc.Receive(ctx, e); err != nil {
+				p.logger.Printf("pulled request %s rejected: %s", item.Message.ID, err.Error())
+				p.settle(ctx, item, false)
+				continue
+			}
+
+			p.wg.Add(1)
+			go func(item pulled) {
+				defer p.wg.Done()
+				p.hold(ctx, item, leaseTimeout, finished)
+			}(item)
+		}
+	}
+}
+
+// hold extends the lease until the execer finishes, then it acknowledges it. If the puller is stopped
+// before that, the lease is left to expire.
+func (p *puller) hold(ctx context.Context, item pulled, leaseTimeout time.Duration, finished <-chan struct{}) {
+	interval := leaseTimeout / 3
+	if interval < minLeaseExtension {
+		interval = minLeaseExtension
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-finished:
+			p.settle(ctx, item, false)
+			return
+		case <-ticker.C:
+			p.settle(ctx, item, true)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (p *puller) settle(ctx context.Context, item pulled, extend bool) {
+	if err := p.ack(ctx, item.Lease, extend); err != nil && ctx.Err() == nil {
+		p.logger.Printf("acknowledging request %s failed: %s", item.Message.ID, err.Error())
+	}
+}
+
+func (p *puller) fetch(ctx context.Context) ([]pulled, time.Duration, error) {
+	q := url.Values{}
+	q.Set(queueParam, p.queue)
+	q.Set(waitParam, p.wait.String())
+	q.Set(maxParam, strconv.Itoa(p.batch))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, withQuery(p.url, q), nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	for k, v := range p.header {
+		req.Header[k] = v
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNoContent:
+		return nil, 0, nil
+	case http.StatusOK:
+	default:
+		return nil, 0, CallFailedError{StatusCode: resp.StatusCode}
+	}
+
+	leaseTimeout, err := time.ParseDuration(resp.Header.Get(leaseTimeoutHeader))
+	if err != nil || leaseTimeout <= 0 {
+		leaseTimeout = defaultLeaseTimeout
+	}
+
+	var body io.ReadCloser = resp.Body
+	if p.maxDecoded > 0 {
+		body = &limitedReader{rc: resp.Body, n: p.maxDecoded}
+	}
+
+	var items []pulled
+	if err = json.NewDecoder(body).Decode(&items); err != nil {
+		return nil, 0, err
+	}
+
+	return items, leaseTimeout, nil
+}
+
+func (p *puller) ack(ctx context.Context, id string, extend bool) error {
+	q := url.Values{}
+	q.Set(leaseParam, id)
+	if extend {
+		q.Set(extendParam, "true")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, withQuery(p.url, q), nil)
+	if err != nil {
+		return err
+	}
+	for k, v := range p.header {
+		req.Header[k] = v
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent {
+		return CallFailedError{StatusCode: resp.StatusCode}
+	}
+
+	return nil
+}
+
+// withQuery adds query parameters to a URL.
+func withQuery(rawURL string, params url.Values) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+
+	q := u.Query()
+	for k, v := range params {
+		q[k] = v
+	}
+	u.RawQuery = q.Encode()
+
+	return u.String()
+}
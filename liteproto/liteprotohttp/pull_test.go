@@ -0,0 +1,226 @@
+package liteprotohttp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/drone/liteproto/liteproto"
+)
+
+// slowExecer responds after a pause and counts its executions.
+type slowExecer struct {
+	pause time.Duration
+	execs *int32
+}
+
+func (e slowExecer) Exec(ctx context.Context, r liteproto.TaskRequest, rc liteproto.ResponderClient) {
+	atomic.AddInt32(e.execs, 1)
+	time.Sleep(e.pause)
+	_ = rc.Respond(ctx, liteproto.StatusSuccess, nil)
+}
+
+// fetchLeases fetches requests from the queue server without acknowledging them, like a worker that dies.
+func fetchLeases(t *testing.T, srvURL string, header http.Header) (items []pulled, status int) {
+	t.Helper()
+
+	req, _ := http.NewRequest(http.MethodGet, withQuery(srvURL, url.Values{waitParam: {"100ms"}}), nil)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK {
+		if err = json.NewDecoder(resp.Body).Decode(&items); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return items, resp.StatusCode
+}
+
+func ackLease(t *testing.T, srvURL, lease string, header http.Header) int {
+	t.Helper()
+
+	req, _ := http.NewRequest(http.MethodPost, withQuery(srvURL, url.Values{leaseParam: {lease}}), nil)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+
+	return resp.StatusCode
+}
+
+func TestPullLeaseExtension(t *testing.T) {
+	server := New("http://unused", false, nil, nil, WithQueue(60*time.Millisecond))
+	defer server.Close()
+
+	srv := httptest.NewServer(server.Handler())
+	defer srv.Close()
+
+	var execs int32
+
+	worker := New(srv.URL, false, nil, nil, WithPull("", 100*time.Millisecond))
+	defer worker.Close()
+	worker.RegisterWithResponder("build", slowExecer{pause: 250 * time.Millisecond, execs: &execs})
+
+	// the execer runs longer than the lease timeout, the worker extends the lease
+	response, stop, err := server.CallWithDeadline(context.Background(), liteproto.TaskRequest{ID: "1", Type: "build"}, time.Now().Add(2*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer close(stop)
+
+	if r, ok := <-response; !ok || r.Status != liteproto.StatusSuccess {
+		t.Fatalf("expected a response, got %+v", r)
+	}
+
+	// a requeued request would be executed again in the meantime
+	time.Sleep(100 * time.Millisecond)
+
+	if n := atomic.LoadInt32(&execs); n != 1 {
+		t.Errorf("expected the request to be executed once, got %d", n)
+	}
+	if n := server.QueueLen(""); n != 0 {
+		t.Errorf("expected the queue to be empty, got %d", n)
+	}
+}
+
+func TestPullLeaseExpiry(t *testing.T) {
+	server := New("http://unused", false, nil, nil, WithQueue(50*time.Millisecond))
+	defer server.Close()
+
+	srv := httptest.NewServer(server.Handler())
+	defer srv.Close()
+
+	if err := server.Call(context.Background(), liteproto.TaskRequest{ID: "1", Type: "build"}); err != nil {
+		t.Fatal(err)
+	}
+
+	first, _ := fetchLeases(t, srv.URL, nil)
+	if len(first) != 1 || first[0].Message.ID != "1" {
+		t.Fatalf("expected the request, got %+v", first)
+	}
+
+	// the lease expires and the request is queued again
+	second, _ := fetchLeases(t, srv.URL, nil)
+	if len(second) != 1 || second[0].Message.ID != "1" || second[0].Lease == first[0].Lease {
+		t.Fatalf("expected the request with a new lease, got %+v", second)
+	}
+
+	if status := ackLease(t, srv.URL, first[0].Lease, nil); status != http.StatusNotFound {
+		t.Errorf("expected the expired lease to be unknown, got status %d", status)
+	}
+	if status := ackLease(t, srv.URL, second[0].Lease, nil); status != http.StatusNoContent {
+		t.Errorf("expected the lease to be acknowledged, got status %d", status)
+	}
+
+	// an acknowledged request isn't queued again
+	time.Sleep(100 * time.Millisecond)
+	if n := server.QueueLen(""); n != 0 {
+		t.Errorf("expected the queue to be empty, got %d", n)
+	}
+}
+
+func TestQueueAuthorizer(t *testing.T) {
+	server := New("http://unused", false, nil, nil, WithQueue(time.Minute),
+		WithQueueAuthorizer(func(r *http.Request, queue string) error {
+			if r.Header.Get("Authorization") != "Bearer worker" {
+				return errors.New("invalid token")
+			}
+			return nil
+		}))
+	defer server.Close()
+
+	srv := httptest.NewServer(server.Handler())
+	defer srv.Close()
+
+	authorized := http.Header{"Authorization": {"Bearer worker"}}
+
+	if err := server.Call(context.Background(), liteproto.TaskRequest{ID: "1", Type: "build"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, status := fetchLeases(t, srv.URL, nil); status != http.StatusForbidden {
+		t.Errorf("expected an unauthorized fetch to be rejected, got status %d", status)
+	}
+
+	items, status := fetchLeases(t, srv.URL, authorized)
+	if status != http.StatusOK || len(items) != 1 {
+		t.Fatalf("expected the request, got status %d and %+v", status, items)
+	}
+
+	tests := []struct {
+		name   string
+		lease  string
+		header http.Header
+		status int
+	}{
+		{"unauthorized", items[0].Lease, nil, http.StatusForbidden},
+		{"unknown lease", "unknown", authorized, http.StatusNotFound},
+		{"authorized", items[0].Lease, authorized, http.StatusNoContent},
+	}
+
+	for _, test := range tests {
+		if status := ackLease(t, srv.URL, test.lease, test.header); status != test.status {
+			t.Errorf("%s: expected status %d, got %d", test.name, test.status, status)
+		}
+	}
+
+	// a worker sends the headers
+	var execs int32
+
+	worker := New(srv.URL, false, nil, nil, WithPull("", 100*time.Millisecond), WithPullHeader(authorized))
+	defer worker.Close()
+	worker.RegisterWithResponder("build", slowExecer{execs: &execs})
+
+	response, stop, err := server.CallWithDeadline(context.Background(), liteproto.TaskRequest{ID: "2", Type: "build"}, time.Now().Add(2*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer close(stop)
+
+	if r, ok := <-response; !ok || r.Status != liteproto.StatusSuccess {
+		t.Errorf("expected a response from the authorized worker, got %+v", r)
+	}
+}
+
+// TestPullShortLease checks that a worker copes with a lease timeout too short to extend the lease in time.
+func TestPullShortLease(t *testing.T) {
+	var acks int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&acks, 1)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	p := &puller{client: http.DefaultClient, url: srv.URL, logger: log.New(io.Discard, "", 0)}
+
+	finished := make(chan struct{})
+	time.AfterFunc(50*time.Millisecond, func() { close(finished) })
+
+	p.hold(context.Background(), pulled{Lease: "1", Message: &message{ID: "1"}}, time.Nanosecond, finished)
+
+	if n := atomic.LoadInt32(&acks); n < 2 || n > 10 {
+		t.Errorf("expected a few extensions and an acknowledgement, got %d requests", n)
+	}
+}
@@ -0,0 +1,86 @@
+package liteprotohttp
+
+import (
+	"context"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/drone/liteproto/liteproto"
+)
+
+// sequenceExecer sends responses 0-4 and the final response 5, then closes done.
+type sequenceExecer struct {
+	done chan struct{}
+}
+
+func (e sequenceExecer) Exec(ctx context.Context, r liteproto.TaskRequest, rc liteproto.ResponderClient) {
+	defer close(e.done)
+
+	for i := 0; i < 6; i++ {
+		status := liteproto.StatusOK
+		if i == 5 {
+			status = liteproto.StatusSuccess
+		}
+		_ = rc.Respond(ctx, status, []byte(strconv.Itoa(i)))
+	}
+}
+
+// TestResubscribe checks that replays skip the responses the caller received,
+// also when the server dropped the oldest stored ones.
+func TestResubscribe(t *testing.T) {
+	done := make(chan struct{})
+
+	caller := httptest.NewServer(nil)
+	defer caller.Close()
+
+	server := New("http://unused", false, nil, nil, WithResultStore(liteproto.NewMemoryResultStore(10, 3, time.Minute)), WithReplyToValidator(AllowReplyTo(caller.URL)))
+	server.RegisterWithResponder("sequence", sequenceExecer{done: done})
+	srv := httptest.NewServer(server.Handler())
+	defer srv.Close()
+
+	client := New(srv.URL, false, nil, nil, WithReplyTo(caller.URL))
+	caller.Config.Handler = client.Handler()
+
+	if err := client.Call(context.Background(), liteproto.TaskRequest{ID: "1", Type: "sequence"}); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the execer didn't finish")
+	}
+
+	tests := []struct {
+		from     int
+		expected string
+	}{
+		{0, "345"}, // the server keeps only the last 3 responses
+		{4, "45"},
+		{5, "5"},
+	}
+
+	for _, test := range tests {
+		response, stop, err := client.Resubscribe(context.Background(), "1", test.from, time.Now().Add(time.Second))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got := ""
+		for r := range response {
+			got += string(r.Data)
+			if r.Status == liteproto.StatusSuccess {
+				break
+			}
+		}
+		close(stop)
+		for range response {
+		}
+
+		if got != test.expected {
+			t.Errorf("from %d: expected responses %q, got %q", test.from, test.expected, got)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package liteprotohttp
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+)
+
+var errReplyToNotAllowed = errors.New("reply-to URL not allowed")
+
+// AllowReplyTo returns a reply-to validator for WithReplyToValidator that accepts only URLs
+// with the scheme and host of one of the provided URLs and a path under its path.
+// For example "https://callers.example.com/liteproto" accepts "https://callers.example.com/liteproto/a?x=1",
+// but not "https://callers.example.com/admin" or "https://callers.example.com.evil.com/liteproto".
+func AllowReplyTo(urls ...string) func(replyTo string) error {
+	allowed := make([]*url.URL, 0, len(urls))
+	for _, s := range urls {
+		if u, err := url.Parse(s); err == nil {
+			allowed = append(allowed, u)
+		}
+	}
+
+	return func(replyTo string) error {
+		u, err := url.Parse(replyTo)
+		if err != nil || u.User != nil || u.Opaque != "" {
+			return errReplyToNotAllowed
+		}
+
+		for _, a := range allowed {
+			if strings.EqualFold(u.Scheme, a.Scheme) && strings.EqualFold(u.Host, a.Host) && underPath(u.Path, a.Path) {
+				return nil
+			}
+		}
+
+		return errReplyToNotAllowed
+	}
+}
+
+// AllowAnyReplyTo is a reply-to validator for WithReplyToValidator that accepts all URLs.
+// Use it only if the handler is reachable by trusted clients only.
+func AllowAnyReplyTo(replyTo string) error {
+	return nil
+}
+
+// underPath reports whether the path is the base path or a path below it.
+func underPath(path, base string) bool {
+	base = strings.TrimSuffix(base, "/")
+	return path == base || strings.HasPrefix(path, base+"/") || base == "" && path == ""
+}
+
+// checkReplyTo validates the reply-to address of a received request with the validator set
+// with WithReplyToValidator. Without one, it accepts the URLs the ServerClient is configured with
+// and addresses of event stream callers. Empty addresses are always accepted.
+func (h *ServerClient) checkReplyTo(replyTo string) error {
+	if replyTo == "" {
+		return nil
+	}
+
+	if h.opts.replyToValidator != nil {
+		return h.opts.replyToValidator(replyTo)
+	}
+
+	if strings.HasPrefix(replyTo, eventStreamPrefix) {
+		if h.hub == nil {
+			return errReplyToNotAllowed
+		}
+		return nil
+	}
+
+	return h.allowReplyTo(replyTo)
+}
@@ -0,0 +1,87 @@
+package liteprotohttp
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/drone/liteproto/liteproto"
+)
+
+func TestAllowReplyTo(t *testing.T) {
+	validate := AllowReplyTo("https://callers.example.com/liteproto", "http://10.0.0.1:8080")
+
+	tests := []struct {
+		replyTo string
+		allowed bool
+	}{
+		{replyTo: "https://callers.example.com/liteproto", allowed: true},
+		{replyTo: "https://callers.example.com/liteproto/a?owner=b", allowed: true},
+		{replyTo: "HTTPS://Callers.Example.com/liteproto/", allowed: true},
+		{replyTo: "http://10.0.0.1:8080", allowed: true},
+		{replyTo: "http://10.0.0.1:8080/anything", allowed: true},
+		{replyTo: "http://callers.example.com/liteproto"},
+		{replyTo: "https://callers.example.com/liteprotox"},
+		{replyTo: "https://callers.example.com/admin"},
+		{replyTo: "https://callers.example.com.evil.com/liteproto"},
+		{replyTo: "https://callers.example.com@evil.com/liteproto"},
+		{replyTo: "https://user@callers.example.com/liteproto"},
+		{replyTo: "http://10.0.0.1:8081"},
+		{replyTo: "http://10.0.0.1"},
+		{replyTo: "http://169.254.169.254/latest/meta-data"},
+		{replyTo: "file:///etc/passwd"},
+		{replyTo: "://"},
+	}
+
+	for _, test := range tests {
+		if err := validate(test.replyTo); (err == nil) != test.allowed {
+			t.Errorf("%s: expected allowed=%v, got %v", test.replyTo, test.allowed, err)
+		}
+	}
+}
+
+func TestReplyToValidator(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    []Option
+		replyTo string
+		allowed bool
+	}{
+		{name: "validator, allowed", opts: []Option{WithReplyToValidator(AllowReplyTo("http://callers"))}, replyTo: "http://callers/a", allowed: true},
+		{name: "validator, not allowed", opts: []Option{WithReplyToValidator(AllowReplyTo("http://callers"))}, replyTo: "http://169.254.169.254"},
+		{name: "validator, no reply-to", opts: []Option{WithReplyToValidator(AllowReplyTo("http://callers"))}, allowed: true},
+		{name: "default, url of New", replyTo: "http://server/liteproto/replies", allowed: true},
+		{name: "default, own reply-to", opts: []Option{WithReplyTo("http://self/replies")}, replyTo: "http://self/replies", allowed: true},
+		{name: "default, balanced url", opts: []Option{WithBalancer([]string{"http://replica/liteproto"}, liteproto.BalanceOptions{})}, replyTo: "http://replica/liteproto", allowed: true},
+		{name: "default, cluster member", opts: []Option{WithCluster("a", map[string]string{"b": "http://b/liteproto"})}, replyTo: "http://b/liteproto?owner=b", allowed: true},
+		{name: "default, other url", replyTo: "http://callers/a"},
+		{name: "default, no reply-to", allowed: true},
+		{name: "allow any", opts: []Option{WithReplyToValidator(AllowAnyReplyTo)}, replyTo: "http://callers/a", allowed: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := New("http://server/liteproto", false, nil, nil, test.opts...)
+			defer server.Close()
+			server.RegisterWithResponder("build", nopExecer{})
+
+			srv := httptest.NewServer(server.Handler())
+			defer srv.Close()
+
+			var opts []Option
+			if test.replyTo != "" {
+				opts = append(opts, WithReplyTo(test.replyTo))
+			}
+			caller := New(srv.URL, false, nil, nil, opts...)
+
+			err := caller.Call(context.Background(), liteproto.TaskRequest{ID: "1", Type: "build"})
+			if test.allowed && err != nil {
+				t.Errorf("expected no error, got %v", err)
+			}
+			if failed, ok := err.(CallFailedError); !test.allowed && (!ok || failed.StatusCode != http.StatusForbidden) {
+				t.Errorf("expected status %d, got %v", http.StatusForbidden, err)
+			}
+		})
+	}
+}
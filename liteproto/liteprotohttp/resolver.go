@@ -0,0 +1,155 @@
+package liteprotohttp
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/drone/liteproto/liteproto"
+)
+
+// SRVResolver is a liteproto.Resolver that discovers remote URLs with DNS SRV records.
+// Each record is turned into a URL "<scheme>://<target>:<port><path>".
+type SRVResolver struct {
+	// Service, Proto and Name form the queried name "_service._proto.name".
+	// If Service and Proto are empty, Name is queried directly.
+	Service, Proto, Name string
+
+	// Scheme is the URL scheme, "http" if empty.
+	Scheme string
+
+	// Path is the URL path of the remote handler.
+	Path string
+
+	// Resolver is used for DNS lookups, net.DefaultResolver if nil.
+	Resolver *net.Resolver
+}
+
+// Resolve implements liteproto.Resolver interface.
+func (r *SRVResolver) Resolve(ctx context.Context) ([]string, error) {
+	_, records, err := resolver(r.Resolver).LookupSRV(ctx, r.Service, r.Proto, r.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	urls := make([]string, 0, len(records))
+	for _, record := range records {
+		host := strings.TrimSuffix(record.Target, ".")
+		urls = append(urls, makeURL(r.Scheme, host, int(record.Port), r.Path))
+	}
+
+	return urls, nil
+}
+
+// DNSResolver is a liteproto.Resolver that discovers remote URLs with DNS A and AAAA records.
+// Each address is turned into a URL "<scheme>://<address>:<port><path>".
+type DNSResolver struct {
+	// Host is the queried name.
+	Host string
+
+	// Port is the port of the remote handler.
+	Port int
+
+	// Scheme is the URL scheme, "http" if empty.
+	Scheme string
+
+	// Path is the URL path of the remote handler.
+	Path string
+
+	// Resolver is used for DNS lookups, net.DefaultResolver if nil.
+	Resolver *net.Resolver
+}
+
+// Resolve implements liteproto.Resolver interface.
+func (r *DNSResolver) Resolve(ctx context.Context) ([]string, error) {
+	addrs, err := resolver(r.Resolver).LookupHost(ctx, r.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	urls := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		urls = append(urls, makeURL(r.Scheme, addr, r.Port, r.Path))
+	}
+
+	return urls, nil
+}
+
+// FileResolver is a liteproto.Resolver that reads remote URLs from a JSON file
+// containing an array of strings, for example a file maintained by a configuration management tool.
+// The file is parsed again only when its modification time or size changes.
+type FileResolver struct {
+	path string
+
+	urls    []string
+	modTime time.Time
+	size    int64
+	mx      sync.Mutex
+}
+
+// NewFileResolver creates a new FileResolver that watches the file at the provided path.
+func NewFileResolver(path string) *FileResolver {
+	return &FileResolver{path: path}
+}
+
+// Resolve implements liteproto.Resolver interface.
+func (r *FileResolver) Resolve(context.Context) ([]string, error) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	info, err := os.Stat(r.path)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.urls != nil && info.ModTime().Equal(r.modTime) && info.Size() == r.size {
+		return r.urls, nil
+	}
+
+	data, err := os.ReadFile(r.path)
+	if err != nil {
+		return nil, err
+	}
+
+	var urls []string
+	if err = json.Unmarshal(data, &urls); err != nil {
+		return nil, err
+	}
+
+	r.urls = urls
+	r.modTime = info.ModTime()
+	r.size = info.Size()
+
+	return urls, nil
+}
+
+func resolver(r *net.Resolver) *net.Resolver {
+	if r == nil {
+		return net.DefaultResolver
+	}
+	return r
+}
+
+func makeURL(scheme, host string, port int, path string) string {
+	if scheme == "" {
+		scheme = "http"
+	}
+
+	if path != "" && !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+
+	return scheme + "://" + net.JoinHostPort(host, strconv.Itoa(port)) + path
+}
+
+// make sure the resolvers implement liteproto.Resolver interface
+var (
+	_ liteproto.Resolver = (*SRVResolver)(nil)
+	_ liteproto.Resolver = (*DNSResolver)(nil)
+	_ liteproto.Resolver = (*FileResolver)(nil)
+)
@@ -0,0 +1,261 @@
+package liteprotohttp
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// DNS record types
+const (
+	dnsA    = 1
+	dnsAAAA = 28
+	dnsSRV  = 33
+)
+
+type srvRecord struct {
+	priority, weight, port uint16
+	target                 string
+}
+
+// dnsStub is a DNS server that answers A, AAAA and SRV queries from maps. Other names are answered
+// with NXDOMAIN, unknown types with an empty answer.
+type dnsStub struct {
+	hosts map[string][]net.IP
+	srv   map[string][]srvRecord
+	conn  net.PacketConn
+}
+
+func newDNSStub(t *testing.T, hosts map[string][]net.IP, srv map[string][]srvRecord) *net.Resolver {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	stub := &dnsStub{hosts: hosts, srv: srv, conn: conn}
+	go stub.serve()
+
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "udp", conn.LocalAddr().String())
+		},
+	}
+}
+
+func (s *dnsStub) serve() {
+	buf := make([]byte, 512)
+	for {
+		n, addr, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if response := s.answer(buf[:n]); response != nil {
+			_, _ = s.conn.WriteTo(response, addr)
+		}
+	}
+}
+
+func (s *dnsStub) answer(query []byte) []byte {
+	if len(query) < 12 {
+		return nil
+	}
+
+	// the name of the question
+	var labels []string
+	i := 12
+	for i < len(query) && query[i] != 0 {
+		n := int(query[i])
+		if i+1+n > len(query) {
+			return nil
+		}
+		labels = append(labels, string(query[i+1:i+1+n]))
+		i += 1 + n
+	}
+	if i+5 > len(query) {
+		return nil
+	}
+	name := strings.ToLower(strings.Join(labels, "."))
+	qtype := binary.BigEndian.Uint16(query[i+1:])
+	question := query[12 : i+5]
+
+	var answers [][]byte
+	var known bool
+
+	switch qtype {
+	case dnsA, dnsAAAA:
+		var ips []net.IP
+		ips, known = s.hosts[name]
+		for _, ip := range ips {
+			if ip4 := ip.To4(); ip4 != nil && qtype == dnsA {
+				answers = append(answers, ip4)
+			} else if ip4 == nil && qtype == dnsAAAA {
+				answers = append(answers, ip.To16())
+			}
+		}
+	case dnsSRV:
+		var records []srvRecord
+		records, known = s.srv[name]
+		for _, r := range records {
+			data := binary.BigEndian.AppendUint16(nil, r.priority)
+			data = binary.BigEndian.AppendUint16(data, r.weight)
+			data = binary.BigEndian.AppendUint16(data, r.port)
+			answers = append(answers, append(data, encodeName(r.target)...))
+		}
+	}
+	if !known {
+		_, known = s.hosts[name]
+		if !known {
+			_, known = s.srv[name]
+		}
+	}
+
+	flags := uint16(0x8180) // response, recursion desired and available
+	if !known {
+		flags |= 3 // NXDOMAIN
+	}
+
+	response := append([]byte(nil), query[:2]...)
+	response = binary.BigEndian.AppendUint16(response, flags)
+	response = binary.BigEndian.AppendUint16(response, 1)
+	response = binary.BigEndian.AppendUint16(response, uint16(len(answers)))
+	response = append(response, 0, 0, 0, 0)
+	response = append(response, question...)
+
+	for _, data := range answers {
+		response = append(response, 0xc0, 12) // pointer to the name of the question
+		response = binary.BigEndian.AppendUint16(response, qtype)
+		response = binary.BigEndian.AppendUint16(response, 1) // class IN
+		response = binary.BigEndian.AppendUint32(response, 60)
+		response = binary.BigEndian.AppendUint16(response, uint16(len(data)))
+		response = append(response, data...)
+	}
+
+	return response
+}
+
+func encodeName(name string) []byte {
+	var b []byte
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	return append(b, 0)
+}
+
+func TestSRVResolver(t *testing.T) {
+	resolver := newDNSStub(t, nil, map[string][]srvRecord{
+		"_liteproto._tcp.workers.test": {
+			{priority: 10, weight: 1, port: 8080, target: "b.workers.test."},
+			{priority: 20, weight: 1, port: 9090, target: "c.workers.test."},
+			{priority: 1, weight: 1, port: 80, target: "a.workers.test."},
+		},
+		"workers.test": {
+			{priority: 1, weight: 1, port: 80, target: "a.workers.test."},
+		},
+	})
+
+	tests := []struct {
+		resolver *SRVResolver
+		expected []string
+	}{
+		{
+			resolver: &SRVResolver{Service: "liteproto", Proto: "tcp", Name: "workers.test", Path: "tasks", Resolver: resolver},
+			expected: []string{"http://a.workers.test:80/tasks", "http://b.workers.test:8080/tasks", "http://c.workers.test:9090/tasks"},
+		},
+		{
+			resolver: &SRVResolver{Name: "workers.test", Scheme: "https", Resolver: resolver},
+			expected: []string{"https://a.workers.test:80"},
+		},
+	}
+
+	for _, test := range tests {
+		urls, err := test.resolver.Resolve(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(urls, test.expected) {
+			t.Errorf("expected %v, got %v", test.expected, urls)
+		}
+	}
+
+	if _, err := (&SRVResolver{Service: "liteproto", Proto: "tcp", Name: "missing.test", Resolver: resolver}).Resolve(context.Background()); err == nil {
+		t.Error("expected an error for a missing name")
+	}
+}
+
+func TestDNSResolver(t *testing.T) {
+	resolver := newDNSStub(t, map[string][]net.IP{
+		"workers.test": {net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2"), net.ParseIP("fd00::1")},
+	}, nil)
+
+	urls, err := (&DNSResolver{Host: "workers.test", Port: 8080, Path: "/tasks", Resolver: resolver}).Resolve(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sort.Strings(urls)
+	expected := []string{"http://10.0.0.1:8080/tasks", "http://10.0.0.2:8080/tasks", "http://[fd00::1]:8080/tasks"}
+	if !reflect.DeepEqual(urls, expected) {
+		t.Errorf("expected %v, got %v", expected, urls)
+	}
+
+	if _, err = (&DNSResolver{Host: "missing.test", Port: 80, Resolver: resolver}).Resolve(context.Background()); err == nil {
+		t.Error("expected an error for a missing name")
+	}
+}
+
+func TestFileResolver(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "workers.json")
+	resolver := NewFileResolver(path)
+
+	if _, err := resolver.Resolve(context.Background()); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+
+	write := func(content string, modTime time.Time) {
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	resolve := func(expected ...string) {
+		t.Helper()
+		urls, err := resolver.Resolve(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(urls, expected) {
+			t.Errorf("expected %v, got %v", expected, urls)
+		}
+	}
+
+	modTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+
+	write(`["http://a", "http://b"]`, modTime)
+	resolve("http://a", "http://b")
+
+	// same size and modification time, the file isn't parsed again
+	write(`["http://c", "http://d"]`, modTime)
+	resolve("http://a", "http://b")
+
+	write(`["http://c", "http://d"]`, modTime.Add(time.Second))
+	resolve("http://c", "http://d")
+
+	write(`["http://e"`, modTime.Add(2*time.Second))
+	if _, err := resolver.Resolve(context.Background()); err == nil {
+		t.Error("expected an error for an invalid file")
+	}
+}
@@ -0,0 +1,358 @@
+package liteprotohttp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/drone/liteproto/liteproto"
+	"github.com/drone/liteproto/liteproto/transport"
+)
+
+// streamHeader marks requests whose body is a binary message with a streamed payload.
+// The body is sent with chunked transfer encoding and the handler doesn't buffer the payload.
+const streamHeader = "Liteproto-Stream"
+
+const defaultStreamTimeout = 30 * time.Second
+
+// StreamExecer runs tasks whose payload is streamed. It should be passed as a parameter to RegisterStream method.
+// The payload reader is valid until Exec returns. If the caller sent an ordinary message,
+// the payload reader reads the request Data.
+type StreamExecer interface {
+	Exec(ctx context.Context, request liteproto.TaskRequest, payload io.Reader, client liteproto.ResponderClient)
+}
+
+// RegisterStream registers a StreamExecer to run tasks of the provided type. Payloads of streamed
+// requests of the type are passed to the execer as they arrive, without being held in memory.
+// Streamed requests of other types are read into the request Data.
+// Size limits (see WithBodyLimits and WithTypeLimit) can't be checked before the execer is invoked,
+// the payload reader fails once a limit is exceeded and the caller gets 413 (Request Entity Too Large),
+// which is counted by RejectedRequests.
+//
+// Streamed payloads can't be validated, RegisterStream panics if the type has a request schema (see RegisterSchema).
+func (h *ServerClient) RegisterStream(t string, execer StreamExecer) {
+	if !h.streams.addType(t) {
+		panic("liteprotohttp: stream type " + t + " has a request schema")
+	}
+	h.sc.RegisterWithResponder(t, streamExecer{streams: h.streams, execer: execer})
+}
+
+// CallStream executes a task on the remote server like CallWithDeadline, but the payload is read
+// from the provided reader and streamed to the remote server with chunked transfer encoding,
+// instead of being taken from the request Data. The call returns when the remote server consumed
+// the payload. Streaming is not supported in pull mode (see WithQueue).
+func (h *ServerClient) CallStream(ctx context.Context, r liteproto.TaskRequest, payload io.Reader, deadline time.Time) (response <-chan liteproto.TaskResponse, stop chan<- struct{}, err error) {
+	e := transport.RequestEnvelope(r, deadline, "")
+	e.Payload = payload
+
+	return h.sc.CallEnvelope(ctx, e)
+}
+
+// ResponsePayload returns the streamed payload of a response received by a call, or nil
+// if the response has no streamed payload. The returned reader must be read to the end or closed,
+// the responder waits until then. Responses whose payload is not claimed in time (see WithStreamTimeout)
+// are dropped.
+func (h *ServerClient) ResponsePayload(response liteproto.TaskResponse) io.ReadCloser {
+	if p := h.streams.takeResponse(response.ID); p != nil {
+		return p
+	}
+
+	return nil
+}
+
+// RespondStream sends a response whose payload is read from the provided reader and streamed
+// to the caller, which reads it with ResponsePayload. The ResponderClient must be one passed
+// to an execer of a ServerClient of this package. RespondStream returns when the caller consumed
+// the payload. Streamed responses are stored in the result store (see WithResultStore) without their payload
+// and are not relayed between replicas of a cluster (see WithCluster).
+func RespondStream(ctx context.Context, client liteproto.ResponderClient, status string, payload io.Reader) error {
+	responder, ok := client.(transport.EnvelopeResponder)
+	if !ok {
+		return errStreamNotSupported
+	}
+
+	return responder.RespondEnvelope(ctx, &transport.Envelope{Status: status, Payload: payload})
+}
+
+// errStreamNotSupported is returned by RespondStream for a ResponderClient that can't send a streamed payload.
+var errStreamNotSupported = errors.New("liteprotohttp: the responder doesn't support streamed payloads")
+
+// stream sends the envelope with the payload streamed as a binary message.
+func (c *caller) stream(ctx context.Context, url string, e *transport.Envelope, payload io.Reader) error {
+	encoding := c.encodings.choose(url, c.compression.preferred)
+
+	pr, pw := io.Pipe()
+
+	go func() {
+		pw.CloseWithError(writeStream(pw, e, payload, encoding, c.compression.level))
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, pr)
+	if err != nil {
+		_ = pr.Close()
+		return err
+	}
+
+	req.Header.Set("Content-Type", binaryer{}.ContentType())
+	req.Header.Set(streamHeader, "true")
+	if encoding != CompressionNone {
+		req.Header.Set("Content-Encoding", string(encoding))
+	}
+	if c.relay != nil {
+		req.Header.Set(relayedHeader, c.relay.sign(e.ID))
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	c.encodings.learn(url, resp.Header)
+
+	return checkResponse(resp)
+}
+
+func writeStream(w io.Writer, e *transport.Envelope, payload io.Reader, encoding Compression, level int) error {
+	wc, err := compressWriter(w, encoding, level)
+	if err != nil {
+		return err
+	}
+
+	if err = writeBinaryHeader(wc, e); err != nil {
+		return err
+	}
+
+	if _, err = io.Copy(wc, payload); err != nil {
+		return err
+	}
+
+	return wc.Close()
+}
+
+// payload is a streamed payload of a received message. The handler waits until it's done:
+// read to the end, failed or closed.
+type payload struct {
+	r    io.Reader
+	err  error // the error that ended reading, other than io.EOF; valid after done is closed
+	done chan struct{}
+	once sync.Once
+}
+
+func newPayload(r io.Reader) *payload {
+	return &payload{r: r, done: make(chan struct{})}
+}
+
+func (p *payload) Read(b []byte) (int, error) {
+	select {
+	case <-p.done:
+		return 0, io.ErrClosedPipe
+	default:
+	}
+
+	n, err := p.r.Read(b)
+	if err != nil {
+		if err != io.EOF {
+			p.err = err
+		}
+		p.finish()
+	}
+
+	return n, err
+}
+
+func (p *payload) Close() error {
+	p.finish()
+	return nil
+}
+
+func (p *payload) finish() {
+	p.once.Do(func() { close(p.done) })
+}
+
+// streams holds streamed payloads of received messages until they are claimed.
+type streams struct {
+	types     map[string]struct{}
+	validated map[string]struct{} // types with a request schema
+	requests  map[string]*payload
+	responses map[string][]*payload
+	mx        sync.Mutex
+}
+
+func newStreams() *streams {
+	return &streams{
+		types:     map[string]struct{}{},
+		validated: map[string]struct{}{},
+		requests:  map[string]*payload{},
+		responses: map[string][]*payload{},
+	}
+}
+
+// addType adds a stream type. It returns false if the type has a request schema.
+func (s *streams) addType(t string) bool {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if _, ok := s.validated[t]; ok {
+		return false
+	}
+
+	s.types[t] = struct{}{}
+	return true
+}
+
+// addValidated records a type with a request schema. It returns false if it's a stream type.
+func (s *streams) addValidated(t string) bool {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if _, ok := s.types[t]; ok {
+		return false
+	}
+
+	s.validated[t] = struct{}{}
+	return true
+}
+
+func (s *streams) isStreamType(t string) bool {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	_, ok := s.types[t]
+	return ok
+}
+
+// putRequest holds the payload of a request. It returns false if a payload of a request
+// with the same ID is already held.
+func (s *streams) putRequest(id string, p *payload) bool {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if _, ok := s.requests[id]; ok {
+		return false
+	}
+
+	s.requests[id] = p
+	return true
+}
+
+func (s *streams) takeRequest(id string) *payload {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	p := s.requests[id]
+	delete(s.requests, id)
+
+	return p
+}
+
+func (s *streams) putResponse(id string, p *payload) {
+	s.mx.Lock()
+	s.responses[id] = append(s.responses[id], p)
+	s.mx.Unlock()
+}
+
+func (s *streams) takeResponse(id string) *payload {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	queue := s.responses[id]
+	if len(queue) == 0 {
+		return nil
+	}
+
+	p := queue[0]
+	if len(queue) == 1 {
+		delete(s.responses, id)
+	} else {
+		s.responses[id] = queue[1:]
+	}
+
+	return p
+}
+
+// discard removes the payload if it wasn't claimed. It reports whether it was removed.
+func (s *streams) discard(id string, p *payload) bool {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if s.requests[id] == p {
+		delete(s.requests, id)
+		return true
+	}
+
+	queue := s.responses[id]
+	for i := range queue {
+		if queue[i] == p {
+			queue = append(queue[:i:i], queue[i+1:]...)
+			if len(queue) == 0 {
+				delete(s.responses, id)
+			} else {
+				s.responses[id] = queue
+			}
+			return true
+		}
+	}
+
+	return false
+}
+
+// errPayloadNotRead is returned by awaitPayload if the payload wasn't read.
+var errPayloadNotRead = errors.New("streamed payload not read")
+
+// awaitPayload waits until the payload of a received message is read. It returns the error that ended
+// reading, for example errBodyTooLarge if the payload exceeds a limit. The payload of a response
+// must be claimed within the stream timeout, otherwise it's dropped and awaitPayload returns errPayloadNotRead.
+func (h *ServerClient) awaitPayload(ctx context.Context, id string, p *payload) error {
+	timeout := h.opts.streamTimeout
+	if timeout <= 0 {
+		timeout = defaultStreamTimeout
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-p.done:
+		return p.err
+	case <-ctx.Done():
+		h.streams.discard(id, p)
+		return errPayloadNotRead
+	case <-timer.C:
+		if h.streams.discard(id, p) {
+			return errPayloadNotRead
+		}
+	}
+
+	// the payload is claimed, the reader decides how long it takes
+
+	select {
+	case <-p.done:
+		return p.err
+	case <-ctx.Done():
+		return errPayloadNotRead
+	}
+}
+
+// streamExecer passes the payload of a streamed request to a StreamExecer.
+type streamExecer struct {
+	streams *streams
+	execer  StreamExecer
+}
+
+func (e streamExecer) Exec(ctx context.Context, r liteproto.TaskRequest, rc liteproto.ResponderClient) {
+	var reader io.Reader
+
+	if p := e.streams.takeRequest(r.ID); p != nil {
+		defer p.finish()
+		reader = p
+	} else {
+		reader = bytes.NewReader(r.Data)
+	}
+
+	e.execer.Exec(ctx, r, reader, rc)
+}
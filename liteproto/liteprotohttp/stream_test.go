@@ -0,0 +1,140 @@
+package liteprotohttp
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/drone/liteproto/liteproto"
+)
+
+// streamEcho streams the request payload back to the caller, the final response holds its size.
+type streamEcho struct{}
+
+func (streamEcho) Exec(ctx context.Context, r liteproto.TaskRequest, payload io.Reader, rc liteproto.ResponderClient) {
+	data, err := io.ReadAll(payload)
+	if err != nil {
+		_ = rc.Respond(ctx, liteproto.StatusError, nil)
+		return
+	}
+
+	if err = RespondStream(ctx, rc, liteproto.StatusOK, bytes.NewReader(data)); err != nil {
+		_ = rc.Respond(ctx, liteproto.StatusError, nil)
+		return
+	}
+
+	_ = rc.Respond(ctx, liteproto.StatusSuccess, []byte(strconv.Itoa(len(data))))
+}
+
+func TestStreamRoundTrip(t *testing.T) {
+	large := bytes.Repeat([]byte("0123456789abcdef"), 1<<16) // 1 MiB
+
+	tests := []struct {
+		name     string
+		compress bool
+		data     []byte
+		stream   bool // send the payload with CallStream, not in the request Data
+	}{
+		{"empty", false, nil, true},
+		{"small", false, []byte("payload"), true},
+		{"large", false, large, true},
+		{"large compressed", true, large, true},
+		{"ordinary request", false, []byte(`"payload"`), false},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			caller := httptest.NewServer(nil)
+			defer caller.Close()
+
+			server := New("http://unused", test.compress, nil, nil, WithReplyToValidator(AllowReplyTo(caller.URL)))
+			server.RegisterStream("echo", streamEcho{})
+			srv := httptest.NewServer(server.Handler())
+			defer srv.Close()
+
+			client := New(srv.URL, test.compress, nil, nil, WithReplyTo(caller.URL))
+			caller.Config.Handler = client.Handler()
+
+			request := liteproto.TaskRequest{ID: "1", Type: "echo"}
+			deadline := time.Now().Add(5 * time.Second)
+
+			var (
+				response <-chan liteproto.TaskResponse
+				stop     chan<- struct{}
+				err      error
+			)
+			if test.stream {
+				response, stop, err = client.CallStream(context.Background(), request, bytes.NewReader(test.data), deadline)
+			} else {
+				request.Data = test.data
+				response, stop, err = client.CallWithDeadline(context.Background(), request, deadline)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer close(stop)
+
+			r := <-response
+			if r.Status != liteproto.StatusOK {
+				t.Fatalf("expected the streamed response, got %+v", r)
+			}
+
+			payload := client.ResponsePayload(r)
+			if payload == nil {
+				t.Fatal("expected a streamed payload")
+			}
+			got, err := io.ReadAll(payload)
+			_ = payload.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, test.data) {
+				t.Errorf("expected the payload to be echoed, got %d bytes instead of %d", len(got), len(test.data))
+			}
+
+			r = <-response
+			if r.Status != liteproto.StatusSuccess || string(r.Data) != strconv.Itoa(len(test.data)) {
+				t.Errorf("expected the size of the payload, got %+v", r)
+			}
+			if client.ResponsePayload(r) != nil {
+				t.Error("expected no streamed payload for an ordinary response")
+			}
+		})
+	}
+}
+
+// dataEcho responds with the request data.
+type dataEcho struct{}
+
+func (dataEcho) Exec(ctx context.Context, r liteproto.TaskRequest, rc liteproto.ResponderClient) {
+	_ = rc.Respond(ctx, liteproto.StatusSuccess, r.Data)
+}
+
+// TestStreamOtherType checks that a streamed request of a type without a StreamExecer is read into the Data.
+func TestStreamOtherType(t *testing.T) {
+	caller := httptest.NewServer(nil)
+	defer caller.Close()
+
+	server := New("http://unused", false, nil, nil, WithReplyToValidator(AllowReplyTo(caller.URL)))
+	server.RegisterWithResponder("data", dataEcho{})
+	srv := httptest.NewServer(server.Handler())
+	defer srv.Close()
+
+	client := New(srv.URL, false, nil, nil, WithReplyTo(caller.URL))
+	caller.Config.Handler = client.Handler()
+
+	response, stop, err := client.CallStream(context.Background(), liteproto.TaskRequest{ID: "1", Type: "data"}, bytes.NewReader([]byte(`"payload"`)), time.Now().Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer close(stop)
+
+	if r := <-response; r.Status != liteproto.StatusSuccess || string(r.Data) != `"payload"` {
+		t.Errorf("expected the payload in the request data, got %+v", r)
+	}
+}
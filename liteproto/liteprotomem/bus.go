@@ -0,0 +1,201 @@
+// Package liteprotomem is a liteproto transport that passes messages between ServerClients
+// in the same process. It's meant for tests of execers and callers and for single-process deployments
+// of code written against liteproto.ServerClient.
+//
+// Messages have the same semantics as with network transports: requests are executed asynchronously,
+// deadlines are enforced, unknown task types are reported with liteproto.ErrUnknownType and payloads
+// are copied, so the sender and the receiver never share memory.
+package liteprotomem
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/drone/liteproto/liteproto"
+	"github.com/drone/liteproto/liteproto/transport"
+)
+
+// Option configures an optional feature of a Bus.
+type Option func(*Bus)
+
+// WithLatency delays delivery of every message by a random duration between min and max,
+// simulating a network. Use the same value for both to get a constant delay.
+func WithLatency(min, max time.Duration) Option {
+	return func(b *Bus) {
+		b.minLatency, b.maxLatency = min, max
+	}
+}
+
+// Bus connects ServerClients by name.
+type Bus struct {
+	members map[string]*ServerClient
+	mx      sync.RWMutex
+
+	minLatency, maxLatency time.Duration
+}
+
+// NewBus creates a new empty Bus.
+func NewBus(opts ...Option) *Bus {
+	b := &Bus{members: map[string]*ServerClient{}}
+
+	for _, opt := range opts {
+		opt(b)
+	}
+
+	return b
+}
+
+// Pair creates two ServerClients, named "a" and "b", that call each other.
+// Parameter 'logger' can be nil.
+func Pair(logger *log.Logger, opts ...Option) (a, b *ServerClient) {
+	bus := NewBus(opts...)
+	return bus.Join("a", "b", logger), bus.Join("b", "a", logger)
+}
+
+// Join creates a new ServerClient connected to the bus under the provided name.
+// Calls made with it are delivered to the member named 'remote', which doesn't have to exist yet.
+// Parameter 'logger' can be nil. Join panics if the name is already taken.
+func (b *Bus) Join(name, remote string, logger *log.Logger) *ServerClient {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	if _, ok := b.members[name]; ok {
+		panic("liteprotomem: name " + name + " already joined")
+	}
+
+	sc := &ServerClient{name: name, bus: b}
+	sc.ServerClient = transport.NewServerClient(transport.Config{
+		Delivery: b,
+		Addr:     remote,
+		ReplyTo:  name,
+		Logger:   logger,
+	})
+
+	b.members[name] = sc
+
+	return sc
+}
+
+// Leave disconnects the member with the provided name. Messages sent to it fail with liteproto.ErrUnknownPeer.
+func (b *Bus) Leave(name string) {
+	b.mx.Lock()
+	delete(b.members, name)
+	b.mx.Unlock()
+}
+
+// Members returns names of all members of the bus.
+func (b *Bus) Members() []string {
+	b.mx.RLock()
+	defer b.mx.RUnlock()
+
+	names := make([]string, 0, len(b.members))
+	for name := range b.members {
+		names = append(names, name)
+	}
+
+	return names
+}
+
+// Deliver implements transport.Delivery. The address is the name of a member.
+func (b *Bus) Deliver(ctx context.Context, addr string, e *transport.Envelope) error {
+	if err := b.delay(ctx); err != nil {
+		return err
+	}
+
+	b.mx.RLock()
+	member, ok := b.members[addr]
+	b.mx.RUnlock()
+
+	if !ok {
+		return liteproto.ErrUnknownPeer
+	}
+
+	err := member.Receive(ctx, clone(e))
+	if err == liteproto.ErrNotSubscribed {
+		// like network transports, accept responses that nobody awaits
+		return nil
+	}
+
+	return err
+}
+
+func (b *Bus) delay(ctx context.Context) error {
+	d := b.minLatency
+	if b.maxLatency > b.minLatency {
+		d += time.Duration(rand.Int63n(int64(b.maxLatency - b.minLatency)))
+	}
+
+	if d <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// clone makes a deep copy of the envelope. Like a network transport, it copies only the fields
+// that are sent to remote servers.
+func clone(e *transport.Envelope) *transport.Envelope {
+	c := transport.Envelope{
+		ID:       e.ID,
+		Type:     e.Type,
+		Status:   e.Status,
+		Data:     e.Data,
+		Deadline: e.Deadline,
+		ReplyTo:  e.ReplyTo,
+		Metadata: e.Metadata,
+	}
+
+	if e.Data != nil {
+		c.Data = append([]byte(nil), e.Data...)
+	}
+
+	if e.Metadata != nil {
+		c.Metadata = make(map[string]string, len(e.Metadata))
+		for k, v := range e.Metadata {
+			c.Metadata[k] = v
+		}
+	}
+
+	return &c
+}
+
+// ServerClient is a member of a Bus. It implements liteproto.ServerClient.
+type ServerClient struct {
+	*transport.ServerClient
+
+	name string
+	bus  *Bus
+}
+
+// Name returns the name under which the ServerClient joined the bus.
+func (sc *ServerClient) Name() string {
+	return sc.name
+}
+
+// Client returns a client for calling the member of the bus with the provided name.
+// Responses are received by this ServerClient.
+func (sc *ServerClient) Client(name string) liteproto.Client {
+	return sc.ClientFor(sc.bus, name)
+}
+
+// Close leaves the bus.
+func (sc *ServerClient) Close() error {
+	sc.bus.mx.Lock()
+	if sc.bus.members[sc.name] == sc {
+		delete(sc.bus.members, sc.name)
+	}
+	sc.bus.mx.Unlock()
+
+	return nil
+}
@@ -0,0 +1,149 @@
+package liteprotomem
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/drone/liteproto/liteproto"
+)
+
+// echo responds with the request data and then overwrites it, which the caller must not see.
+type echo struct{}
+
+func (echo) Exec(ctx context.Context, r liteproto.TaskRequest, rc liteproto.ResponderClient) {
+	_ = rc.Respond(ctx, liteproto.StatusOK, r.Data)
+	_ = rc.Respond(ctx, liteproto.StatusSuccess, r.Data)
+	copy(r.Data, "!!!")
+}
+
+// collect reads responses until a final response arrives or the channel is closed.
+func collect(t *testing.T, ch <-chan liteproto.TaskResponse, stop chan<- struct{}) []liteproto.TaskResponse {
+	t.Helper()
+	defer close(stop)
+
+	var responses []liteproto.TaskResponse
+	for r := range ch {
+		responses = append(responses, r)
+		if r.Status == liteproto.StatusSuccess || r.Status == liteproto.StatusError {
+			break
+		}
+	}
+
+	return responses
+}
+
+func TestRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []Option
+	}{
+		{"no latency", nil},
+		{"constant latency", []Option{WithLatency(5*time.Millisecond, 5*time.Millisecond)}},
+		{"random latency", []Option{WithLatency(time.Millisecond, 5*time.Millisecond)}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a, b := Pair(nil, test.opts...)
+			a.RegisterWithResponder("echo", echo{})
+			b.RegisterWithResponder("echo", echo{})
+
+			if err := a.Call(context.Background(), liteproto.TaskRequest{ID: "1", Type: "unknown"}); err != liteproto.ErrUnknownType {
+				t.Errorf("expected ErrUnknownType, got %v", err)
+			}
+
+			for _, caller := range []*ServerClient{a, b} {
+				data := []byte(`"x"`)
+
+				ch, stop, err := caller.CallWithDeadline(context.Background(), liteproto.TaskRequest{ID: "2", Type: "echo", Data: data}, time.Now().Add(time.Second))
+				if err != nil {
+					t.Fatalf("%s: %v", caller.Name(), err)
+				}
+
+				responses := collect(t, ch, stop)
+				if len(responses) != 2 || string(responses[0].Data) != `"x"` || string(responses[1].Data) != `"x"` {
+					t.Errorf("%s: expected 2 unchanged responses, got %+v", caller.Name(), responses)
+				}
+				if string(data) != `"x"` {
+					t.Errorf("%s: the request data was changed by the execer: %s", caller.Name(), data)
+				}
+			}
+		})
+	}
+}
+
+func TestLatency(t *testing.T) {
+	a, b := Pair(nil, WithLatency(20*time.Millisecond, 20*time.Millisecond))
+	b.RegisterWithResponder("echo", echo{})
+
+	start := time.Now()
+
+	// the request and the responses are delayed
+	ch, stop, err := a.CallWithResponse(context.Background(), liteproto.TaskRequest{ID: "1", Type: "echo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	collect(t, ch, stop)
+
+	if d := time.Since(start); d < 40*time.Millisecond {
+		t.Errorf("expected the call to take at least 40ms, took %s", d)
+	}
+
+	// the deadline applies to the delay as well
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+
+	if err = a.Call(ctx, liteproto.TaskRequest{ID: "2", Type: "echo"}); err != context.DeadlineExceeded {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+}
+
+func TestMembers(t *testing.T) {
+	bus := NewBus()
+	a := bus.Join("a", "b", nil)
+
+	if err := a.Call(context.Background(), liteproto.TaskRequest{ID: "1", Type: "echo"}); err != liteproto.ErrUnknownPeer {
+		t.Errorf("expected ErrUnknownPeer before b joined, got %v", err)
+	}
+
+	b := bus.Join("b", "a", nil)
+	b.RegisterWithResponder("echo", echo{})
+
+	members := bus.Members()
+	sort.Strings(members)
+	if len(members) != 2 || members[0] != "a" || members[1] != "b" {
+		t.Errorf("expected members a and b, got %v", members)
+	}
+
+	if err := a.Call(context.Background(), liteproto.TaskRequest{ID: "2", Type: "echo"}); err != nil {
+		t.Errorf("expected the call to succeed, got %v", err)
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("expected Join with a taken name to panic")
+			}
+		}()
+		bus.Join("b", "a", nil)
+	}()
+
+	_ = b.Close()
+	if err := a.Call(context.Background(), liteproto.TaskRequest{ID: "3", Type: "echo"}); err != liteproto.ErrUnknownPeer {
+		t.Errorf("expected ErrUnknownPeer after b closed, got %v", err)
+	}
+
+	// b can join again, like a reconnecting client
+	b = bus.Join("b", "a", nil)
+	b.RegisterWithResponder("echo", echo{})
+	if err := a.Call(context.Background(), liteproto.TaskRequest{ID: "4", Type: "echo"}); err != nil {
+		t.Errorf("expected the call to succeed after b joined again, got %v", err)
+	}
+
+	bus.Leave("b")
+	if err := a.Call(context.Background(), liteproto.TaskRequest{ID: "5", Type: "echo"}); err != liteproto.ErrUnknownPeer {
+		t.Errorf("expected ErrUnknownPeer after b left, got %v", err)
+	}
+}
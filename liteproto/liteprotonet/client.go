@@ -0,0 +1,76 @@
+package liteprotonet
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"log"
+	"net"
+
+	"github.com/drone/liteproto/liteproto/transport"
+)
+
+// Client keeps a pool of connections open to a Server, reconnecting automatically when they break.
+//
+// Client implements liteproto.ServerClient. Calls awaiting responses survive reconnections,
+// see transport.ConnClient.
+type Client struct {
+	*transport.ConnClient
+
+	network, address string
+
+	opts   options
+	key    string // sent in the hello frame of every connection of the pool
+	dialer net.Dialer
+}
+
+// Dial creates a new Client for the Server at the network address, for example "tcp", "host:7000"
+// or "unix", "/run/app.sock". The connections are established in the background, calls made before
+// they are established wait for them. Parameter 'logger' can be nil.
+func Dial(network, address string, logger *log.Logger, opts ...Option) *Client {
+	o := newOptions(opts)
+
+	if o.poolSize < 1 {
+		o.poolSize = 1
+	}
+
+	c := &Client{
+		ConnClient: transport.NewConnClient(o.ConnOptions, logger),
+		network:    network,
+		address:    address,
+		opts:       o,
+		key:        transport.RandomID(),
+	}
+
+	for i := 0; i < o.poolSize; i++ {
+		c.Keep(network+" "+address, c.connect, false)
+	}
+
+	return c
+}
+
+func (c *Client) connect(ctx context.Context) (*transport.Session, error) {
+	conn, err := c.dialer.DialContext(ctx, c.network, c.address)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.opts.tlsConfig != nil {
+		tlsConn := tls.Client(conn, c.opts.tlsConfig)
+		if err = tlsConn.HandshakeContext(ctx); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+
+	fc := transport.NewStreamConn(conn, c.opts.maxFrameSize)
+
+	data, _ := json.Marshal(hello{Client: c.ID(), Key: c.key, Credentials: c.opts.credentials})
+	if err = fc.WriteFrame(data); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	return transport.NewSession(fc, c.SessionReceiver()), nil
+}
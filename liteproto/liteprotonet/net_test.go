@@ -0,0 +1,265 @@
+package liteprotonet
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/drone/liteproto/liteproto"
+	"github.com/drone/liteproto/liteproto/transport"
+)
+
+// counter responds three times with a pause before each response. The last response has StatusSuccess.
+type counter struct {
+	pause time.Duration
+}
+
+func (e counter) Exec(ctx context.Context, r liteproto.TaskRequest, rc liteproto.ResponderClient) {
+	for _, status := range []string{liteproto.StatusOK, liteproto.StatusOK, liteproto.StatusSuccess} {
+		time.Sleep(e.pause)
+		_ = rc.Respond(ctx, status, r.Data)
+	}
+}
+
+// dropListener is a net.Listener that can break all accepted connections.
+type dropListener struct {
+	net.Listener
+	conns []net.Conn
+	mx    sync.Mutex
+}
+
+func (l *dropListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err == nil {
+		l.mx.Lock()
+		l.conns = append(l.conns, c)
+		l.mx.Unlock()
+	}
+	return c, err
+}
+
+func (l *dropListener) drop() {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+
+	for _, c := range l.conns {
+		_ = c.Close()
+	}
+	l.conns = nil
+}
+
+func serve(t *testing.T, s *Server) (address string, l *dropListener) {
+	tl, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l = &dropListener{Listener: tl}
+	go func() { _ = s.Serve(l) }()
+	t.Cleanup(func() { _ = s.Close() })
+
+	return tl.Addr().String(), l
+}
+
+func dial(t *testing.T, address string, opts ...Option) *Client {
+	c := Dial("tcp", address, nil, opts...)
+	t.Cleanup(func() { _ = c.Close() })
+
+	return c
+}
+
+// waitConnected waits until the client with the provided ID is connected to the server.
+func waitConnected(t *testing.T, s *Server, clientID string) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		err := s.Client(clientID).Call(context.Background(), liteproto.TaskRequest{ID: transport.RandomID(), Type: "count"})
+		if err == nil {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("client %s didn't connect: %v", clientID, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// collect reads responses until a final response arrives or the channel is closed.
+func collect(t *testing.T, ch <-chan liteproto.TaskResponse, stop chan<- struct{}) []liteproto.TaskResponse {
+	t.Helper()
+	defer close(stop)
+
+	var responses []liteproto.TaskResponse
+	for r := range ch {
+		responses = append(responses, r)
+		if r.Status == liteproto.StatusSuccess || r.Status == liteproto.StatusError {
+			break
+		}
+	}
+
+	return responses
+}
+
+func TestRoundTrip(t *testing.T) {
+	s := NewServer(nil, WithResumeTimeout(100*time.Millisecond))
+	s.RegisterWithResponder("count", counter{})
+
+	address, _ := serve(t, s)
+
+	c := dial(t, address, WithClientID("worker"), WithPoolSize(3))
+	c.RegisterWithResponder("count", counter{})
+	waitConnected(t, s, "worker")
+
+	if err := c.Call(context.Background(), liteproto.TaskRequest{ID: "1", Type: "unknown"}); err != liteproto.ErrUnknownType {
+		t.Errorf("expected ErrUnknownType, got %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		client liteproto.Client
+	}{
+		{"client calls server", c},
+		{"server calls client", s.Client("worker")},
+	}
+
+	for _, test := range tests {
+		// more calls than connections in the pool
+		for i := 0; i < 5; i++ {
+			ch, stop, err := test.client.CallWithDeadline(context.Background(), liteproto.TaskRequest{ID: transport.RandomID(), Type: "count", Data: []byte(`"x"`)}, time.Now().Add(time.Second))
+			if err != nil {
+				t.Fatalf("%s: %v", test.name, err)
+			}
+
+			responses := collect(t, ch, stop)
+			if len(responses) != 3 || string(responses[0].Data) != `"x"` {
+				t.Errorf("%s: expected 3 responses, got %+v", test.name, responses)
+			}
+		}
+	}
+
+	if err := s.Client("nobody").Call(context.Background(), liteproto.TaskRequest{ID: "2", Type: "count"}); err != liteproto.ErrUnknownPeer {
+		t.Errorf("expected ErrUnknownPeer, got %v", err)
+	}
+}
+
+func TestResume(t *testing.T) {
+	s := NewServer(nil)
+	s.RegisterWithResponder("count", counter{pause: 50 * time.Millisecond})
+
+	address, l := serve(t, s)
+
+	c := dial(t, address)
+
+	ch, stop, err := c.CallWithDeadline(context.Background(), liteproto.TaskRequest{ID: "1", Type: "count"}, time.Now().Add(2*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// break the connection while the task runs, the responses wait for the client to reconnect
+	time.Sleep(60 * time.Millisecond)
+	l.drop()
+
+	if responses := collect(t, ch, stop); len(responses) != 3 {
+		t.Errorf("expected all 3 responses after reconnecting, got %+v", responses)
+	}
+}
+
+func TestClientIDConflict(t *testing.T) {
+	authenticate := func(conn net.Conn, clientID, credentials string) error {
+		if credentials != "token "+clientID {
+			return errors.New("invalid token")
+		}
+		return nil
+	}
+
+	tests := []struct {
+		name         string
+		authenticate func(conn net.Conn, clientID, credentials string) error
+		hello        func(c *Client) hello
+		accepted     bool
+	}{
+		{
+			name:     "same pool",
+			hello:    func(c *Client) hello { return hello{Client: "worker", Key: c.key} },
+			accepted: true,
+		},
+		{
+			name:  "other key",
+			hello: func(c *Client) hello { return hello{Client: "worker", Key: "other"} },
+		},
+		{
+			name:  "no key",
+			hello: func(c *Client) hello { return hello{Client: "worker"} },
+		},
+		{
+			name:         "authenticated",
+			authenticate: authenticate,
+			hello:        func(c *Client) hello { return hello{Client: "worker", Credentials: "token worker"} },
+			accepted:     true,
+		},
+		{
+			name:         "wrong credentials",
+			authenticate: authenticate,
+			hello:        func(c *Client) hello { return hello{Client: "worker", Key: c.key, Credentials: "token other"} },
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := NewServer(nil, WithAuthenticator(test.authenticate))
+			address, _ := serve(t, s)
+
+			c := dial(t, address, WithClientID("worker"), WithCredentials("token worker"))
+			c.RegisterWithResponder("count", counter{})
+			waitConnected(t, s, "worker")
+
+			conn, err := net.Dial("tcp", address)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			fc := transport.NewStreamConn(conn, 0)
+			data, _ := json.Marshal(test.hello(c))
+			if err = fc.WriteFrame(data); err != nil {
+				t.Fatal(err)
+			}
+
+			// the server closes rejected connections, accepted ones stay open
+			_ = conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+			_, err = fc.ReadFrame()
+
+			var ne net.Error
+			accepted := errors.As(err, &ne) && ne.Timeout()
+			if accepted != test.accepted {
+				t.Errorf("expected accepted=%t, got error %v", test.accepted, err)
+			}
+		})
+	}
+}
+
+func TestCloseWhileConnecting(t *testing.T) {
+	s := NewServer(nil)
+	address, _ := serve(t, s)
+
+	for i := 0; i < 20; i++ {
+		c := Dial("tcp", address, nil, WithPoolSize(2))
+
+		closed := make(chan struct{})
+		go func() {
+			_ = c.Close()
+			close(closed)
+		}()
+
+		select {
+		case <-closed:
+		case <-time.After(time.Second):
+			t.Fatal("Close didn't return")
+		}
+	}
+}
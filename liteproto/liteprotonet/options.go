@@ -0,0 +1,106 @@
+package liteprotonet
+
+import (
+	"crypto/tls"
+	"net"
+	"time"
+
+	"github.com/drone/liteproto/liteproto"
+	"github.com/drone/liteproto/liteproto/transport"
+)
+
+// Option configures an optional feature of a Server or a Client.
+type Option func(*options)
+
+type options struct {
+	transport.ConnOptions
+
+	tlsConfig    *tls.Config
+	poolSize     int
+	maxFrameSize int
+	credentials  string
+	authenticate func(conn net.Conn, clientID, credentials string) error
+}
+
+func newOptions(opts []Option) options {
+	o := options{
+		poolSize: 1,
+	}
+
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return o
+}
+
+// WithTLS enables TLS. A Server uses the config for accepted connections, a Client for dialed ones.
+func WithTLS(config *tls.Config) Option {
+	return func(o *options) {
+		o.tlsConfig = config
+	}
+}
+
+// WithPoolSize sets the number of connections a Client keeps open to the Server. The default is 1.
+// Messages are spread across the connections.
+func WithPoolSize(size int) Option {
+	return func(o *options) {
+		o.poolSize = size
+	}
+}
+
+// WithMaxFrameSize sets the maximum size of a received frame, an encoded message.
+// The default is transport.DefaultMaxFrameSize. Connections that send larger frames are closed.
+func WithMaxFrameSize(size int) Option {
+	return func(o *options) {
+		o.maxFrameSize = size
+	}
+}
+
+// WithResumeTimeout sets transport.ConnOptions.ResumeTimeout of a Server or a Client.
+func WithResumeTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.ResumeTimeout = d
+	}
+}
+
+// WithClientID sets transport.ConnOptions.ClientID of a Client, sent to the Server
+// in the first frame of every connection of the pool.
+func WithClientID(id string) Option {
+	return func(o *options) {
+		o.ClientID = id
+	}
+}
+
+// WithResultStore sets transport.ConnOptions.ResultStore of a Server or a Client.
+func WithResultStore(store liteproto.ResultStore) Option {
+	return func(o *options) {
+		o.ResultStore = store
+	}
+}
+
+// WithOrphanHandler sets transport.ConnOptions.OrphanHandler of a Server or a Client.
+func WithOrphanHandler(f func(response liteproto.TaskResponse)) Option {
+	return func(o *options) {
+		o.OrphanHandler = f
+	}
+}
+
+// WithCredentials sets credentials, for example a token, that a Client sends to the Server
+// when it opens a connection. The Server checks them with the authenticator set with WithAuthenticator.
+func WithCredentials(credentials string) Option {
+	return func(o *options) {
+		o.credentials = credentials
+	}
+}
+
+// WithAuthenticator sets a function that verifies that the client opening a connection is allowed
+// to use the client ID it presents, for example with the credentials set by WithCredentials
+// or with the certificate of a TLS connection. Connections it returns an error for are closed.
+// Without an authenticator a connection with the ID of a connected client is accepted only
+// if it belongs to the same pool, other connections are closed until the client disconnects.
+func WithAuthenticator(f func(conn net.Conn, clientID, credentials string) error) Option {
+	return func(o *options) {
+		o.authenticate = f
+	}
+}
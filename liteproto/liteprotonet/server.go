@@ -0,0 +1,202 @@
+// Package liteprotonet is a liteproto transport that multiplexes messages over persistent TCP
+// or Unix domain socket connections, using length-prefixed frames.
+//
+// A Client opens one or more connections to a Server. Requests and responses flow in both directions
+// over the connections, every message is acknowledged by the receiving side and the number of
+// unacknowledged messages per connection is limited (see transport.Session).
+package liteprotonet
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/drone/liteproto/liteproto/transport"
+)
+
+// helloTimeout is the time in which a client must identify itself after connecting.
+const helloTimeout = 10 * time.Second
+
+// hello is the first frame a client sends on a new connection.
+type hello struct {
+	Client string `json:"client"`
+
+	// Key is generated by the client, all connections of its pool present the same key.
+	Key string `json:"key,omitempty"`
+
+	// Credentials are set with WithCredentials and checked by the authenticator of the server.
+	Credentials string `json:"credentials,omitempty"`
+}
+
+// Server accepts connections from Clients.
+//
+// Server implements liteproto.ServerClient, see transport.ConnServer.
+type Server struct {
+	*transport.ConnServer
+
+	opts     options
+	sessions *transport.Sessions
+	logger   *log.Logger
+
+	listeners map[net.Listener]struct{}
+	mx        sync.Mutex
+	wg        sync.WaitGroup
+	closed    bool
+}
+
+// NewServer creates a new Server. Parameter 'logger' can be nil.
+func NewServer(logger *log.Logger, opts ...Option) *Server {
+	o := newOptions(opts)
+
+	if logger == nil {
+		logger = log.Default()
+	}
+
+	s := &Server{
+		ConnServer: transport.NewConnServer(o.ConnOptions, logger),
+		opts:       o,
+		logger:     logger,
+		listeners:  map[net.Listener]struct{}{},
+	}
+	s.sessions = s.Sessions()
+
+	return s
+}
+
+// ListenAndServe listens on the network address, for example "tcp", ":7000" or "unix", "/run/app.sock",
+// and serves connections until the Server is closed.
+func (s *Server) ListenAndServe(network, address string) error {
+	l, err := net.Listen(network, address)
+	if err != nil {
+		return err
+	}
+
+	return s.Serve(l)
+}
+
+// Serve accepts connections on the listener until the Server is closed. It always closes the listener.
+func (s *Server) Serve(l net.Listener) error {
+	if s.opts.tlsConfig != nil {
+		l = tls.NewListener(l, s.opts.tlsConfig)
+	}
+
+	s.mx.Lock()
+	if s.closed {
+		s.mx.Unlock()
+		_ = l.Close()
+		return net.ErrClosed
+	}
+	s.listeners[l] = struct{}{}
+	s.mx.Unlock()
+
+	defer func() {
+		s.mx.Lock()
+		delete(s.listeners, l)
+		s.mx.Unlock()
+		_ = l.Close()
+	}()
+
+	var delay time.Duration
+
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			s.mx.Lock()
+			closed := s.closed
+			s.mx.Unlock()
+
+			if closed {
+				return net.ErrClosed
+			}
+
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
+
+			// like net/http, back off and retry, accepting can fail for a while,
+			// for example when the process runs out of file descriptors
+			if delay == 0 {
+				delay = 5 * time.Millisecond
+			} else if delay *= 2; delay > time.Second {
+				delay = time.Second
+			}
+
+			s.logger.Printf("accepting a connection failed: %s; retrying in %s", err.Error(), delay)
+			time.Sleep(delay)
+			continue
+		}
+
+		delay = 0
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.serveConn(c)
+		}()
+	}
+}
+
+// Close stops accepting connections and closes connections of all clients.
+func (s *Server) Close() error {
+	s.mx.Lock()
+	s.closed = true
+	for l := range s.listeners {
+		_ = l.Close()
+	}
+	s.mx.Unlock()
+
+	_ = s.ConnServer.Close()
+	s.wg.Wait()
+
+	return nil
+}
+
+func (s *Server) serveConn(c net.Conn) {
+	fc := transport.NewStreamConn(c, s.opts.maxFrameSize)
+
+	_ = c.SetReadDeadline(time.Now().Add(helloTimeout))
+
+	data, err := fc.ReadFrame()
+	if err != nil {
+		_ = c.Close()
+		return
+	}
+
+	var h hello
+	if err = json.Unmarshal(data, &h); err != nil || h.Client == "" {
+		_ = c.Close()
+		return
+	}
+
+	if s.opts.authenticate != nil {
+		if err = s.opts.authenticate(c, h.Client, h.Credentials); err != nil {
+			_ = c.Close()
+			return
+		}
+	}
+
+	_ = c.SetReadDeadline(time.Time{})
+
+	session := transport.NewSession(fc, s.SessionReceiver())
+
+	// an authenticated connection joins the pool of the client, an unauthenticated one
+	// only if it presents the key of the pool, so it can't take over the responses of another client
+	var added bool
+	if s.opts.authenticate != nil {
+		added = s.sessions.Add(h.Client, session)
+	} else {
+		added = s.sessions.AddWithKey(h.Client, h.Key, session)
+	}
+
+	if !added {
+		_ = session.Close()
+		return
+	}
+	defer s.sessions.Remove(h.Client, session)
+
+	_ = session.Run()
+}
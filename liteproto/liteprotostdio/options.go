@@ -0,0 +1,39 @@
+package liteprotostdio
+
+import "time"
+
+// Option configures an optional feature of a Process or a Plugin.
+type Option func(*options)
+
+type options struct {
+	maxFrameSize  int
+	resumeTimeout time.Duration
+}
+
+func newOptions(opts []Option) options {
+	o := options{
+		resumeTimeout: 30 * time.Second,
+	}
+
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return o
+}
+
+// WithMaxFrameSize sets the maximum size of a received frame, an encoded message.
+// The default is transport.DefaultMaxFrameSize.
+func WithMaxFrameSize(size int) Option {
+	return func(o *options) {
+		o.maxFrameSize = size
+	}
+}
+
+// WithResumeTimeout sets how long calls to a plugin wait while the plugin process is being restarted.
+// The default is 30 seconds.
+func WithResumeTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.resumeTimeout = d
+	}
+}
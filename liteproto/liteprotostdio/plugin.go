@@ -0,0 +1,174 @@
+// Package liteprotostdio runs task executors as separate processes, plugins, that speak liteproto
+// over their standard input and output.
+//
+// The parent launches a plugin with Launch. The plugin registers its execers on a Plugin
+// and calls Serve(os.Stdin, os.Stdout). The task types registered by the plugin are advertised
+// to the parent, which registers them on its own liteproto.Server and forwards the tasks
+// to the plugin. Crashed plugins are restarted.
+//
+// Messages are framed like in package liteprotonet. A plugin must not write anything else
+// to its standard output, it should log to standard error.
+package liteprotostdio
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"sort"
+	"sync"
+
+	"github.com/drone/liteproto/liteproto"
+	"github.com/drone/liteproto/liteproto/transport"
+)
+
+// typeDone is the type of the response a plugin sends after an execer returned.
+// It ends forwarding of responses on the parent side and is never passed to the caller.
+const typeDone = "liteproto.stdio.done"
+
+// hello is the first frame a plugin sends, it advertises the registered task types.
+type hello struct {
+	Types []string `json:"types"`
+}
+
+// Plugin is the plugin side of the transport. It implements liteproto.ServerClient,
+// calls made with it are sent to the parent.
+type Plugin struct {
+	*transport.ServerClient
+
+	opts     options
+	sessions *transport.Sessions
+
+	types map[string]struct{}
+	mx    sync.Mutex
+}
+
+// NewPlugin creates a new Plugin. Parameter 'logger' can be nil.
+func NewPlugin(logger *log.Logger, opts ...Option) *Plugin {
+	o := newOptions(opts)
+
+	p := &Plugin{
+		opts:     o,
+		sessions: transport.NewSessions(o.resumeTimeout),
+		types:    map[string]struct{}{},
+	}
+
+	p.ServerClient = transport.NewServerClient(transport.Config{
+		Delivery: sessionDelivery{p.sessions},
+		Logger:   logger,
+	})
+
+	return p
+}
+
+// Register registers an Execer and advertises the task type to the parent.
+// Execers must be registered before Serve is called.
+func (p *Plugin) Register(t string, execer liteproto.Execer) {
+	p.advertise(t)
+	p.ServerClient.RegisterWithResponder(t, doneExecer{execer: execerAdapter{execer}})
+}
+
+// RegisterWithResponder registers an ExecerWithResponder and advertises the task type to the parent.
+// Execers must be registered before Serve is called.
+func (p *Plugin) RegisterWithResponder(t string, execer liteproto.ExecerWithResponder) {
+	p.advertise(t)
+	p.ServerClient.RegisterWithResponder(t, doneExecer{execer: execer})
+}
+
+// RegisterCatchAll registers an ExecerWithResponder for all task types that are not registered.
+// Catch-all execers are not advertised, the parent only sends tasks of the advertised types.
+func (p *Plugin) RegisterCatchAll(execer liteproto.ExecerWithResponder) {
+	p.ServerClient.RegisterCatchAll(doneExecer{execer: execer})
+}
+
+// Serve advertises the registered task types and exchanges messages with the parent
+// until the input is closed, usually Serve(os.Stdin, os.Stdout).
+func (p *Plugin) Serve(r io.Reader, w io.Writer) error {
+	p.mx.Lock()
+	types := make([]string, 0, len(p.types))
+	for t := range p.types {
+		types = append(types, t)
+	}
+	p.mx.Unlock()
+
+	sort.Strings(types)
+
+	conn := transport.NewStreamConn(pipe{Reader: r, Writer: w}, p.opts.maxFrameSize)
+
+	data, _ := json.Marshal(hello{Types: types})
+	if err := conn.WriteFrame(data); err != nil {
+		return err
+	}
+
+	session := transport.NewSession(conn, p.SessionReceiver())
+
+	p.sessions.Add("", session)
+	defer p.sessions.Remove("", session)
+
+	err := session.Run()
+	if err == io.EOF {
+		err = nil
+	}
+
+	return err
+}
+
+func (p *Plugin) advertise(t string) {
+	p.mx.Lock()
+	p.types[t] = struct{}{}
+	p.mx.Unlock()
+}
+
+// doneExecer sends a typeDone response after the execer returned.
+type doneExecer struct {
+	execer liteproto.ExecerWithResponder
+}
+
+func (e doneExecer) Exec(ctx context.Context, r liteproto.TaskRequest, rc liteproto.ResponderClient) {
+	defer func() {
+		_ = rc.RespondWithType(context.Background(), typeDone, liteproto.StatusOK, nil)
+	}()
+
+	e.execer.Exec(ctx, r, rc)
+}
+
+// execerAdapter turns an Execer into an ExecerWithResponder.
+type execerAdapter struct {
+	execer liteproto.Execer
+}
+
+func (e execerAdapter) Exec(ctx context.Context, r liteproto.TaskRequest, rc liteproto.ResponderClient) {
+	e.execer.Exec(ctx, r, rc)
+}
+
+// pipe joins a reader and a writer into a connection. Close closes both if they are closers.
+type pipe struct {
+	io.Reader
+	io.Writer
+}
+
+func (p pipe) Close() error {
+	var err error
+
+	if c, ok := p.Writer.(io.Closer); ok {
+		err = c.Close()
+	}
+
+	if c, ok := p.Reader.(io.Closer); ok {
+		if e := c.Close(); err == nil {
+			err = e
+		}
+	}
+
+	return err
+}
+
+// sessionDelivery sends all envelopes over the session with the other side.
+type sessionDelivery struct {
+	sessions *transport.Sessions
+}
+
+func (d sessionDelivery) Deliver(ctx context.Context, _ string, e *transport.Envelope) error {
+	_, err := d.sessions.DeliverOn(ctx, "", e)
+	return err
+}
@@ -0,0 +1,427 @@
+package liteprotostdio
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/drone/liteproto/liteproto"
+	"github.com/drone/liteproto/liteproto/transport"
+)
+
+const (
+	// helloTimeout is the time in which a started plugin must advertise its task types.
+	helloTimeout = 10 * time.Second
+
+	// stopTimeout is the time a plugin gets to exit after its input is closed, before it's killed.
+	stopTimeout = 5 * time.Second
+
+	minRestartDelay = 100 * time.Millisecond
+	maxRestartDelay = 30 * time.Second
+
+	// lostGrace is the time in which responses that arrived before the connection ended are still forwarded.
+	lostGrace = 100 * time.Millisecond
+)
+
+// ErrNoHello is returned by Launch when the plugin exits or doesn't advertise its task types in time.
+var ErrNoHello = errors.New("plugin did not advertise its task types")
+
+// Process is a running plugin on the parent side.
+type Process struct {
+	sc       *transport.ServerClient
+	pubsub   *transport.PubSub
+	server   liteproto.Server
+	template *exec.Cmd
+	logger   *log.Logger
+	opts     options
+	sessions *transport.Sessions
+
+	types   map[string]struct{}
+	cmd     *exec.Cmd
+	session *transport.Session
+	closed  bool
+	mx      sync.Mutex
+
+	closing chan struct{}
+	done    chan struct{}
+}
+
+// Launch starts the plugin and registers the task types it advertises on the server.
+// Tasks of those types are forwarded to the plugin, responses are forwarded back to the caller.
+//
+// Parameter cmd is used as a template: its Path, Args, Env, Dir, Stderr and SysProcAttr are copied
+// every time the plugin is started, cmd itself is never started. If Stderr is nil, the plugin's
+// standard error goes to the parent's standard error. When the plugin exits, it's started again;
+// task types it newly advertises are registered as well. Parameter 'logger' can be nil.
+//
+// Launch returns an error if the first start fails.
+func Launch(server liteproto.Server, cmd *exec.Cmd, logger *log.Logger, opts ...Option) (*Process, error) {
+	o := newOptions(opts)
+
+	if logger == nil {
+		logger = log.Default()
+	}
+
+	p := &Process{
+		server:   server,
+		template: cmd,
+		logger:   logger,
+		opts:     o,
+		sessions: transport.NewSessions(o.resumeTimeout),
+		pubsub:   &transport.PubSub{},
+		types:    map[string]struct{}{},
+		closing:  make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	p.sc = transport.NewServerClient(transport.Config{
+		Delivery: sessionDelivery{p.sessions},
+		PubSub:   p.pubsub,
+		Logger:   logger,
+	})
+
+	session, err := p.start()
+	if err != nil {
+		return nil, err
+	}
+
+	go p.supervise(session)
+
+	return p, nil
+}
+
+// Types returns the task types advertised by the plugin.
+func (p *Process) Types() []string {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+
+	types := make([]string, 0, len(p.types))
+	for t := range p.types {
+		types = append(types, t)
+	}
+
+	return types
+}
+
+// Client returns a client for calling the plugin directly. The response channel is closed
+// when the execer of the plugin returns.
+func (p *Process) Client() liteproto.Client {
+	return pluginClient{p.sc}
+}
+
+// Close stops the plugin and doesn't restart it anymore. The plugin's standard input is closed
+// and the plugin is killed if it doesn't exit in time. Registered task types stay registered,
+// calls of them fail.
+func (p *Process) Close() error {
+	p.mx.Lock()
+	if p.closed {
+		p.mx.Unlock()
+		return nil
+	}
+	p.closed = true
+	session := p.session
+	p.mx.Unlock()
+
+	close(p.closing)
+
+	if session != nil {
+		_ = session.Close()
+	}
+
+	<-p.done
+
+	return nil
+}
+
+// start starts the plugin, reads the advertised types and registers them.
+func (p *Process) start() (*transport.Session, error) {
+	t := p.template
+
+	cmd := &exec.Cmd{
+		Path:        t.Path,
+		Args:        t.Args,
+		Env:         t.Env,
+		Dir:         t.Dir,
+		Stderr:      t.Stderr,
+		SysProcAttr: t.SysProcAttr,
+	}
+
+	if cmd.Stderr == nil {
+		cmd.Stderr = os.Stderr
+	}
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	if err = cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	conn := transport.NewStreamConn(pipe{Reader: stdout, Writer: stdin}, p.opts.maxFrameSize)
+
+	h, err := readHello(conn)
+	if err != nil {
+		_ = conn.Close()
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+		return nil, err
+	}
+
+	session := transport.NewSession(conn, p.sc.SessionReceiver())
+
+	p.mx.Lock()
+	p.cmd = cmd
+	p.session = session
+	var added []string
+	for _, t := range h.Types {
+		if _, ok := p.types[t]; !ok {
+			p.types[t] = struct{}{}
+			added = append(added, t)
+		}
+	}
+	p.mx.Unlock()
+
+	for _, t := range added {
+		p.server.RegisterWithResponder(t, forwarder{sessions: p.sessions, pubsub: p.pubsub})
+	}
+
+	return session, nil
+}
+
+func readHello(conn transport.FrameConn) (hello, error) {
+	type result struct {
+		data []byte
+		err  error
+	}
+
+	ch := make(chan result, 1)
+	go func() {
+		data, err := conn.ReadFrame()
+		ch <- result{data, err}
+	}()
+
+	var h hello
+
+	select {
+	case r := <-ch:
+		if r.err != nil {
+			return h, ErrNoHello
+		}
+		if err := json.Unmarshal(r.data, &h); err != nil {
+			return h, err
+		}
+		return h, nil
+	case <-time.After(helloTimeout):
+		return h, ErrNoHello
+	}
+}
+
+// supervise runs sessions with the plugin and restarts it when it exits, until the Process is closed.
+func (p *Process) supervise(session *transport.Session) {
+	defer close(p.done)
+
+	delay := minRestartDelay
+
+	for {
+		p.run(session)
+
+		for {
+			select {
+			case <-p.closing:
+				return
+			case <-time.After(delay):
+			}
+
+			delay *= 2
+			if delay > maxRestartDelay {
+				delay = maxRestartDelay
+			}
+
+			var err error
+			if session, err = p.start(); err == nil {
+				delay = minRestartDelay
+				break
+			}
+
+			p.logger.Printf("starting plugin %s failed: %s", p.template.Path, err.Error())
+		}
+	}
+}
+
+// run runs the session until it ends and waits for the plugin to exit.
+func (p *Process) run(session *transport.Session) {
+	p.sessions.Add("", session)
+
+	p.mx.Lock()
+	closed := p.closed
+	cmd := p.cmd
+	p.mx.Unlock()
+
+	if closed {
+		_ = session.Close()
+	}
+
+	err := session.Run()
+	p.sessions.Remove("", session)
+
+	if err != nil && err != io.EOF {
+		p.logger.Printf("plugin %s connection failed: %s", p.template.Path, err.Error())
+	}
+
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+
+	select {
+	case err = <-exited:
+	case <-time.After(stopTimeout):
+		_ = cmd.Process.Kill()
+		err = <-exited
+	}
+
+	p.mx.Lock()
+	closed = p.closed
+	p.mx.Unlock()
+
+	if !closed {
+		if err == nil {
+			err = errors.New("exited")
+		}
+		p.logger.Printf("plugin %s stopped: %s, restarting", p.template.Path, err.Error())
+	}
+}
+
+// errPluginStopped is sent to callers whose tasks were running when the plugin stopped.
+var errPluginStopped = errors.New("plugin stopped before the task finished")
+
+// forwarder executes tasks by calling the plugin and forwards its responses to the caller.
+// If the plugin stops before it finishes the task, the caller gets an error response.
+type forwarder struct {
+	sessions *transport.Sessions
+	pubsub   *transport.PubSub
+}
+
+func (f forwarder) Exec(ctx context.Context, r liteproto.TaskRequest, rc liteproto.ResponderClient) {
+	deadline, _ := ctx.Deadline()
+
+	fail := func(err error) {
+		data, _ := json.Marshal(err.Error())
+		_ = rc.Respond(ctx, liteproto.StatusError, data)
+	}
+
+	caller := &sessionCaller{sessions: f.sessions}
+
+	ch, stop, err := transport.NewRunner(caller, f.pubsub).Run(ctx, r, deadline)
+	if err != nil {
+		fail(err)
+		return
+	}
+	defer close(stop)
+
+	var lost <-chan struct{}
+	if caller.session != nil {
+		lost = caller.session.Done()
+	}
+
+	var grace <-chan time.Time
+
+	for {
+		select {
+		case response, ok := <-ch:
+			if !ok || response.Type == typeDone {
+				return
+			}
+
+			if err = rc.RespondWithType(ctx, response.Type, response.Status, response.Data); err != nil {
+				return
+			}
+
+		case <-lost:
+			// the plugin can't send the done response anymore
+			lost = nil
+			grace = time.After(lostGrace)
+
+		case <-grace:
+			fail(errPluginStopped)
+			return
+		}
+	}
+}
+
+// pluginClient calls the plugin. It doesn't pass the typeDone responses to the caller,
+// it closes the response channel instead.
+type pluginClient struct {
+	client liteproto.Client
+}
+
+func (c pluginClient) Call(ctx context.Context, r liteproto.TaskRequest) error {
+	return c.client.Call(ctx, r)
+}
+
+func (c pluginClient) CallWithResponse(ctx context.Context, r liteproto.TaskRequest) (<-chan liteproto.TaskResponse, chan<- struct{}, error) {
+	return hideDone(c.client.CallWithResponse(ctx, r))
+}
+
+func (c pluginClient) CallWithDeadline(ctx context.Context, r liteproto.TaskRequest, deadline time.Time) (<-chan liteproto.TaskResponse, chan<- struct{}, error) {
+	return hideDone(c.client.CallWithDeadline(ctx, r, deadline))
+}
+
+func hideDone(ch <-chan liteproto.TaskResponse, stop chan<- struct{}, err error) (<-chan liteproto.TaskResponse, chan<- struct{}, error) {
+	if err != nil {
+		return nil, nil, err
+	}
+
+	responseChan := make(chan liteproto.TaskResponse)
+	stopChan := make(chan struct{})
+
+	go func() {
+		defer close(responseChan)
+		defer close(stop)
+
+		for {
+			select {
+			case response, ok := <-ch:
+				if !ok || response.Type == typeDone {
+					return
+				}
+
+				select {
+				case responseChan <- response:
+				case <-stopChan:
+					return
+				}
+
+			case <-stopChan:
+				return
+			}
+		}
+	}()
+
+	return responseChan, stopChan, nil
+}
+
+// sessionCaller delivers a request over the session with the plugin and records the session,
+// so that the forwarder notices when it ends.
+type sessionCaller struct {
+	sessions *transport.Sessions
+	session  *transport.Session
+}
+
+func (c *sessionCaller) Call(ctx context.Context, e *transport.Envelope) (err error) {
+	c.session, err = c.sessions.DeliverOn(ctx, "", e)
+	return err
+}
@@ -0,0 +1,200 @@
+package liteprotostdio
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/drone/liteproto/liteproto"
+	"github.com/drone/liteproto/liteproto/liteprotomem"
+)
+
+// pluginEnv is set for the test binary started as a plugin. It's the path of a file
+// with the task types the plugin registers, one per line.
+const pluginEnv = "LITEPROTOSTDIO_TEST_PLUGIN"
+
+// counter responds three times, the last response has StatusSuccess. It exits the process
+// after the first response if the request data is "crash".
+type counter struct{}
+
+func (counter) Exec(ctx context.Context, r liteproto.TaskRequest, rc liteproto.ResponderClient) {
+	for i, status := range []string{liteproto.StatusOK, liteproto.StatusOK, liteproto.StatusSuccess} {
+		if i == 1 && string(r.Data) == `"crash"` {
+			os.Exit(3)
+		}
+		_ = rc.Respond(ctx, status, r.Data)
+	}
+}
+
+func TestMain(m *testing.M) {
+	if path := os.Getenv(pluginEnv); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			os.Exit(2)
+		}
+
+		p := NewPlugin(nil)
+		for _, t := range strings.Fields(string(data)) {
+			p.RegisterWithResponder(t, counter{})
+		}
+
+		_ = p.Serve(os.Stdin, os.Stdout)
+		return
+	}
+
+	os.Exit(m.Run())
+}
+
+// launch starts the test binary as a plugin that registers the types. The types can be changed
+// with the returned function, a restarted plugin registers the new ones.
+func launch(t *testing.T, server liteproto.Server, types ...string) (p *Process, setTypes func(types ...string)) {
+	path := filepath.Join(t.TempDir(), "types")
+
+	setTypes = func(types ...string) {
+		if err := os.WriteFile(path, []byte(strings.Join(types, "\n")), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	setTypes(types...)
+
+	cmd := exec.Command(os.Args[0])
+	cmd.Env = append(os.Environ(), pluginEnv+"="+path)
+
+	p, err := Launch(server, cmd, nil, WithResumeTimeout(5*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = p.Close() })
+
+	return p, setTypes
+}
+
+// collect reads responses until a final response arrives or the channel is closed.
+func collect(t *testing.T, ch <-chan liteproto.TaskResponse, stop chan<- struct{}) []liteproto.TaskResponse {
+	t.Helper()
+	defer close(stop)
+
+	var responses []liteproto.TaskResponse
+	for r := range ch {
+		responses = append(responses, r)
+		if r.Status == liteproto.StatusSuccess || r.Status == liteproto.StatusError {
+			break
+		}
+	}
+
+	return responses
+}
+
+func TestRoundTrip(t *testing.T) {
+	caller, server := liteprotomem.Pair(nil)
+
+	p, _ := launch(t, server, "count", "other")
+
+	types := p.Types()
+	sort.Strings(types)
+	if len(types) != 2 || types[0] != "count" || types[1] != "other" {
+		t.Errorf("expected types count and other, got %v", types)
+	}
+
+	if err := caller.Call(context.Background(), liteproto.TaskRequest{ID: "1", Type: "unknown"}); err != liteproto.ErrUnknownType {
+		t.Errorf("expected ErrUnknownType, got %v", err)
+	}
+
+	ch, stop, err := caller.CallWithDeadline(context.Background(), liteproto.TaskRequest{ID: "2", Type: "count", Data: []byte(`"x"`)}, time.Now().Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if responses := collect(t, ch, stop); len(responses) != 3 || string(responses[2].Data) != `"x"` {
+		t.Errorf("expected 3 responses through the server, got %+v", responses)
+	}
+
+	// the direct client closes the channel when the plugin's execer returns, without the done response
+	ch, stop, err = p.Client().CallWithDeadline(context.Background(), liteproto.TaskRequest{ID: "3", Type: "count"}, time.Now().Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer close(stop)
+
+	var responses []liteproto.TaskResponse
+	timeout := time.After(time.Second)
+	for open := true; open; {
+		select {
+		case r, ok := <-ch:
+			if ok {
+				responses = append(responses, r)
+			}
+			open = ok
+		case <-timeout:
+			t.Fatal("the response channel wasn't closed")
+		}
+	}
+
+	if len(responses) != 3 {
+		t.Errorf("expected 3 responses from the direct client, got %+v", responses)
+	}
+	for _, r := range responses {
+		if r.Type == typeDone {
+			t.Errorf("the done response was passed to the caller")
+		}
+	}
+}
+
+func TestRestart(t *testing.T) {
+	caller, server := liteprotomem.Pair(nil)
+
+	p, setTypes := launch(t, server, "count")
+
+	// the restarted plugin registers a new type
+	setTypes("count", "added")
+
+	// keep calling while the plugin restarts and the new type is registered
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				_ = caller.Call(context.Background(), liteproto.TaskRequest{ID: "background", Type: "added"})
+				time.Sleep(time.Millisecond)
+			}
+		}
+	}()
+
+	ch, stop, err := caller.CallWithDeadline(context.Background(), liteproto.TaskRequest{ID: "1", Type: "count", Data: []byte(`"crash"`)}, time.Now().Add(2*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	responses := collect(t, ch, stop)
+	if len(responses) != 2 || responses[0].Status != liteproto.StatusOK || responses[1].Status != liteproto.StatusError {
+		t.Fatalf("expected a response and an error response, got %+v", responses)
+	}
+
+	var message string
+	if err = json.Unmarshal(responses[1].Data, &message); err != nil || message != errPluginStopped.Error() {
+		t.Errorf("expected error %q, got %s", errPluginStopped, responses[1].Data)
+	}
+
+	// calls wait for the restarted plugin
+	for _, typ := range []string{"count", "added"} {
+		ch, stop, err = caller.CallWithDeadline(context.Background(), liteproto.TaskRequest{ID: "2" + typ, Type: typ}, time.Now().Add(5*time.Second))
+		if err != nil {
+			t.Fatalf("%s: %v", typ, err)
+		}
+		if responses = collect(t, ch, stop); len(responses) != 3 {
+			t.Errorf("%s: expected 3 responses after restarting, got %+v", typ, responses)
+		}
+	}
+
+	if len(p.Types()) != 2 {
+		t.Errorf("expected 2 types after restarting, got %v", p.Types())
+	}
+}
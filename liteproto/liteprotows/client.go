@@ -0,0 +1,64 @@
+package liteprotows
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/drone/liteproto/liteproto/transport"
+)
+
+// Client dials a Server and keeps the WebSocket connection open, reconnecting automatically
+// when it breaks. Requests and responses flow in both directions over the connection.
+//
+// Client implements liteproto.ServerClient. Calls awaiting responses survive reconnections,
+// see transport.ConnClient.
+type Client struct {
+	*transport.ConnClient
+
+	url          string
+	header       http.Header
+	dialer       *websocket.Dialer
+	maxFrameSize int
+}
+
+// Dial creates a new Client for the Server at the provided WebSocket URL ("ws://" or "wss://").
+// The connection is established in the background, calls made before it is established wait for it.
+// Parameter 'logger' can be nil.
+func Dial(serverURL string, logger *log.Logger, opts ...Option) (*Client, error) {
+	o := newOptions(opts)
+
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Client{
+		ConnClient:   transport.NewConnClient(o.ConnOptions, logger),
+		header:       o.header,
+		dialer:       websocket.DefaultDialer,
+		maxFrameSize: o.maxFrameSize,
+	}
+
+	q := u.Query()
+	q.Set(clientParam, c.ID())
+	u.RawQuery = q.Encode()
+	c.url = u.String()
+
+	// a reconnected client replaces its old connection, which may not be found broken yet
+	c.Keep(c.url, c.connect, true)
+
+	return c, nil
+}
+
+func (c *Client) connect(ctx context.Context) (*transport.Session, error) {
+	ws, _, err := c.dialer.DialContext(ctx, c.url, c.header)
+	if err != nil {
+		return nil, err
+	}
+
+	return transport.NewSession(newConn(ws, c.maxFrameSize), c.SessionReceiver()), nil
+}
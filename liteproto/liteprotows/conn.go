@@ -0,0 +1,44 @@
+package liteprotows
+
+import (
+	"github.com/gorilla/websocket"
+
+	"github.com/drone/liteproto/liteproto/transport"
+)
+
+// conn carries frames of a transport.Session in binary WebSocket messages.
+type conn struct {
+	ws           *websocket.Conn
+	maxFrameSize int
+}
+
+// newConn creates a conn whose frames are limited to maxFrameSize in both directions,
+// zero value means transport.DefaultMaxFrameSize.
+func newConn(ws *websocket.Conn, maxFrameSize int) conn {
+	if maxFrameSize <= 0 {
+		maxFrameSize = transport.DefaultMaxFrameSize
+	}
+
+	ws.SetReadLimit(int64(maxFrameSize))
+
+	return conn{ws: ws, maxFrameSize: maxFrameSize}
+}
+
+func (c conn) ReadFrame() ([]byte, error) {
+	_, data, err := c.ws.ReadMessage()
+	if err == websocket.ErrReadLimit {
+		return nil, transport.ErrFrameTooLarge
+	}
+	return data, err
+}
+
+func (c conn) WriteFrame(frame []byte) error {
+	if len(frame) > c.maxFrameSize {
+		return transport.ErrFrameTooLarge
+	}
+	return c.ws.WriteMessage(websocket.BinaryMessage, frame)
+}
+
+func (c conn) Close() error {
+	return c.ws.Close()
+}
@@ -0,0 +1,86 @@
+package liteprotows
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/drone/liteproto/liteproto"
+	"github.com/drone/liteproto/liteproto/transport"
+)
+
+// Option configures an optional feature of a Server or a Client.
+type Option func(*options)
+
+type options struct {
+	transport.ConnOptions
+
+	header       http.Header
+	authenticate func(r *http.Request, clientID string) error
+	maxFrameSize int
+}
+
+func newOptions(opts []Option) options {
+	o := options{}
+
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return o
+}
+
+// WithMaxFrameSize sets the maximum size of a WebSocket message, an encoded message of liteproto,
+// in both directions. The default is transport.DefaultMaxFrameSize. Connections that receive
+// larger messages are closed, larger messages aren't sent.
+func WithMaxFrameSize(size int) Option {
+	return func(o *options) {
+		o.maxFrameSize = size
+	}
+}
+
+// WithResumeTimeout sets transport.ConnOptions.ResumeTimeout of a Server or a Client.
+func WithResumeTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.ResumeTimeout = d
+	}
+}
+
+// WithClientID sets transport.ConnOptions.ClientID of a Client, sent to the Server in the WebSocket URL.
+func WithClientID(id string) Option {
+	return func(o *options) {
+		o.ClientID = id
+	}
+}
+
+// WithHeader sets additional HTTP headers that a Client sends when it opens the WebSocket connection,
+// for example for authentication.
+func WithHeader(header http.Header) Option {
+	return func(o *options) {
+		o.header = header
+	}
+}
+
+// WithResultStore sets transport.ConnOptions.ResultStore of a Server or a Client.
+func WithResultStore(store liteproto.ResultStore) Option {
+	return func(o *options) {
+		o.ResultStore = store
+	}
+}
+
+// WithOrphanHandler sets transport.ConnOptions.OrphanHandler of a Server or a Client.
+func WithOrphanHandler(f func(response liteproto.TaskResponse)) Option {
+	return func(o *options) {
+		o.OrphanHandler = f
+	}
+}
+
+// WithAuthenticator sets a function that verifies that the client opening a WebSocket connection
+// is allowed to use the client ID it presents, for example with the headers set by WithHeader.
+// Connections it returns an error for are rejected with 401 (Unauthorized). An authenticated connection
+// replaces the current connection of the client. Without an authenticator a connection with the ID
+// of a connected client is rejected with 409 (Conflict), the client retries until the old connection is closed.
+func WithAuthenticator(f func(r *http.Request, clientID string) error) Option {
+	return func(o *options) {
+		o.authenticate = f
+	}
+}
@@ -0,0 +1,89 @@
+package liteprotows
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/drone/liteproto/liteproto/transport"
+)
+
+// clientParam is the query parameter of the WebSocket URL that holds the client ID.
+const clientParam = "client"
+
+// Server accepts WebSocket connections from Clients. Requests and responses flow
+// in both directions over the connections, so the clients need not be reachable by the server.
+//
+// Server implements liteproto.ServerClient, see transport.ConnServer.
+type Server struct {
+	*transport.ConnServer
+
+	upgrader     websocket.Upgrader
+	sessions     *transport.Sessions
+	authenticate func(r *http.Request, clientID string) error
+	maxFrameSize int
+}
+
+// NewServer creates a new Server. Parameter 'logger' can be nil.
+func NewServer(logger *log.Logger, opts ...Option) *Server {
+	o := newOptions(opts)
+
+	s := &Server{
+		ConnServer:   transport.NewConnServer(o.ConnOptions, logger),
+		authenticate: o.authenticate,
+		maxFrameSize: o.maxFrameSize,
+	}
+	s.sessions = s.Sessions()
+
+	return s
+}
+
+// SetCheckOrigin sets the function that validates the Origin header of WebSocket handshakes.
+// By default requests with an Origin header that doesn't match the Host header are rejected.
+func (s *Server) SetCheckOrigin(f func(r *http.Request) bool) {
+	s.upgrader.CheckOrigin = f
+}
+
+// Handler returns the HTTP handler that accepts WebSocket connections.
+func (s *Server) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.URL.Query().Get(clientParam)
+		if id == "" {
+			http.Error(w, "missing client id", http.StatusBadRequest)
+			return
+		}
+
+		if s.authenticate != nil {
+			if err := s.authenticate(r, id); err != nil {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+		} else if s.sessions.Connected(id) {
+			// an unauthenticated connection must not take over the session of another client
+			http.Error(w, "client already connected", http.StatusConflict)
+			return
+		}
+
+		ws, err := s.upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return // the upgrader already replied with an error
+		}
+
+		session := transport.NewSession(newConn(ws, s.maxFrameSize), s.SessionReceiver())
+
+		added := false
+		if s.authenticate != nil {
+			added = s.sessions.Replace(id, session)
+		} else {
+			added = s.sessions.AddIfAbsent(id, session)
+		}
+		if !added {
+			_ = ws.Close()
+			return
+		}
+		defer s.sessions.Remove(id, session)
+
+		_ = session.Run()
+	})
+}
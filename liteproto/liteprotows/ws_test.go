@@ -0,0 +1,298 @@
+package liteprotows
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/drone/liteproto/liteproto"
+	"github.com/drone/liteproto/liteproto/transport"
+)
+
+// counter responds three times with a pause before each response. The last response has StatusSuccess.
+type counter struct {
+	pause time.Duration
+}
+
+func (e counter) Exec(ctx context.Context, r liteproto.TaskRequest, rc liteproto.ResponderClient) {
+	for _, status := range []string{liteproto.StatusOK, liteproto.StatusOK, liteproto.StatusSuccess} {
+		time.Sleep(e.pause)
+		_ = rc.Respond(ctx, status, r.Data)
+	}
+}
+
+// dropListener is a net.Listener that can break all accepted connections.
+type dropListener struct {
+	net.Listener
+	conns []net.Conn
+	mx    sync.Mutex
+}
+
+func (l *dropListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err == nil {
+		l.mx.Lock()
+		l.conns = append(l.conns, c)
+		l.mx.Unlock()
+	}
+	return c, err
+}
+
+func (l *dropListener) drop() {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+
+	for _, c := range l.conns {
+		_ = c.Close()
+	}
+	l.conns = nil
+}
+
+// newTestServer starts an HTTP server with the handler of the Server. The returned URL is a WebSocket URL.
+func newTestServer(t *testing.T, s *Server) (url string, l *dropListener) {
+	srv := httptest.NewUnstartedServer(s.Handler())
+	l = &dropListener{Listener: srv.Listener}
+	srv.Listener = l
+	srv.Start()
+
+	t.Cleanup(func() {
+		_ = s.Close()
+		srv.Close()
+	})
+
+	return "ws" + strings.TrimPrefix(srv.URL, "http"), l
+}
+
+func dial(t *testing.T, url string, opts ...Option) *Client {
+	c, err := Dial(url, nil, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = c.Close() })
+
+	return c
+}
+
+// waitConnected waits until the client with the provided ID is connected to the server.
+func waitConnected(t *testing.T, s *Server, clientID string) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		err := s.Client(clientID).Call(context.Background(), liteproto.TaskRequest{ID: transport.RandomID(), Type: "count"})
+		if err == nil {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("client %s didn't connect: %v", clientID, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// collect reads responses until a final response arrives or the channel is closed.
+func collect(t *testing.T, ch <-chan liteproto.TaskResponse, stop chan<- struct{}) []liteproto.TaskResponse {
+	t.Helper()
+	defer close(stop)
+
+	var responses []liteproto.TaskResponse
+	for r := range ch {
+		responses = append(responses, r)
+		if r.Status == liteproto.StatusSuccess || r.Status == liteproto.StatusError {
+			break
+		}
+	}
+
+	return responses
+}
+
+func TestRoundTrip(t *testing.T) {
+	s := NewServer(nil, WithResumeTimeout(100*time.Millisecond))
+	s.RegisterWithResponder("count", counter{})
+
+	url, _ := newTestServer(t, s)
+
+	c := dial(t, url, WithClientID("worker"))
+	c.RegisterWithResponder("count", counter{})
+	waitConnected(t, s, "worker")
+
+	if err := c.Call(context.Background(), liteproto.TaskRequest{ID: "1", Type: "unknown"}); err != liteproto.ErrUnknownType {
+		t.Errorf("expected ErrUnknownType, got %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		client liteproto.Client
+	}{
+		{"client calls server", c},
+		{"server calls client", s.Client("worker")},
+	}
+
+	for _, test := range tests {
+		ch, stop, err := test.client.CallWithDeadline(context.Background(), liteproto.TaskRequest{ID: test.name, Type: "count", Data: []byte(`"x"`)}, time.Now().Add(200*time.Millisecond))
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		responses := collect(t, ch, stop)
+		if len(responses) != 3 || string(responses[0].Data) != `"x"` {
+			t.Errorf("%s: expected 3 responses, got %+v", test.name, responses)
+		}
+	}
+
+	if err := s.Client("nobody").Call(context.Background(), liteproto.TaskRequest{ID: "2", Type: "count"}); err != liteproto.ErrUnknownPeer {
+		t.Errorf("expected ErrUnknownPeer, got %v", err)
+	}
+}
+
+func TestResume(t *testing.T) {
+	s := NewServer(nil)
+	s.RegisterWithResponder("count", counter{pause: 50 * time.Millisecond})
+
+	url, l := newTestServer(t, s)
+
+	c := dial(t, url)
+
+	ch, stop, err := c.CallWithDeadline(context.Background(), liteproto.TaskRequest{ID: "1", Type: "count"}, time.Now().Add(2*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// break the connection while the task runs, the responses wait for the client to reconnect
+	time.Sleep(60 * time.Millisecond)
+	l.drop()
+
+	if responses := collect(t, ch, stop); len(responses) != 3 {
+		t.Errorf("expected all 3 responses after reconnecting, got %+v", responses)
+	}
+}
+
+func TestClientIDConflict(t *testing.T) {
+	tests := []struct {
+		name          string
+		authenticate  func(r *http.Request, clientID string) error
+		header        http.Header
+		expected      int // status of the second connection
+		firstReplaced bool
+	}{
+		{
+			name:     "unauthenticated",
+			expected: http.StatusConflict,
+		},
+		{
+			name: "authenticated",
+			authenticate: func(r *http.Request, clientID string) error {
+				if r.Header.Get("Authorization") != "token "+clientID {
+					return errors.New("invalid token")
+				}
+				return nil
+			},
+			header:        http.Header{"Authorization": {"token worker"}},
+			expected:      http.StatusSwitchingProtocols,
+			firstReplaced: true,
+		},
+		{
+			name: "wrong credentials",
+			authenticate: func(r *http.Request, clientID string) error {
+				if r.Header.Get("Authorization") != "token "+clientID {
+					return errors.New("invalid token")
+				}
+				return nil
+			},
+			header:   http.Header{"Authorization": {"token other"}},
+			expected: http.StatusUnauthorized,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := NewServer(nil, WithAuthenticator(test.authenticate))
+			url, _ := newTestServer(t, s)
+
+			c := dial(t, url, WithClientID("worker"), WithHeader(http.Header{"Authorization": {"token worker"}}))
+			c.RegisterWithResponder("count", counter{})
+
+			waitConnected(t, s, "worker")
+
+			ws, resp, err := websocket.DefaultDialer.Dial(url+"?client=worker", test.header)
+			if ws != nil {
+				defer ws.Close()
+			}
+			if resp == nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != test.expected {
+				t.Fatalf("expected status %d, got %d", test.expected, resp.StatusCode)
+			}
+
+			// the client reconnects when its connection is replaced, calls reach it either way
+			if !test.firstReplaced {
+				ch, stop, err := s.Client("worker").CallWithDeadline(context.Background(), liteproto.TaskRequest{ID: "2", Type: "count"}, time.Now().Add(time.Second))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if responses := collect(t, ch, stop); len(responses) != 3 {
+					t.Errorf("expected the calls to reach the client, got %+v", responses)
+				}
+			}
+		})
+	}
+}
+
+func TestMaxFrameSize(t *testing.T) {
+	s := NewServer(nil, WithMaxFrameSize(1<<10))
+	s.RegisterWithResponder("count", counter{})
+
+	url, _ := newTestServer(t, s)
+
+	large := liteproto.TaskRequest{ID: "1", Type: "count", Data: bytes.Repeat([]byte("x"), 4<<10)}
+
+	// the client doesn't send frames over its own limit
+	small := dial(t, url, WithMaxFrameSize(1<<10))
+	if err := small.Call(context.Background(), large); err != transport.ErrFrameTooLarge {
+		t.Errorf("expected ErrFrameTooLarge, got %v", err)
+	}
+
+	// the server drops the connection of a client that sends a larger frame
+	c := dial(t, url)
+	if err := c.Call(context.Background(), large); err != transport.ErrNotAcknowledged {
+		t.Errorf("expected ErrNotAcknowledged, got %v", err)
+	}
+
+	if err := c.Call(context.Background(), liteproto.TaskRequest{ID: "2", Type: "count"}); err != nil {
+		t.Errorf("expected the client to reconnect, got %v", err)
+	}
+}
+
+func TestCloseWhileConnecting(t *testing.T) {
+	s := NewServer(nil)
+	url, _ := newTestServer(t, s)
+
+	for i := 0; i < 20; i++ {
+		c, err := Dial(url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		closed := make(chan struct{})
+		go func() {
+			_ = c.Close()
+			close(closed)
+		}()
+
+		select {
+		case <-closed:
+		case <-time.After(time.Second):
+			t.Fatal("Close didn't return")
+		}
+	}
+}
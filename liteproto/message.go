@@ -16,6 +16,9 @@ type TaskRequest struct {
 
 	// Data holds arbitrary byte data payload.
 	Data []byte
+
+	// Metadata holds optional key-value pairs that describe the request, for example for routing.
+	Metadata map[string]string
 }
 
 // TaskResponse contains response of a task.
@@ -32,3 +35,38 @@ type TaskResponse struct {
 	// Data holds arbitrary byte data payload.
 	Data []byte
 }
+
+// TypeReplay is a reserved task type. A request of this type asks a remote server to send again
+// the stored responses of the task with the same ID. Data of the request is a JSON encoded ReplayRequest.
+const TypeReplay = "liteproto.replay"
+
+// ReplayRequest is the payload of a TypeReplay request.
+type ReplayRequest struct {
+	// From is the number of responses the caller already received. Only responses after that are replayed.
+	From int `json:"from"`
+}
+
+// TypeSchema is a reserved task type. A request of this type asks a remote server for the schemas
+// of its task types (see SchemaServer). Data of the request is an optional JSON encoded SchemaRequest,
+// Data of the response is a JSON object with TypeSchemas of each task type that has any. FetchSchemas sends it.
+const TypeSchema = "liteproto.schema"
+
+// SchemaRequest is the payload of a TypeSchema request.
+type SchemaRequest struct {
+	// Types lists the task types whose schemas are requested. If empty, the schemas of all types are returned.
+	Types []string `json:"types,omitempty"`
+}
+
+// TypeSchemas holds the schemas of payloads of a task type.
+type TypeSchemas struct {
+	// Request validates Data of requests. Nil means requests are not validated.
+	Request *Schema `json:"request,omitempty"`
+
+	// Response validates Data of responses, except those with StatusError. Nil means responses are not validated.
+	// A response sent with a different type (RespondWithType) is validated with the schema of that type.
+	Response *Schema `json:"response,omitempty"`
+}
+
+// TypePing is a reserved task type. A server accepts requests of this type without executing anything,
+// so they can be used to check if the server is reachable.
+const TypePing = "liteproto.ping"
@@ -0,0 +1,20 @@
+package liteproto
+
+// OverflowPolicy tells what happens with a response when the buffer of its subscriber is full.
+type OverflowPolicy int
+
+const (
+	// OverflowBlock makes the publisher wait until there is room in the buffer or a timeout expires.
+	// The response is dropped if the timeout expires.
+	OverflowBlock OverflowPolicy = iota
+
+	// OverflowDropOldest drops the oldest buffered response to make room for the new one.
+	OverflowDropOldest
+
+	// OverflowDropNewest drops the new response.
+	OverflowDropNewest
+
+	// OverflowFail terminates the response stream. The last response in the stream
+	// will have StatusError and ErrResponseOverflow message as JSON string in Data.
+	OverflowFail
+)
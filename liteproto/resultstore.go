@@ -0,0 +1,132 @@
+package liteproto
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// ResultStore keeps responses of recently executed tasks on the responding side,
+// so that they can be replayed to callers that were not listening when the responses were sent.
+type ResultStore interface {
+	// Append stores a response. Responses of a task are kept in the order they were appended.
+	Append(response TaskResponse) error
+
+	// Load returns the stored responses of the task with the provided ID, skipping the first 'from'
+	// responses appended for it. A store that dropped responses of the task still counts them,
+	// so 'from' is the number of responses sent, not the number of stored ones.
+	// It returns ErrNoResults if nothing is stored for the ID.
+	Load(id string, from int) ([]TaskResponse, error)
+}
+
+// MemoryResultStore is a ResultStore that keeps responses in memory.
+// It retains responses of at most maxTasks tasks and drops responses of tasks that
+// didn't receive a new response for longer than maxAge. Each task keeps its last maxResponses
+// responses, older ones are dropped, so replays of long tasks still end with the final response.
+type MemoryResultStore struct {
+	maxTasks     int
+	maxResponses int
+	maxAge       time.Duration
+
+	entries map[string]*list.Element
+	order   *list.List // least recently updated first
+	mx      sync.Mutex
+}
+
+type resultEntry struct {
+	id        string
+	updated   time.Time
+	dropped   int // number of responses dropped before the first stored one
+	responses []TaskResponse
+}
+
+// NewMemoryResultStore creates a new MemoryResultStore.
+// Zero value for any of maxTasks, maxResponses or maxAge means no limit.
+func NewMemoryResultStore(maxTasks, maxResponses int, maxAge time.Duration) *MemoryResultStore {
+	return &MemoryResultStore{
+		maxTasks:     maxTasks,
+		maxResponses: maxResponses,
+		maxAge:       maxAge,
+		entries:      map[string]*list.Element{},
+		order:        list.New(),
+	}
+}
+
+// Append stores a response. This method implements ResultStore interface.
+func (s *MemoryResultStore) Append(response TaskResponse) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	now := time.Now()
+	s.expire(now)
+
+	var entry *resultEntry
+
+	if el, ok := s.entries[response.ID]; ok {
+		entry = el.Value.(*resultEntry)
+		s.order.MoveToBack(el)
+	} else {
+		entry = &resultEntry{id: response.ID}
+		s.entries[response.ID] = s.order.PushBack(entry)
+	}
+
+	entry.updated = now
+	entry.responses = append(entry.responses, response)
+
+	if n := len(entry.responses) - s.maxResponses; s.maxResponses > 0 && n > 0 {
+		entry.responses = entry.responses[n:]
+		entry.dropped += n
+	}
+
+	for s.maxTasks > 0 && s.order.Len() > s.maxTasks {
+		s.remove(s.order.Front())
+	}
+
+	return nil
+}
+
+// Load returns stored responses of a task. This method implements ResultStore interface.
+func (s *MemoryResultStore) Load(id string, from int) ([]TaskResponse, error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	s.expire(time.Now())
+
+	el, ok := s.entries[id]
+	if !ok {
+		return nil, ErrNoResults
+	}
+
+	entry := el.Value.(*resultEntry)
+
+	// the caller missed the dropped responses, it gets the stored ones
+	from -= entry.dropped
+	if from < 0 {
+		from = 0
+	} else if from > len(entry.responses) {
+		from = len(entry.responses)
+	}
+
+	responses := make([]TaskResponse, len(entry.responses)-from)
+	copy(responses, entry.responses[from:])
+
+	return responses, nil
+}
+
+func (s *MemoryResultStore) expire(now time.Time) {
+	if s.maxAge <= 0 {
+		return
+	}
+
+	for el := s.order.Front(); el != nil; el = s.order.Front() {
+		if now.Sub(el.Value.(*resultEntry).updated) <= s.maxAge {
+			return
+		}
+		s.remove(el)
+	}
+}
+
+func (s *MemoryResultStore) remove(el *list.Element) {
+	entry := s.order.Remove(el).(*resultEntry)
+	delete(s.entries, entry.id)
+}
@@ -0,0 +1,71 @@
+package liteproto
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestMemoryResultStoreMaxResponses(t *testing.T) {
+	s := NewMemoryResultStore(0, 3, time.Minute)
+
+	// responses 0-4 and the final response 5, the store keeps 3-5
+	for i := 0; i < 6; i++ {
+		status := StatusOK
+		if i == 5 {
+			status = StatusSuccess
+		}
+		if err := s.Append(TaskResponse{ID: "1", Status: status, Data: []byte(strconv.Itoa(i))}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		from     int
+		expected string
+	}{
+		{0, "345"},
+		{3, "345"},
+		{4, "45"},
+		{5, "5"},
+		{6, ""},
+		{10, ""},
+	}
+
+	for _, test := range tests {
+		responses, err := s.Load("1", test.from)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got := ""
+		for _, r := range responses {
+			got += string(r.Data)
+		}
+		if got != test.expected {
+			t.Errorf("from %d: expected responses %q, got %q", test.from, test.expected, got)
+		}
+	}
+
+	if err := s.Append(TaskResponse{ID: "2", Status: StatusOK}); err != nil {
+		t.Error(err)
+	}
+	if responses, _ := s.Load("2", 0); len(responses) != 1 {
+		t.Errorf("expected the limit to apply per task, got %+v", responses)
+	}
+}
+
+func TestMemoryResultStoreMaxTasks(t *testing.T) {
+	s := NewMemoryResultStore(1, 0, time.Minute)
+
+	_ = s.Append(TaskResponse{ID: "1", Status: StatusSuccess})
+	_ = s.Append(TaskResponse{ID: "2", Status: StatusSuccess})
+
+	if _, err := s.Load("1", 0); err != ErrNoResults {
+		t.Errorf("expected the oldest task to be dropped, got %v", err)
+	}
+
+	if responses, err := s.Load("2", 0); err != nil || len(responses) != 1 {
+		t.Errorf("expected one response of the newest task, got %+v, %v", responses, err)
+	}
+}
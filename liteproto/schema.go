@@ -0,0 +1,763 @@
+package liteproto
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Schema validates JSON payloads (Data) of messages. It supports a subset of JSON Schema:
+// type, enum, const, properties, required, additionalProperties, items, minItems, maxItems, uniqueItems,
+// minLength, maxLength, pattern, minimum, maximum, exclusiveMinimum, exclusiveMaximum, multipleOf,
+// minProperties, maxProperties, allOf, anyOf, oneOf, not, boolean schemas and local references
+// ($ref to "#", "#/$defs/..." or "#/definitions/..."). Other keywords, like format, are ignored.
+// Schemas are created with ParseSchema or SchemaFor and are safe for concurrent use.
+type Schema struct {
+	raw  json.RawMessage
+	root *schemaNode
+}
+
+// ParseSchema parses a JSON Schema document.
+func ParseSchema(data []byte) (*Schema, error) {
+	p := schemaParser{refs: map[string]*schemaNode{}}
+
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(data, &doc); err == nil {
+		p.defs = map[string]json.RawMessage{}
+		for _, keyword := range []string{"definitions", "$defs"} {
+			var defs map[string]json.RawMessage
+			if raw, ok := doc[keyword]; ok {
+				if err = json.Unmarshal(raw, &defs); err != nil {
+					return nil, fmt.Errorf("schema: invalid %s: %w", keyword, err)
+				}
+			}
+			for name, def := range defs {
+				p.defs["#/"+keyword+"/"+escapePointer(name)] = def
+			}
+		}
+	}
+
+	root := &schemaNode{}
+	p.refs["#"] = root
+
+	if err := p.parse(root, data, "#"); err != nil {
+		return nil, err
+	}
+
+	// parse the referenced definitions, they can reference further definitions
+
+	for len(p.pending) > 0 {
+		ref := p.pending[0]
+		p.pending = p.pending[1:]
+
+		if err := p.parse(p.refs[ref], p.defs[ref], ref); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := checkCycles(root); err != nil {
+		return nil, err
+	}
+
+	return &Schema{raw: append(json.RawMessage(nil), data...), root: root}, nil
+}
+
+// Validate validates a JSON payload. Empty payload is validated as JSON null.
+// It returns a *ValidationError if the payload doesn't match the schema.
+func (s *Schema) Validate(data []byte) error {
+	if len(bytes.TrimSpace(data)) == 0 {
+		data = []byte("null")
+	}
+
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+
+	var v interface{}
+	err := d.Decode(&v)
+	if err == nil && d.Decode(&struct{}{}) != io.EOF {
+		err = errors.New("unexpected data after the JSON value")
+	}
+	if err != nil {
+		return &ValidationError{Errors: []FieldError{{Path: "", Message: "invalid JSON: " + err.Error()}}}
+	}
+
+	var errs []FieldError
+	s.root.validate(v, "", 0, &errs)
+
+	if len(errs) > 0 {
+		return &ValidationError{Errors: errs}
+	}
+
+	return nil
+}
+
+// MarshalJSON returns the schema document.
+func (s *Schema) MarshalJSON() ([]byte, error) {
+	return s.raw, nil
+}
+
+// UnmarshalJSON parses a schema document.
+func (s *Schema) UnmarshalJSON(data []byte) error {
+	parsed, err := ParseSchema(data)
+	if err != nil {
+		return err
+	}
+
+	*s = *parsed
+	return nil
+}
+
+// FetchSchemas asks a remote server for the schemas of its task types with a TypeSchema request.
+// If no types are provided, the schemas of all types are returned.
+func FetchSchemas(ctx context.Context, client Client, deadline time.Time, types ...string) (map[string]TypeSchemas, error) {
+	id := make([]byte, 8)
+	_, _ = rand.Read(id)
+
+	data, err := json.Marshal(SchemaRequest{Types: types})
+	if err != nil {
+		return nil, err
+	}
+
+	response, stop, err := client.CallWithDeadline(ctx, TaskRequest{
+		ID:   TypeSchema + "." + hex.EncodeToString(id),
+		Type: TypeSchema,
+		Data: data,
+	}, deadline)
+	if err != nil {
+		return nil, err
+	}
+	defer close(stop)
+
+	r, ok := <-response
+	if !ok {
+		return nil, context.DeadlineExceeded
+	}
+
+	if r.Status == StatusError {
+		var message string
+		_ = json.Unmarshal(r.Data, &message)
+		return nil, errors.New(message)
+	}
+
+	var schemas map[string]TypeSchemas
+	if err = json.Unmarshal(r.Data, &schemas); err != nil {
+		return nil, err
+	}
+
+	return schemas, nil
+}
+
+type schemaParser struct {
+	defs    map[string]json.RawMessage // definitions by their reference
+	refs    map[string]*schemaNode
+	pending []string
+}
+
+// schemaNode is a parsed schema. Pointer fields are nil if the keyword is absent.
+type schemaNode struct {
+	always *bool // boolean schema
+
+	ref *schemaNode
+
+	types    []string
+	enum     []interface{}
+	constant *interface{}
+
+	properties    map[string]*schemaNode
+	required      []string
+	additional    *schemaNode
+	minProperties *int
+	maxProperties *int
+
+	items       *schemaNode
+	minItems    *int
+	maxItems    *int
+	uniqueItems bool
+
+	minLength *int
+	maxLength *int
+	pattern   *regexp.Regexp
+
+	minimum          *float64
+	maximum          *float64
+	exclusiveMinimum *float64
+	exclusiveMaximum *float64
+	multipleOf       *float64
+
+	allOf []*schemaNode
+	anyOf []*schemaNode
+	oneOf []*schemaNode
+	not   *schemaNode
+}
+
+const typeMismatch = "must be of type "
+
+// maxSchemaDepth limits the nesting of schemas applied while validating a value.
+const maxSchemaDepth = 1000
+
+var schemaTypes = map[string]bool{
+	"null": true, "boolean": true, "object": true, "array": true, "number": true, "integer": true, "string": true,
+}
+
+func (p *schemaParser) parse(n *schemaNode, data []byte, path string) (err error) {
+	var always bool
+	if json.Unmarshal(data, &always) == nil {
+		n.always = &always
+		return nil
+	}
+
+	var keywords map[string]json.RawMessage
+	if err = json.Unmarshal(data, &keywords); err != nil || keywords == nil {
+		return fmt.Errorf("schema: %s: must be an object or a boolean", path)
+	}
+
+	fail := func(keyword string, err error) error {
+		return fmt.Errorf("schema: %s/%s: %w", path, keyword, err)
+	}
+
+	sub := func(keyword string, raw json.RawMessage) (*schemaNode, error) {
+		node := &schemaNode{}
+		return node, p.parse(node, raw, path+"/"+keyword)
+	}
+
+	subs := func(keyword string, raw json.RawMessage) ([]*schemaNode, error) {
+		var list []json.RawMessage
+		if err := json.Unmarshal(raw, &list); err != nil || len(list) == 0 {
+			return nil, fail(keyword, errors.New("must be a non-empty array"))
+		}
+
+		nodes := make([]*schemaNode, len(list))
+		for i := range list {
+			node, err := sub(keyword+"/"+strconv.Itoa(i), list[i])
+			if err != nil {
+				return nil, err
+			}
+			nodes[i] = node
+		}
+
+		return nodes, nil
+	}
+
+	count := func(keyword string, raw json.RawMessage) (*int, error) {
+		var v int
+		if err := json.Unmarshal(raw, &v); err != nil || v < 0 {
+			return nil, fail(keyword, errors.New("must be a non-negative integer"))
+		}
+		return &v, nil
+	}
+
+	number := func(keyword string, raw json.RawMessage) (*float64, error) {
+		var v float64
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return nil, fail(keyword, errors.New("must be a number"))
+		}
+		return &v, nil
+	}
+
+	for keyword, raw := range keywords {
+		switch keyword {
+		case "$ref":
+			var ref string
+			if err = json.Unmarshal(raw, &ref); err != nil {
+				return fail(keyword, errors.New("must be a string"))
+			}
+			if n.ref, err = p.resolve(ref); err != nil {
+				return fail(keyword, err)
+			}
+
+		case "type":
+			var t string
+			if json.Unmarshal(raw, &t) == nil {
+				n.types = []string{t}
+			} else if err = json.Unmarshal(raw, &n.types); err != nil {
+				return fail(keyword, errors.New("must be a string or an array of strings"))
+			}
+			for _, t := range n.types {
+				if !schemaTypes[t] {
+					return fail(keyword, fmt.Errorf("unknown type %q", t))
+				}
+			}
+
+		case "enum":
+			if err = unmarshalNumbers(raw, &n.enum); err != nil {
+				return fail(keyword, errors.New("must be an array"))
+			}
+
+		case "const":
+			var v interface{}
+			if err = unmarshalNumbers(raw, &v); err != nil {
+				return fail(keyword, err)
+			}
+			n.constant = &v
+
+		case "properties":
+			var props map[string]json.RawMessage
+			if err = json.Unmarshal(raw, &props); err != nil {
+				return fail(keyword, errors.New("must be an object"))
+			}
+			n.properties = make(map[string]*schemaNode, len(props))
+			for name, prop := range props {
+				if n.properties[name], err = sub(keyword+"/"+escapePointer(name), prop); err != nil {
+					return err
+				}
+			}
+
+		case "required":
+			if err = json.Unmarshal(raw, &n.required); err != nil {
+				return fail(keyword, errors.New("must be an array of strings"))
+			}
+
+		case "additionalProperties":
+			n.additional, err = sub(keyword, raw)
+		case "minProperties":
+			n.minProperties, err = count(keyword, raw)
+		case "maxProperties":
+			n.maxProperties, err = count(keyword, raw)
+
+		case "items":
+			n.items, err = sub(keyword, raw)
+		case "minItems":
+			n.minItems, err = count(keyword, raw)
+		case "maxItems":
+			n.maxItems, err = count(keyword, raw)
+		case "uniqueItems":
+			if err = json.Unmarshal(raw, &n.uniqueItems); err != nil {
+				return fail(keyword, errors.New("must be a boolean"))
+			}
+
+		case "minLength":
+			n.minLength, err = count(keyword, raw)
+		case "maxLength":
+			n.maxLength, err = count(keyword, raw)
+		case "pattern":
+			var pattern string
+			if err = json.Unmarshal(raw, &pattern); err != nil {
+				return fail(keyword, errors.New("must be a string"))
+			}
+			if n.pattern, err = regexp.Compile(pattern); err != nil {
+				return fail(keyword, err)
+			}
+
+		case "minimum":
+			n.minimum, err = number(keyword, raw)
+		case "maximum":
+			n.maximum, err = number(keyword, raw)
+		case "exclusiveMinimum":
+			n.exclusiveMinimum, err = number(keyword, raw)
+		case "exclusiveMaximum":
+			n.exclusiveMaximum, err = number(keyword, raw)
+		case "multipleOf":
+			if n.multipleOf, err = number(keyword, raw); err == nil && *n.multipleOf <= 0 {
+				return fail(keyword, errors.New("must be greater than 0"))
+			}
+
+		case "allOf":
+			n.allOf, err = subs(keyword, raw)
+		case "anyOf":
+			n.anyOf, err = subs(keyword, raw)
+		case "oneOf":
+			n.oneOf, err = subs(keyword, raw)
+		case "not":
+			n.not, err = sub(keyword, raw)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// resolve returns the node of a local reference. Definitions are parsed after the root schema.
+func (p *schemaParser) resolve(ref string) (*schemaNode, error) {
+	if node, ok := p.refs[ref]; ok {
+		return node, nil
+	}
+
+	if _, ok := p.defs[ref]; !ok {
+		return nil, fmt.Errorf("unsupported or unknown reference %q", ref)
+	}
+
+	node := &schemaNode{}
+	p.refs[ref] = node
+	p.pending = append(p.pending, ref)
+
+	return node, nil
+}
+
+// checkCycles returns an error if references form a cycle in which schemas apply to the same value,
+// like {"$ref":"#"}. Validation with such a schema would never end. Cycles through properties
+// or items are fine, each step goes into a nested value.
+func checkCycles(root *schemaNode) error {
+	const (
+		visiting = 1
+		visited  = 2
+	)
+
+	state := map[*schemaNode]int{}
+	nested := []*schemaNode{root}
+	seen := map[*schemaNode]bool{root: true}
+
+	var visit func(n *schemaNode) error
+	visit = func(n *schemaNode) error {
+		switch state[n] {
+		case visiting:
+			return errors.New("schema: references form a cycle that doesn't go into a nested value")
+		case visited:
+			return nil
+		}
+		state[n] = visiting
+
+		for _, child := range []*schemaNode{n.additional, n.items} {
+			if child != nil && !seen[child] {
+				seen[child] = true
+				nested = append(nested, child)
+			}
+		}
+		for _, child := range n.properties {
+			if !seen[child] {
+				seen[child] = true
+				nested = append(nested, child)
+			}
+		}
+
+		same := append(append(append([]*schemaNode{n.ref, n.not}, n.allOf...), n.anyOf...), n.oneOf...)
+		for _, child := range same {
+			if child == nil {
+				continue
+			}
+			if err := visit(child); err != nil {
+				return err
+			}
+		}
+
+		state[n] = visited
+		return nil
+	}
+
+	for len(nested) > 0 {
+		n := nested[0]
+		nested = nested[1:]
+
+		if err := visit(n); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (n *schemaNode) validate(v interface{}, path string, depth int, errs *[]FieldError) {
+	fail := func(format string, args ...interface{}) {
+		*errs = append(*errs, FieldError{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if depth > maxSchemaDepth {
+		fail("is nested too deeply")
+		return
+	}
+	depth++
+
+	if n.always != nil {
+		if !*n.always {
+			fail("is not allowed")
+		}
+		return
+	}
+
+	if n.ref != nil {
+		n.ref.validate(v, path, depth, errs)
+	}
+
+	if len(n.types) > 0 && !matchesType(v, n.types) {
+		fail(typeMismatch+"%s", strings.Join(n.types, " or "))
+		return
+	}
+
+	if n.enum != nil {
+		found := false
+		for _, e := range n.enum {
+			if jsonEqual(v, e) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			fail("must be one of %s", formatValues(n.enum))
+		}
+	}
+
+	if n.constant != nil && !jsonEqual(v, *n.constant) {
+		fail("must be %s", formatValues([]interface{}{*n.constant}))
+	}
+
+	switch v := v.(type) {
+	case string:
+		length := utf8.RuneCountInString(v)
+		if n.minLength != nil && length < *n.minLength {
+			fail("must be at least %d characters long", *n.minLength)
+		}
+		if n.maxLength != nil && length > *n.maxLength {
+			fail("must be at most %d characters long", *n.maxLength)
+		}
+		if n.pattern != nil && !n.pattern.MatchString(v) {
+			fail("must match pattern %q", n.pattern.String())
+		}
+
+	case json.Number:
+		f, _ := v.Float64()
+		if n.minimum != nil && f < *n.minimum {
+			fail("must be >= %s", formatNumber(*n.minimum))
+		}
+		if n.maximum != nil && f > *n.maximum {
+			fail("must be <= %s", formatNumber(*n.maximum))
+		}
+		if n.exclusiveMinimum != nil && f <= *n.exclusiveMinimum {
+			fail("must be > %s", formatNumber(*n.exclusiveMinimum))
+		}
+		if n.exclusiveMaximum != nil && f >= *n.exclusiveMaximum {
+			fail("must be < %s", formatNumber(*n.exclusiveMaximum))
+		}
+		if n.multipleOf != nil {
+			if q := f / *n.multipleOf; math.Abs(q-math.Round(q)) > 1e-9 {
+				fail("must be a multiple of %s", formatNumber(*n.multipleOf))
+			}
+		}
+
+	case map[string]interface{}:
+		if n.minProperties != nil && len(v) < *n.minProperties {
+			fail("must have at least %d properties", *n.minProperties)
+		}
+		if n.maxProperties != nil && len(v) > *n.maxProperties {
+			fail("must have at most %d properties", *n.maxProperties)
+		}
+
+		for _, name := range n.required {
+			if _, ok := v[name]; !ok {
+				*errs = append(*errs, FieldError{Path: path + "/" + escapePointer(name), Message: "is required"})
+			}
+		}
+
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			if prop, ok := n.properties[name]; ok {
+				prop.validate(v[name], path+"/"+escapePointer(name), depth, errs)
+			} else if n.additional != nil {
+				n.additional.validate(v[name], path+"/"+escapePointer(name), depth, errs)
+			}
+		}
+
+	case []interface{}:
+		if n.minItems != nil && len(v) < *n.minItems {
+			fail("must have at least %d items", *n.minItems)
+		}
+		if n.maxItems != nil && len(v) > *n.maxItems {
+			fail("must have at most %d items", *n.maxItems)
+		}
+
+		if n.uniqueItems {
+		unique:
+			for i := range v {
+				for j := 0; j < i; j++ {
+					if jsonEqual(v[i], v[j]) {
+						fail("must have unique items, items %d and %d are equal", j, i)
+						break unique
+					}
+				}
+			}
+		}
+
+		if n.items != nil {
+			for i := range v {
+				n.items.validate(v[i], path+"/"+strconv.Itoa(i), depth, errs)
+			}
+		}
+	}
+
+	for _, s := range n.allOf {
+		s.validate(v, path, depth, errs)
+	}
+
+	if n.anyOf != nil {
+		if matched, branchErrs := matchAll(n.anyOf, v, path, depth, true); matched == 0 {
+			if branchErrs != nil {
+				*errs = append(*errs, branchErrs...)
+			} else {
+				fail("must match at least one schema of anyOf")
+			}
+		}
+	}
+
+	if n.oneOf != nil {
+		matched, branchErrs := matchAll(n.oneOf, v, path, depth, false)
+		switch {
+		case matched == 0 && branchErrs != nil:
+			*errs = append(*errs, branchErrs...)
+		case matched != 1:
+			fail("must match exactly one schema of oneOf, matches %d", matched)
+		}
+	}
+
+	if n.not != nil && n.not.matches(v, path, depth) {
+		fail("must not match the schema of not")
+	}
+}
+
+// matchAll validates the value with the schemas and returns the number of schemas it matches.
+// If it matches none, but all schemas except one fail only because of the type of the value,
+// the errors of that schema are returned, as they tell more than a failed anyOf or oneOf.
+func matchAll(schemas []*schemaNode, v interface{}, path string, depth int, first bool) (matched int, errs []FieldError) {
+	var candidates int
+
+	for _, s := range schemas {
+		var branchErrs []FieldError
+		s.validate(v, path, depth, &branchErrs)
+
+		if len(branchErrs) == 0 {
+			matched++
+			if first {
+				return matched, nil
+			}
+			continue
+		}
+
+		if len(branchErrs) == 1 && branchErrs[0].Path == path && strings.HasPrefix(branchErrs[0].Message, typeMismatch) {
+			continue
+		}
+
+		candidates++
+		errs = branchErrs
+	}
+
+	if matched > 0 || candidates != 1 {
+		return matched, nil
+	}
+
+	return matched, errs
+}
+
+func (n *schemaNode) matches(v interface{}, path string, depth int) bool {
+	var errs []FieldError
+	n.validate(v, path, depth, &errs)
+	return len(errs) == 0
+}
+
+func matchesType(v interface{}, types []string) bool {
+	for _, t := range types {
+		switch v := v.(type) {
+		case nil:
+			if t == "null" {
+				return true
+			}
+		case bool:
+			if t == "boolean" {
+				return true
+			}
+		case string:
+			if t == "string" {
+				return true
+			}
+		case map[string]interface{}:
+			if t == "object" {
+				return true
+			}
+		case []interface{}:
+			if t == "array" {
+				return true
+			}
+		case json.Number:
+			if t == "number" {
+				return true
+			}
+			if f, err := v.Float64(); t == "integer" && err == nil && f == math.Trunc(f) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// jsonEqual compares two decoded JSON values, numbers are compared by value.
+func jsonEqual(a, b interface{}) bool {
+	if x, ok := a.(json.Number); ok {
+		y, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		fx, _ := x.Float64()
+		fy, _ := y.Float64()
+		return fx == fy
+	}
+
+	switch x := a.(type) {
+	case map[string]interface{}:
+		y, ok := b.(map[string]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for k := range x {
+			if v, ok := y[k]; !ok || !jsonEqual(x[k], v) {
+				return false
+			}
+		}
+		return true
+
+	case []interface{}:
+		y, ok := b.([]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if !jsonEqual(x[i], y[i]) {
+				return false
+			}
+		}
+		return true
+	}
+
+	return reflect.DeepEqual(a, b)
+}
+
+func unmarshalNumbers(data []byte, v interface{}) error {
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	return d.Decode(v)
+}
+
+func formatValues(values []interface{}) string {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		data, _ := json.Marshal(v)
+		parts[i] = string(data)
+	}
+	return strings.Join(parts, ", ")
+}
+
+func formatNumber(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// escapePointer escapes a JSON Pointer reference token.
+func escapePointer(s string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(s, "~", "~0"), "/", "~1")
+}
@@ -0,0 +1,360 @@
+package liteproto
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSchemaValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+		data   string
+		paths  []string // paths of the expected errors, nil if valid
+	}{
+		{"true", `true`, `1`, nil},
+		{"false", `false`, `1`, []string{""}},
+		{"empty data is null", `{"type":"null"}`, ``, nil},
+		{"invalid json", `{}`, `{`, []string{""}},
+		{"trailing data", `{}`, `1 2`, []string{""}},
+
+		{"type", `{"type":"string"}`, `"a"`, nil},
+		{"type mismatch", `{"type":"string"}`, `1`, []string{""}},
+		{"type list", `{"type":["string","null"]}`, `null`, nil},
+		{"integer", `{"type":"integer"}`, `2.0`, nil},
+		{"integer fraction", `{"type":"integer"}`, `2.5`, []string{""}},
+		{"number", `{"type":"number"}`, `2.5`, nil},
+		{"boolean", `{"type":"boolean"}`, `true`, nil},
+		{"object", `{"type":"object"}`, `[]`, []string{""}},
+		{"array", `{"type":"array"}`, `[]`, nil},
+
+		{"enum", `{"enum":["a",1]}`, `1.0`, nil},
+		{"enum mismatch", `{"enum":["a",1]}`, `"b"`, []string{""}},
+		{"const", `{"const":{"a":[1]}}`, `{"a":[1]}`, nil},
+		{"const mismatch", `{"const":{"a":[1]}}`, `{"a":[2]}`, []string{""}},
+
+		{"properties", `{"properties":{"a":{"type":"string"}}}`, `{"a":1}`, []string{"/a"}},
+		{"required", `{"required":["a","b"]}`, `{"a":1}`, []string{"/b"}},
+		{"additionalProperties false", `{"properties":{"a":{}},"additionalProperties":false}`, `{"a":1,"b":2}`, []string{"/b"}},
+		{"additionalProperties schema", `{"additionalProperties":{"type":"integer"}}`, `{"a":1,"b":"x"}`, []string{"/b"}},
+		{"minProperties", `{"minProperties":2}`, `{"a":1}`, []string{""}},
+		{"maxProperties", `{"maxProperties":1}`, `{"a":1,"b":2}`, []string{""}},
+		{"escaped path", `{"properties":{"a/b~":{"type":"string"}}}`, `{"a/b~":1}`, []string{"/a~1b~0"}},
+
+		{"items", `{"items":{"type":"integer"}}`, `[1,"a",2,"b"]`, []string{"/1", "/3"}},
+		{"minItems", `{"minItems":2}`, `[1]`, []string{""}},
+		{"maxItems", `{"maxItems":1}`, `[1,2]`, []string{""}},
+		{"uniqueItems", `{"uniqueItems":true}`, `[1,{"a":1},1.0]`, []string{""}},
+		{"uniqueItems valid", `{"uniqueItems":true}`, `[1,{"a":1},{"a":2}]`, nil},
+
+		{"minLength", `{"minLength":3}`, `"ab"`, []string{""}},
+		{"maxLength runes", `{"maxLength":2}`, `"žš"`, nil},
+		{"maxLength", `{"maxLength":2}`, `"abc"`, []string{""}},
+		{"pattern", `{"pattern":"^a+$"}`, `"aab"`, []string{""}},
+
+		{"minimum", `{"minimum":1}`, `1`, nil},
+		{"minimum fails", `{"minimum":1}`, `0.5`, []string{""}},
+		{"maximum", `{"maximum":1}`, `2`, []string{""}},
+		{"exclusiveMinimum", `{"exclusiveMinimum":1}`, `1`, []string{""}},
+		{"exclusiveMaximum", `{"exclusiveMaximum":1}`, `1`, []string{""}},
+		{"multipleOf", `{"multipleOf":0.1}`, `0.3`, nil},
+		{"multipleOf fails", `{"multipleOf":3}`, `10`, []string{""}},
+		{"multipleOf big number", `{"multipleOf":2}`, `12345678901234567890`, nil},
+		{"keywords of other types", `{"minLength":3,"minimum":5}`, `[1]`, nil},
+
+		{"allOf", `{"allOf":[{"type":"integer"},{"minimum":5}]}`, `3`, []string{""}},
+		{"anyOf", `{"anyOf":[{"type":"string"},{"minimum":5}]}`, `6`, nil},
+		{"anyOf fails", `{"anyOf":[{"type":"string"},{"minimum":5}]}`, `3`, []string{""}},
+		{"oneOf", `{"oneOf":[{"type":"integer"},{"minimum":5}]}`, `3`, nil},
+		{"oneOf both", `{"oneOf":[{"type":"integer"},{"minimum":5}]}`, `6`, []string{""}},
+		{"oneOf none", `{"oneOf":[{"type":"integer"},{"minimum":5}]}`, `4.5`, []string{""}},
+		{"anyOf reports the schema of the type", `{"anyOf":[{"type":"null"},{"type":"object","required":["a"]}]}`, `{}`, []string{"/a"}},
+		{"anyOf of several types", `{"anyOf":[{"type":"string","minLength":2},{"type":"integer","minimum":5}]}`, `true`, []string{""}},
+		{"not", `{"not":{"type":"string"}}`, `"a"`, []string{""}},
+
+		{"ref defs", `{"$defs":{"n":{"type":"integer"}},"properties":{"a":{"$ref":"#/$defs/n"}}}`, `{"a":"x"}`, []string{"/a"}},
+		{"ref definitions", `{"definitions":{"n":{"type":"integer"}},"items":{"$ref":"#/definitions/n"}}`, `[1,"x"]`, []string{"/1"}},
+		{"ref chain", `{"$defs":{"a":{"$ref":"#/$defs/b"},"b":{"type":"integer"}},"$ref":"#/$defs/a"}`, `"x"`, []string{""}},
+		{"ref root", `{"properties":{"next":{"$ref":"#"},"v":{"type":"integer"}}}`, `{"next":{"next":{"v":"x"}}}`, []string{"/next/next/v"}},
+		{"ref with keywords", `{"$defs":{"n":{"type":"integer"}},"$ref":"#/$defs/n","minimum":5}`, `3`, []string{""}},
+		{"format is ignored", `{"format":"email"}`, `"x"`, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, err := ParseSchema([]byte(test.schema))
+			if err != nil {
+				t.Fatalf("failed to parse schema: %v", err)
+			}
+
+			err = s.Validate([]byte(test.data))
+			if test.paths == nil {
+				if err != nil {
+					t.Fatalf("expected valid, got %v", err)
+				}
+				return
+			}
+
+			verr, ok := err.(*ValidationError)
+			if !ok {
+				t.Fatalf("expected *ValidationError, got %v", err)
+			}
+
+			paths := make([]string, len(verr.Errors))
+			for i, e := range verr.Errors {
+				paths[i] = e.Path
+				if e.Message == "" {
+					t.Errorf("empty message for path %q", e.Path)
+				}
+			}
+
+			if !reflect.DeepEqual(paths, test.paths) {
+				t.Errorf("expected errors at %q, got %v", test.paths, verr.Errors)
+			}
+		})
+	}
+}
+
+func TestParseSchemaErrors(t *testing.T) {
+	tests := []string{
+		`1`,
+		`"string"`,
+		`{"type":"text"}`,
+		`{"type":1}`,
+		`{"minLength":-1}`,
+		`{"maxItems":"a"}`,
+		`{"minimum":"a"}`,
+		`{"multipleOf":0}`,
+		`{"pattern":"("}`,
+		`{"required":"a"}`,
+		`{"allOf":[]}`,
+		`{"anyOf":{}}`,
+		`{"$ref":"#/$defs/missing"}`,
+		`{"$ref":"http://example.com/schema"}`,
+		`{"properties":{"a":1}}`,
+		`{"$defs":{"a":1},"$ref":"#/$defs/a"}`,
+	}
+
+	for _, test := range tests {
+		if _, err := ParseSchema([]byte(test)); err == nil {
+			t.Errorf("expected error for %s", test)
+		}
+	}
+}
+
+func TestSchemaCycles(t *testing.T) {
+	tests := []struct {
+		schema string
+		cycle  bool
+	}{
+		{`{"$ref":"#"}`, true},
+		{`{"allOf":[{"$ref":"#"}]}`, true},
+		{`{"not":{"anyOf":[{"$ref":"#"}]}}`, true},
+		{`{"$defs":{"a":{"$ref":"#/$defs/b"},"b":{"oneOf":[{"$ref":"#/$defs/a"}]}},"$ref":"#/$defs/a"}`, true},
+		{`{"type":"object","properties":{"next":{"$ref":"#"}}}`, false},
+		{`{"items":{"$ref":"#"}}`, false},
+		{`{"$defs":{"a":{"additionalProperties":{"$ref":"#/$defs/a"}}},"allOf":[{"$ref":"#/$defs/a"}]}`, false},
+	}
+
+	for _, test := range tests {
+		_, err := ParseSchema([]byte(test.schema))
+		if test.cycle && (err == nil || !strings.Contains(err.Error(), "cycle")) {
+			t.Errorf("%s: expected a cycle error, got %v", test.schema, err)
+		} else if !test.cycle && err != nil && strings.Contains(err.Error(), "cycle") {
+			t.Errorf("%s: unexpected cycle error %v", test.schema, err)
+		}
+	}
+}
+
+func TestSchemaDepth(t *testing.T) {
+	s, err := ParseSchema([]byte(`{"items":{"$ref":"#"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nested := func(depth int) []byte {
+		return []byte(strings.Repeat("[", depth) + strings.Repeat("]", depth))
+	}
+
+	if err = s.Validate(nested(10)); err != nil {
+		t.Errorf("expected a valid value, got %v", err)
+	}
+
+	if err = s.Validate(nested(5000)); err == nil || !strings.Contains(err.Error(), "nested too deeply") {
+		t.Errorf("expected an error of a deeply nested value, got %v", err)
+	}
+}
+
+func TestSchemaJSON(t *testing.T) {
+	doc := `{"type":"object","required":["a"]}`
+
+	s, err := ParseSchema([]byte(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := json.Marshal(TypeSchemas{Request: s})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ts TypeSchemas
+	if err = json.Unmarshal(data, &ts); err != nil {
+		t.Fatal(err)
+	}
+
+	if ts.Response != nil {
+		t.Error("expected no response schema")
+	}
+
+	if ts.Request == nil || ts.Request.Validate([]byte(`{}`)) == nil {
+		t.Error("expected the decoded schema to require a")
+	}
+}
+
+func TestValidationErrorMessage(t *testing.T) {
+	err := &ValidationError{
+		Type: "t",
+		Errors: []FieldError{
+			{Path: "", Message: "must be of type object"},
+			{Path: "/a", Message: "is required"},
+		},
+	}
+
+	expected := "invalid payload of type t: must be of type object; /a is required"
+	if err.Error() != expected {
+		t.Errorf("expected %q, got %q", expected, err.Error())
+	}
+}
+
+type schemaBase struct {
+	ID      string `json:"id"`
+	Comment string `json:"comment"`
+}
+
+type schemaTree struct {
+	schemaBase
+	Comment  int               `json:"comment"` // shadows schemaBase.Comment
+	Name     string            `json:"name"`
+	Size     uint16            `json:"size,omitempty"`
+	Ratio    float64           `json:"ratio,string"`
+	Parent   *schemaTree       `json:"parent"`
+	Children []schemaTree      `json:"children"`
+	Leaf     *schemaLeaf       `json:"leaf,omitempty"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	Created  time.Time         `json:"created"`
+	Raw      json.RawMessage   `json:"raw,omitempty"`
+	Bytes    []byte            `json:"bytes,omitempty"`
+	Any      interface{}       `json:"any,omitempty"`
+	Fixed    [2]int            `json:"fixed"`
+	Skipped  string            `json:"-"`
+	hidden   string
+}
+
+type schemaLeaf struct {
+	Value int         `json:"value"`
+	Back  *schemaTree `json:"back,omitempty"`
+}
+
+func TestSchemaFor(t *testing.T) {
+	s := SchemaFor(&schemaTree{})
+
+	valid := `{
+		"id": "1", "comment": 3, "name": "root", "ratio": "0.5", "created": "2020-01-01T00:00:00Z", "fixed": [1, 2],
+		"parent": null, "children": [
+			{"id": "2", "comment": 0, "name": "a", "ratio": "1", "created": "2020-01-01T00:00:00Z", "fixed": [0, 0],
+			 "parent": null, "children": null, "leaf": {"value": 1, "back": null}}
+		],
+		"labels": {"k": "v"}, "raw": {"anything": [1]}, "bytes": "AAE=", "any": [true]
+	}`
+
+	if err := s.Validate([]byte(valid)); err != nil {
+		t.Fatalf("expected valid, got %v", err)
+	}
+
+	invalid := `{
+		"id": 1, "comment": "x", "size": -1, "ratio": 0.5, "created": 5, "fixed": [1],
+		"parent": {"name": "p"}, "children": [{"leaf": {"value": "x"}}], "labels": {"k": 1}, "Skipped": 1
+	}`
+
+	err := s.Validate([]byte(invalid))
+
+	verr, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("expected *ValidationError, got %v", err)
+	}
+
+	got := map[string]bool{}
+	for _, e := range verr.Errors {
+		got[e.Path] = true
+	}
+
+	for _, path := range []string{
+		"/name", "/id", "/comment", "/size", "/ratio", "/created", "/fixed",
+		"/parent/id", "/parent/children", "/children/0/name", "/children/0/leaf/value", "/labels/k",
+	} {
+		if !got[path] {
+			t.Errorf("expected an error at %s, got %v", path, verr.Errors)
+		}
+	}
+
+	if got["/Skipped"] || got["/hidden"] {
+		t.Errorf("unexpected errors for ignored fields: %v", verr.Errors)
+	}
+}
+
+func TestSchemaForNames(t *testing.T) {
+	type inner struct {
+		A int `json:"a"`
+	}
+
+	type outer struct {
+		Local  inner      `json:"local"`
+		Shared schemaLeaf `json:"shared"`
+		Again  schemaLeaf `json:"again"`
+	}
+
+	data, err := json.Marshal(SchemaFor(outer{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var doc struct {
+		Defs map[string]json.RawMessage `json:"$defs"`
+	}
+	if err = json.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	// schemaLeaf references schemaTree, which is defined once although it's recursive
+	for _, name := range []string{"inner", "schemaLeaf", "schemaTree"} {
+		if _, ok := doc.Defs[name]; !ok {
+			t.Errorf("expected definition %s in %s", name, data)
+		}
+	}
+
+	if len(doc.Defs) != 3 {
+		t.Errorf("expected 3 definitions, got %d", len(doc.Defs))
+	}
+}
+
+func TestSchemaForUnsupported(t *testing.T) {
+	for _, v := range []interface{}{
+		make(chan int),
+		struct{ F func() }{},
+		map[[2]int]string{},
+		complex(1, 2),
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("expected panic for %T", v)
+				}
+			}()
+			SchemaFor(v)
+		}()
+	}
+}
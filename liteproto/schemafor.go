@@ -0,0 +1,227 @@
+package liteproto
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// SchemaFor derives a Schema from the Go type of value v, following the rules of encoding/json:
+// exported struct fields are properties named by their json tags, embedded structs are flattened,
+// fields without omitempty are required and pointers are nullable. Values of types that implement
+// json.Marshaler or encoding.TextMarshaler are not validated, except time.Time, which must be a string.
+// Named struct types other than v's type are put into "$defs", so recursive types are supported.
+// It panics if the type can't be encoded as JSON, for example a channel or a function.
+func SchemaFor(v interface{}) *Schema {
+	g := schemaGenerator{
+		defs:  map[string]interface{}{},
+		names: map[reflect.Type]string{},
+	}
+
+	t := reflect.TypeOf(v)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	g.root = t
+
+	doc := g.schema(t)
+	if len(g.defs) > 0 {
+		doc["$defs"] = g.defs
+	}
+
+	data, err := json.Marshal(doc)
+	if err != nil {
+		panic(err)
+	}
+
+	s, err := ParseSchema(data)
+	if err != nil {
+		panic(err)
+	}
+
+	return s
+}
+
+type schemaGenerator struct {
+	root  reflect.Type
+	defs  map[string]interface{}
+	names map[reflect.Type]string // names of struct types in defs
+}
+
+var (
+	timeType          = reflect.TypeOf(time.Time{})
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+func (g *schemaGenerator) schema(t reflect.Type) map[string]interface{} {
+	if t == nil {
+		return map[string]interface{}{}
+	}
+
+	switch {
+	case t == timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case t.Implements(jsonMarshalerType) || reflect.PtrTo(t).Implements(jsonMarshalerType):
+		return map[string]interface{}{}
+	case t.Implements(textMarshalerType) || reflect.PtrTo(t).Implements(textMarshalerType):
+		return map[string]interface{}{"type": "string"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return map[string]interface{}{"type": "integer", "minimum": 0}
+
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+
+	case reflect.Interface:
+		return map[string]interface{}{}
+
+	case reflect.Ptr:
+		s := g.schema(t.Elem())
+		if typ, ok := s["type"].(string); ok {
+			s["type"] = []string{typ, "null"}
+			return s
+		}
+		if len(s) == 0 {
+			return s
+		}
+		return map[string]interface{}{"anyOf": []interface{}{s, map[string]interface{}{"type": "null"}}}
+
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 && !reflect.PtrTo(t.Elem()).Implements(textMarshalerType) {
+			return map[string]interface{}{"type": []string{"string", "null"}} // base64
+		}
+		return map[string]interface{}{"type": []string{"array", "null"}, "items": g.schema(t.Elem())}
+
+	case reflect.Array:
+		return map[string]interface{}{
+			"type":     "array",
+			"items":    g.schema(t.Elem()),
+			"minItems": t.Len(),
+			"maxItems": t.Len(),
+		}
+
+	case reflect.Map:
+		switch t.Key().Kind() {
+		case reflect.String,
+			reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		default:
+			if !t.Key().Implements(textMarshalerType) {
+				panic(fmt.Sprintf("liteproto: unsupported map key type %s", t.Key()))
+			}
+		}
+		return map[string]interface{}{"type": []string{"object", "null"}, "additionalProperties": g.schema(t.Elem())}
+
+	case reflect.Struct:
+		if t == g.root {
+			if _, ok := g.names[t]; ok {
+				return map[string]interface{}{"$ref": "#"}
+			}
+			g.names[t] = "#"
+			return g.object(t)
+		}
+
+		if t.Name() == "" {
+			return g.object(t)
+		}
+
+		if name, ok := g.names[t]; ok {
+			return map[string]interface{}{"$ref": name}
+		}
+
+		name := t.Name()
+		for i := 2; g.defs[name] != nil; i++ {
+			name = fmt.Sprintf("%s%d", t.Name(), i)
+		}
+
+		ref := "#/$defs/" + escapePointer(name)
+		g.names[t] = ref
+		g.defs[name] = map[string]interface{}{} // reserve the name
+		g.defs[name] = g.object(t)
+
+		return map[string]interface{}{"$ref": ref}
+	}
+
+	panic(fmt.Sprintf("liteproto: unsupported type %s", t))
+}
+
+func (g *schemaGenerator) object(t reflect.Type) map[string]interface{} {
+	properties := map[string]interface{}{}
+	required := []string{}
+
+	g.fields(t, properties, &required)
+
+	return map[string]interface{}{
+		"type":       "object",
+		"properties": properties,
+		"required":   required,
+	}
+}
+
+func (g *schemaGenerator) fields(t reflect.Type, properties map[string]interface{}, required *[]string) {
+	// fields of embedded structs are added last, shallower fields win like with encoding/json
+	var embedded []reflect.Type
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+
+		name, opts, _ := strings.Cut(tag, ",")
+
+		ft := f.Type
+		if f.Anonymous && name == "" {
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				embedded = append(embedded, ft)
+				continue
+			}
+		}
+
+		if !f.IsExported() {
+			continue
+		}
+
+		if name == "" {
+			name = f.Name
+		}
+
+		if _, ok := properties[name]; ok {
+			continue
+		}
+
+		s := g.schema(ft)
+		if strings.Contains(","+opts+",", ",string,") {
+			s = map[string]interface{}{"type": "string"}
+		}
+		properties[name] = s
+
+		if !strings.Contains(","+opts+",", ",omitempty,") && ft.Kind() != reflect.Ptr {
+			*required = append(*required, name)
+		}
+	}
+
+	for _, et := range embedded {
+		g.fields(et, properties, required)
+	}
+}
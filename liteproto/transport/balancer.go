@@ -0,0 +1,445 @@
+package transport
+
+import (
+	"context"
+	"hash/fnv"
+	"log"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/drone/liteproto/liteproto"
+)
+
+// Pinger checks if a remote endpoint with the provided address is healthy.
+type Pinger func(ctx context.Context, addr string) error
+
+// Balancer picks remote endpoints for calls according to a liteproto.BalancePolicy.
+// It actively checks health of the endpoints with a Pinger and ejects endpoints that fail.
+// Endpoints are identified by addresses, which are opaque to the Balancer.
+type Balancer struct {
+	opts liteproto.BalanceOptions
+	ping Pinger
+
+	endpoints []*endpoint
+	ring      []ringPoint
+	mx        sync.RWMutex
+
+	next uint64 // round robin counter
+
+	resolved   chan struct{} // closed when the first resolution of a watched resolver finished
+	resolveMx  sync.Mutex
+	resolveErr error
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+type endpoint struct {
+	addr      string
+	inFlight  int64
+	healthy   bool
+	failures  int
+	successes int
+	mx        sync.Mutex // guards healthy, failures and successes
+}
+
+type ringPoint struct {
+	hash     uint32
+	endpoint *endpoint
+}
+
+const ringReplicas = 100
+
+// ResolveTimeout is the longest time a Balancer waits for a resolver, or the resolve interval if it's shorter.
+const ResolveTimeout = 5 * time.Second
+
+// NewBalancer creates a new Balancer. Health checks don't run until Start is called.
+func NewBalancer(addrs []string, opts liteproto.BalanceOptions, ping Pinger) *Balancer {
+	if opts.CheckTimeout <= 0 {
+		opts.CheckTimeout = opts.CheckInterval
+	}
+	if opts.FailThreshold <= 0 {
+		opts.FailThreshold = 3
+	}
+	if opts.RecoverThreshold <= 0 {
+		opts.RecoverThreshold = 2
+	}
+
+	b := &Balancer{
+		opts:     opts,
+		ping:     ping,
+		resolved: make(chan struct{}),
+		stop:     make(chan struct{}),
+	}
+	close(b.resolved)
+
+	b.SetAddresses(addrs)
+
+	return b
+}
+
+// SetAddresses replaces the set of endpoints. Endpoints that remain in the set keep their state.
+func (b *Balancer) SetAddresses(addrs []string) {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	existing := make(map[string]*endpoint, len(b.endpoints))
+	for _, e := range b.endpoints {
+		existing[e.addr] = e
+	}
+
+	endpoints := make([]*endpoint, 0, len(addrs))
+	seen := make(map[string]bool, len(addrs))
+	for _, addr := range addrs {
+		if seen[addr] {
+			continue
+		}
+		seen[addr] = true
+
+		e, ok := existing[addr]
+		if !ok {
+			e = &endpoint{addr: addr, healthy: true}
+		}
+		endpoints = append(endpoints, e)
+	}
+
+	ring := make([]ringPoint, 0, len(endpoints)*ringReplicas)
+	for _, e := range endpoints {
+		for i := 0; i < ringReplicas; i++ {
+			ring = append(ring, ringPoint{hash: hashString(e.addr + "#" + strconv.Itoa(i)), endpoint: e})
+		}
+	}
+	sort.Slice(ring, func(i, j int) bool { return ring[i].hash < ring[j].hash })
+
+	b.endpoints = endpoints
+	b.ring = ring
+}
+
+// Addresses returns addresses of all endpoints, including the ejected ones.
+func (b *Balancer) Addresses() []string {
+	b.mx.RLock()
+	defer b.mx.RUnlock()
+
+	addrs := make([]string, len(b.endpoints))
+	for i, e := range b.endpoints {
+		addrs[i] = e.addr
+	}
+
+	return addrs
+}
+
+// Pick selects an endpoint for the request. The returned function done must be called
+// when the call completes, with the error the call returned.
+func (b *Balancer) Pick(r liteproto.TaskRequest) (addr string, done func(err error), err error) {
+	b.mx.RLock()
+	defer b.mx.RUnlock()
+
+	var e *endpoint
+
+	switch b.opts.Policy {
+	case liteproto.BalanceConsistentHash:
+		key := r.ID
+		if b.opts.HashKey != "" {
+			key = r.Metadata[b.opts.HashKey]
+		}
+		e = b.pickHash(key)
+
+	case liteproto.BalanceLeastInFlight:
+		healthy := b.healthy()
+		if len(healthy) == 0 {
+			break
+		}
+
+		// start from a different endpoint each time to spread calls between equally loaded endpoints
+		start := int(atomic.AddUint64(&b.next, 1) % uint64(len(healthy)))
+		for i := range healthy {
+			candidate := healthy[(start+i)%len(healthy)]
+			if e == nil || atomic.LoadInt64(&candidate.inFlight) < atomic.LoadInt64(&e.inFlight) {
+				e = candidate
+			}
+		}
+
+	default:
+		healthy := b.healthy()
+		if len(healthy) > 0 {
+			e = healthy[int(atomic.AddUint64(&b.next, 1)%uint64(len(healthy)))]
+		}
+	}
+
+	if e == nil {
+		return "", nil, liteproto.ErrNoHealthyEndpoint
+	}
+
+	atomic.AddInt64(&e.inFlight, 1)
+
+	done = func(err error) {
+		atomic.AddInt64(&e.inFlight, -1)
+		if err != nil && b.opts.CheckInterval > 0 {
+			b.report(e, err)
+		}
+	}
+
+	return e.addr, done, nil
+}
+
+// Start starts active health checks of endpoints. It does nothing if CheckInterval is zero.
+func (b *Balancer) Start() {
+	if b.opts.CheckInterval <= 0 || b.ping == nil {
+		return
+	}
+
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+
+		ticker := time.NewTicker(b.opts.CheckInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-b.stop:
+				return
+			case <-ticker.C:
+				b.checkAll()
+			}
+		}
+	}()
+}
+
+// Watch periodically updates the set of endpoints with addresses returned by the resolver.
+// The first update starts right away, in the background, so the resolver can't block the caller.
+// Until it finishes, deliveries wait for it if there are no endpoints yet. Failed or empty resolutions
+// don't change the set, the error of the last resolution is returned by ResolveErr.
+// Watching stops when the Balancer is closed.
+func (b *Balancer) Watch(resolver liteproto.Resolver, interval time.Duration, logger *log.Logger) {
+	timeout := ResolveTimeout
+	if interval < timeout {
+		timeout = interval
+	}
+
+	resolve := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+
+		addrs, err := resolver.Resolve(ctx)
+
+		b.resolveMx.Lock()
+		b.resolveErr = err
+		b.resolveMx.Unlock()
+
+		if err != nil {
+			if logger != nil {
+				logger.Printf("failed to resolve endpoints: %s", err.Error())
+			}
+			return
+		}
+
+		if len(addrs) > 0 {
+			b.SetAddresses(addrs)
+		}
+	}
+
+	resolved := make(chan struct{})
+
+	b.mx.Lock()
+	b.resolved = resolved
+	b.mx.Unlock()
+
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+
+		resolve()
+		close(resolved)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-b.stop:
+				return
+			case <-ticker.C:
+				resolve()
+			}
+		}
+	}()
+}
+
+// ResolveErr returns the error of the last resolution of the watched resolver, nil if it succeeded.
+func (b *Balancer) ResolveErr() error {
+	b.resolveMx.Lock()
+	defer b.resolveMx.Unlock()
+
+	return b.resolveErr
+}
+
+// wait waits for the first resolution of the watched resolver if there are no endpoints yet.
+func (b *Balancer) wait(ctx context.Context) error {
+	b.mx.RLock()
+	resolved, empty := b.resolved, len(b.endpoints) == 0
+	b.mx.RUnlock()
+
+	if !empty {
+		return nil
+	}
+
+	select {
+	case <-resolved:
+		return nil
+	case <-b.stop:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close stops the health checks and watching of resolvers.
+func (b *Balancer) Close() {
+	b.stopOnce.Do(func() { close(b.stop) })
+	b.wg.Wait()
+}
+
+func (b *Balancer) checkAll() {
+	b.mx.RLock()
+	endpoints := make([]*endpoint, len(b.endpoints))
+	copy(endpoints, b.endpoints)
+	b.mx.RUnlock()
+
+	wg := sync.WaitGroup{}
+	for _, e := range endpoints {
+		wg.Add(1)
+		go func(e *endpoint) {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(context.Background(), b.opts.CheckTimeout)
+			defer cancel()
+
+			b.report(e, b.ping(ctx, e.addr))
+		}(e)
+	}
+	wg.Wait()
+}
+
+// report updates health of an endpoint after a health check or a failed call.
+func (b *Balancer) report(e *endpoint, err error) {
+	e.mx.Lock()
+	defer e.mx.Unlock()
+
+	if err != nil {
+		e.successes = 0
+		e.failures++
+		if e.failures >= b.opts.FailThreshold {
+			e.healthy = false
+		}
+		return
+	}
+
+	e.failures = 0
+	if e.healthy {
+		return
+	}
+
+	e.successes++
+	if e.successes >= b.opts.RecoverThreshold {
+		e.healthy = true
+		e.successes = 0
+	}
+}
+
+func (b *Balancer) healthy() []*endpoint {
+	healthy := make([]*endpoint, 0, len(b.endpoints))
+	for _, e := range b.endpoints {
+		if e.isHealthy() {
+			healthy = append(healthy, e)
+		}
+	}
+	return healthy
+}
+
+func (b *Balancer) pickHash(key string) *endpoint {
+	if len(b.ring) == 0 {
+		return nil
+	}
+
+	h := hashString(key)
+	i := sort.Search(len(b.ring), func(i int) bool { return b.ring[i].hash >= h })
+
+	for n := 0; n < len(b.ring); n++ {
+		e := b.ring[(i+n)%len(b.ring)].endpoint
+		if e.isHealthy() {
+			return e
+		}
+	}
+
+	return nil
+}
+
+func (e *endpoint) isHealthy() bool {
+	e.mx.Lock()
+	defer e.mx.Unlock()
+	return e.healthy
+}
+
+func hashString(s string) uint32 {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(s))
+	return h.Sum32()
+}
+
+// BalancedDelivery is a Delivery that delivers requests addressed to the default (empty) address
+// to an endpoint picked by the Balancer. Responses and envelopes with other addresses are delivered directly.
+// A call that awaits responses counts as in flight at its endpoint until its responses end,
+// with a response with StatusSuccess or StatusError, or until the caller stops awaiting them.
+type BalancedDelivery struct {
+	Delivery Delivery
+	Balancer *Balancer
+
+	// EndpointError, if not nil, filters errors of deliveries before they are reported to the Balancer.
+	// It should return nil for errors that don't indicate a problem with the endpoint.
+	EndpointError func(err error) error
+}
+
+// Deliver implements Delivery interface.
+func (d *BalancedDelivery) Deliver(ctx context.Context, addr string, e *Envelope) (err error) {
+	if addr != "" || !e.IsRequest() {
+		return d.Delivery.Deliver(ctx, addr, e)
+	}
+
+	if err = d.Balancer.wait(ctx); err != nil {
+		return
+	}
+
+	addr, done, err := d.Balancer.Pick(e.Request())
+	if err != nil {
+		return
+	}
+
+	err = d.Delivery.Deliver(ctx, addr, e)
+
+	endpointErr := err
+	if d.EndpointError != nil {
+		endpointErr = d.EndpointError(err)
+	}
+
+	// a call that awaits responses is in flight until it ends
+
+	if err != nil || e.End == nil {
+		done(endpointErr)
+	} else {
+		e.End.Add(func() { done(nil) })
+	}
+
+	return
+}
+
+// PingDelivery returns a Pinger that delivers liteproto.TypePing requests with the Delivery.
+func PingDelivery(d Delivery) Pinger {
+	return func(ctx context.Context, addr string) error {
+		return d.Deliver(ctx, addr, &Envelope{ID: "ping", Type: liteproto.TypePing})
+	}
+}
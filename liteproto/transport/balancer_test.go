@@ -0,0 +1,192 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/drone/liteproto/liteproto"
+)
+
+// resolverFunc is a liteproto.Resolver implemented by a function.
+type resolverFunc func(ctx context.Context) ([]string, error)
+
+func (f resolverFunc) Resolve(ctx context.Context) ([]string, error) {
+	return f(ctx)
+}
+
+// deliveryFunc is a Delivery implemented by a function.
+type deliveryFunc func(ctx context.Context, addr string, e *Envelope) error
+
+func (f deliveryFunc) Deliver(ctx context.Context, addr string, e *Envelope) error {
+	return f(ctx, addr, e)
+}
+
+func TestBalancerWatch(t *testing.T) {
+	release := make(chan struct{})
+
+	b := NewBalancer(nil, liteproto.BalanceOptions{}, nil)
+	defer b.Close()
+
+	start := time.Now()
+	b.Watch(resolverFunc(func(ctx context.Context) ([]string, error) {
+		<-release
+		return []string{"a"}, nil
+	}), time.Hour, nil)
+
+	if time.Since(start) > 100*time.Millisecond {
+		t.Fatal("Watch waited for the resolver")
+	}
+
+	delivered := make(chan string, 1)
+	d := &BalancedDelivery{
+		Balancer: b,
+		Delivery: deliveryFunc(func(_ context.Context, addr string, _ *Envelope) error {
+			delivered <- addr
+			return nil
+		}),
+	}
+
+	// a delivery waits for the first resolution while there are no endpoints
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := d.Deliver(ctx, "", &Envelope{ID: "1", Type: "t"}); err != context.DeadlineExceeded {
+		t.Fatalf("expected the delivery to wait, got %v", err)
+	}
+
+	close(release)
+
+	if err := d.Deliver(context.Background(), "", &Envelope{ID: "1", Type: "t"}); err != nil {
+		t.Fatal(err)
+	}
+	if addr := <-delivered; addr != "a" {
+		t.Errorf("expected delivery to a, got %q", addr)
+	}
+}
+
+func TestBalancerResolveErr(t *testing.T) {
+	errResolve := errors.New("no records")
+	resolved := make(chan struct{}, 1)
+	fail := int32(1)
+
+	b := NewBalancer([]string{"a"}, liteproto.BalanceOptions{}, nil)
+	defer b.Close()
+
+	b.Watch(resolverFunc(func(ctx context.Context) ([]string, error) {
+		defer func() {
+			select {
+			case resolved <- struct{}{}:
+			default:
+			}
+		}()
+		if _, ok := ctx.Deadline(); !ok {
+			t.Error("resolver called without a deadline")
+		}
+		if atomic.LoadInt32(&fail) == 1 {
+			return nil, errResolve
+		}
+		return []string{"b"}, nil
+	}), 20*time.Millisecond, nil)
+
+	<-resolved
+	waitFor(t, func() bool { return b.ResolveErr() == errResolve })
+
+	if addrs := b.Addresses(); len(addrs) != 1 || addrs[0] != "a" {
+		t.Errorf("failed resolution changed the addresses: %v", addrs)
+	}
+
+	atomic.StoreInt32(&fail, 0)
+
+	waitFor(t, func() bool { return b.ResolveErr() == nil })
+
+	if addrs := b.Addresses(); len(addrs) != 1 || addrs[0] != "b" {
+		t.Errorf("expected resolved addresses, got %v", addrs)
+	}
+}
+
+func TestBalancedDeliveryResponses(t *testing.T) {
+	var addrs []string
+	d := &BalancedDelivery{
+		Balancer: NewBalancer([]string{"a", "b"}, liteproto.BalanceOptions{}, nil),
+		Delivery: deliveryFunc(func(_ context.Context, addr string, _ *Envelope) error {
+			addrs = append(addrs, addr)
+			return nil
+		}),
+	}
+
+	_ = d.Deliver(context.Background(), "", ResponseEnvelope(liteproto.TaskResponse{ID: "1", Type: "t", Status: liteproto.StatusOK}))
+	_ = d.Deliver(context.Background(), "c", &Envelope{ID: "2", Type: "t"})
+	_ = d.Deliver(context.Background(), "", &Envelope{ID: "3", Type: "t"})
+
+	if len(addrs) != 3 || addrs[0] != "" || addrs[1] != "c" || addrs[2] == "" {
+		t.Errorf("expected only the request to the default address to be balanced, got %q", addrs)
+	}
+}
+
+// TestBalancerLeastInFlight checks that a call counts as in flight until its responses end.
+func TestBalancerLeastInFlight(t *testing.T) {
+	pubsub := &PubSub{}
+	b := NewBalancer([]string{"a", "b"}, liteproto.BalanceOptions{Policy: liteproto.BalanceLeastInFlight}, nil)
+
+	delivered := make(chan string, 10)
+	caller := NewCaller(&BalancedDelivery{
+		Balancer: b,
+		Delivery: deliveryFunc(func(_ context.Context, addr string, _ *Envelope) error {
+			delivered <- addr
+			return nil
+		}),
+	}, "", "")
+	runner := NewRunner(caller, pubsub)
+
+	// the first call awaits responses, so the following calls go to the other endpoint
+
+	response, stop, err := runner.Run(context.Background(), liteproto.TaskRequest{ID: "long", Type: "t"}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer close(stop)
+	busy := <-delivered
+
+	for i := 0; i < 3; i++ {
+		_, otherStop, err := runner.Run(context.Background(), liteproto.TaskRequest{ID: "short", Type: "t"}, time.Time{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if addr := <-delivered; addr == busy {
+			t.Fatalf("call %d went to the busy endpoint %s", i, addr)
+		}
+		close(otherStop)
+		waitFor(t, func() bool {
+			return pubsub.Publish(context.Background(), liteproto.TaskResponse{ID: "short"}) == liteproto.ErrNotSubscribed
+		})
+	}
+
+	// intermediate responses don't end the call, a final one does
+
+	_ = pubsub.Publish(context.Background(), liteproto.TaskResponse{ID: "long", Status: liteproto.StatusOK})
+	<-response
+
+	_, otherStop, _ := runner.Run(context.Background(), liteproto.TaskRequest{ID: "short", Type: "t"}, time.Time{})
+	if addr := <-delivered; addr == busy {
+		t.Fatal("call went to the busy endpoint after an intermediate response")
+	}
+	close(otherStop)
+	waitFor(t, func() bool {
+		return pubsub.Publish(context.Background(), liteproto.TaskResponse{ID: "short"}) == liteproto.ErrNotSubscribed
+	})
+
+	_ = pubsub.Publish(context.Background(), liteproto.TaskResponse{ID: "long", Status: liteproto.StatusSuccess})
+	<-response
+
+	counts := map[string]int{}
+	for i := 0; i < 4; i++ {
+		_ = caller.Call(context.Background(), &Envelope{ID: "call", Type: "t"})
+		counts[<-delivered]++
+	}
+	if counts["a"] != 2 || counts["b"] != 2 {
+		t.Errorf("expected the calls spread evenly after the call ended, got %v", counts)
+	}
+}
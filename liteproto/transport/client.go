@@ -0,0 +1,130 @@
+package transport
+
+import (
+	"context"
+	"time"
+
+	"github.com/drone/liteproto/liteproto"
+)
+
+// NewCaller creates a Caller that delivers requests to the provided address.
+// Parameter replyTo is advertised in every request, it can be empty.
+func NewCaller(delivery Delivery, addr, replyTo string) Caller {
+	return &caller{
+		delivery: delivery,
+		addr:     addr,
+		replyTo:  replyTo,
+	}
+}
+
+type caller struct {
+	delivery Delivery
+	addr     string
+	replyTo  string
+	encoding string // set for the calls made by an execer, see Envelope.Encoding
+}
+
+func (c *caller) Call(ctx context.Context, e *Envelope) error {
+	if e.ReplyTo == "" {
+		e.ReplyTo = c.replyTo
+	}
+	if e.Encoding == "" {
+		e.Encoding = c.encoding
+	}
+
+	return c.delivery.Deliver(ctx, c.addr, e)
+}
+
+// client is a liteproto.Client that sends calls with a Caller.
+// Responses to the calls are received by the ResponseSub.
+type client struct {
+	caller Caller
+	runner *Runner
+}
+
+func newClient(c Caller, respSub ResponseSub) *client {
+	return &client{
+		caller: c,
+		runner: NewRunner(c, respSub),
+	}
+}
+
+func (c *client) Call(ctx context.Context, r liteproto.TaskRequest) (err error) {
+	return c.caller.Call(ctx, RequestEnvelope(r, time.Time{}, ""))
+}
+
+func (c *client) CallWithResponse(ctx context.Context, r liteproto.TaskRequest) (response <-chan liteproto.TaskResponse, stop chan<- struct{}, err error) {
+	return c.runner.Run(ctx, r, time.Time{})
+}
+
+func (c *client) CallWithDeadline(ctx context.Context, r liteproto.TaskRequest, deadline time.Time) (response <-chan liteproto.TaskResponse, stop chan<- struct{}, err error) {
+	return c.runner.Run(ctx, r, deadline)
+}
+
+// responderFactory makes responders that deliver responses to the reply-to address
+// with the ServerClient's Delivery.
+type responderFactory struct {
+	sc *ServerClient
+}
+
+// Client returns a client for calling back the caller at the reply-to address of the request.
+// If it's empty the calls are sent to the default address. The calls have the encoding of the request.
+func (f responderFactory) Client(request *Envelope) liteproto.Client {
+	if request.ReplyTo == "" && request.Encoding == "" {
+		return f.sc
+	}
+
+	addr := request.ReplyTo
+	if addr == "" {
+		addr = f.sc.addr
+	}
+
+	return newClient(&caller{
+		delivery: f.sc.delivery,
+		addr:     addr,
+		replyTo:  f.sc.replyTo,
+		encoding: request.Encoding,
+	}, f.sc.pubsub)
+}
+
+func (f responderFactory) MakeResponder(request *Envelope) liteproto.ResponderClient {
+	return &responder{
+		Client:   f.Client(request),
+		delivery: f.sc.delivery,
+		addr:     request.ReplyTo,
+		encoding: request.Encoding,
+		id:       request.ID,
+		defType:  request.Type,
+	}
+}
+
+type responder struct {
+	liteproto.Client
+	delivery Delivery
+	addr     string
+	encoding string
+	id       string
+	defType  string
+}
+
+func (r *responder) Respond(ctx context.Context, status string, data []byte) (err error) {
+	return r.RespondWithType(ctx, r.defType, status, data)
+}
+
+func (r *responder) RespondWithType(ctx context.Context, responseType, status string, data []byte) (err error) {
+	return r.RespondEnvelope(ctx, &Envelope{Type: responseType, Status: status, Data: data})
+}
+
+func (r *responder) RespondEnvelope(ctx context.Context, e *Envelope) (err error) {
+	if e.Status == "" {
+		panic("status can't be empty")
+	}
+
+	e.ID = r.id
+	if e.Type == "" {
+		e.Type = r.defType
+	}
+	e.Encoding = r.encoding
+
+	return r.delivery.Deliver(ctx, r.addr, e)
+}
@@ -0,0 +1,92 @@
+package transport
+
+import (
+	"context"
+
+	"github.com/drone/liteproto/liteproto"
+)
+
+// Relay forwards a response to another member of a cluster of replicas.
+// It should return liteproto.ErrNotSubscribed if the member doesn't await the response.
+type Relay interface {
+	Relay(ctx context.Context, member string, response liteproto.TaskResponse) error
+}
+
+// ClusterPubSub is an implementation of publisher/subscriber interface for a cluster of replicas
+// with static membership. Subscriptions are stored in a local PubSub. A response that nobody awaits
+// locally is forwarded with a Relay to the member that owns the subscription. The owner is taken
+// from the Owner field of the received envelope (see PublishEnvelope). Without the owner hint
+// the response is offered to all members.
+type ClusterPubSub struct {
+	local   *PubSub
+	self    string
+	members []string
+	relay   Relay
+}
+
+// NewClusterPubSub creates a new ClusterPubSub. Parameter self is the name of this member,
+// parameter members holds names of all members, it may or may not include self.
+func NewClusterPubSub(local *PubSub, self string, members []string, relay Relay) *ClusterPubSub {
+	others := make([]string, 0, len(members))
+	for _, member := range members {
+		if member != self {
+			others = append(others, member)
+		}
+	}
+
+	return &ClusterPubSub{
+		local:   local,
+		self:    self,
+		members: others,
+		relay:   relay,
+	}
+}
+
+func (q *ClusterPubSub) Subscribe(id string) (<-chan liteproto.TaskResponse, error) {
+	return q.local.Subscribe(id)
+}
+
+func (q *ClusterPubSub) Unsubscribe(id string) error {
+	return q.local.Unsubscribe(id)
+}
+
+func (q *ClusterPubSub) Publish(ctx context.Context, response liteproto.TaskResponse) error {
+	return q.PublishEnvelope(ctx, ResponseEnvelope(response))
+}
+
+// PublishEnvelope publishes a received response. It uses the Owner and Relayed fields of the envelope.
+// This method implements EnvelopePub interface.
+func (q *ClusterPubSub) PublishEnvelope(ctx context.Context, e *Envelope) error {
+	response := e.Response()
+
+	err := q.local.Publish(ctx, response)
+	if err != liteproto.ErrNotSubscribed {
+		return err
+	}
+
+	// relayed responses are never relayed again, to avoid loops
+	if e.Relayed {
+		return liteproto.ErrNotSubscribed
+	}
+
+	owner := e.Owner
+	if owner == q.self {
+		return liteproto.ErrNotSubscribed
+	}
+
+	if owner != "" {
+		for _, member := range q.members {
+			if member == owner {
+				return q.relay.Relay(ctx, member, response)
+			}
+		}
+	}
+
+	for _, member := range q.members {
+		if q.relay.Relay(ctx, member, response) == nil {
+			return nil
+		}
+	}
+
+	return liteproto.ErrNotSubscribed
+}
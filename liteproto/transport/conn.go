@@ -0,0 +1,232 @@
+package transport
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/drone/liteproto/liteproto"
+)
+
+// DefaultResumeTimeout is the default of ConnOptions.ResumeTimeout.
+const DefaultResumeTimeout = 30 * time.Second
+
+const (
+	minReconnectDelay = 100 * time.Millisecond
+	maxReconnectDelay = 10 * time.Second
+)
+
+// ConnOptions are the settings shared by transports whose clients keep connections open to a server,
+// over which requests and responses flow in both directions.
+type ConnOptions struct {
+	// ResumeTimeout is how long messages to a disconnected peer wait for the peer to reconnect.
+	// Zero value means DefaultResumeTimeout.
+	ResumeTimeout time.Duration
+
+	// ClientID is the ID with which a client identifies itself to the server. It must be unique
+	// among clients of the server. If empty, a random ID is generated.
+	ClientID string
+
+	// ResultStore, if not nil, keeps all responses sent by the execers of the server side,
+	// so callers can fetch the responses they missed with Resubscribe.
+	ResultStore liteproto.ResultStore
+
+	// OrphanHandler, if not nil, is called with every received response that nobody awaits.
+	OrphanHandler func(response liteproto.TaskResponse)
+}
+
+func (o ConnOptions) resumeTimeout() time.Duration {
+	if o.ResumeTimeout <= 0 {
+		return DefaultResumeTimeout
+	}
+	return o.ResumeTimeout
+}
+
+// ConnServer is the server side of a transport whose clients keep connections open to it.
+// The transport accepts the connections and adds their sessions to Sessions under the client IDs.
+//
+// ConnServer implements liteproto.ServerClient. Responses are sent back over a connection of the client
+// that made the call. Calls to a client can be made with the liteproto.Client returned by Client method,
+// calls made directly with the ConnServer fail with liteproto.ErrUnknownPeer.
+type ConnServer struct {
+	*ServerClient
+
+	sessions *Sessions
+}
+
+// NewConnServer creates a new ConnServer. Parameter 'logger' can be nil.
+func NewConnServer(opts ConnOptions, logger *log.Logger) *ConnServer {
+	s := &ConnServer{sessions: NewSessions(opts.resumeTimeout())}
+
+	s.ServerClient = NewServerClient(Config{
+		Delivery:      serverDelivery{s.sessions},
+		ResultStore:   opts.ResultStore,
+		OrphanHandler: opts.OrphanHandler,
+		Logger:        logger,
+	})
+
+	return s
+}
+
+// Sessions returns the sessions of the connected clients.
+func (s *ConnServer) Sessions() *Sessions {
+	return s.sessions
+}
+
+// Client returns a client for calling the connected client with the provided ID.
+func (s *ConnServer) Client(clientID string) liteproto.Client {
+	return s.ClientFor(serverDelivery{s.sessions}, clientID)
+}
+
+// Close closes connections of all clients. Connections accepted afterwards are closed right away.
+func (s *ConnServer) Close() error {
+	s.sessions.CloseAll()
+	return nil
+}
+
+// serverDelivery sends envelopes to clients. The address is the client ID.
+type serverDelivery struct {
+	sessions *Sessions
+}
+
+func (d serverDelivery) Deliver(ctx context.Context, addr string, e *Envelope) error {
+	if addr == "" {
+		return liteproto.ErrUnknownPeer
+	}
+
+	return d.sessions.Deliver(ctx, addr, e)
+}
+
+// ConnClient is the client side of a transport whose clients keep connections open to a server.
+// Connections are opened with Keep and reopened when they break.
+//
+// ConnClient implements liteproto.ServerClient. Calls awaiting responses survive reconnections:
+// the server holds responses for a disconnected client until it reconnects or the resume timeout expires.
+type ConnClient struct {
+	*ServerClient
+
+	id       string
+	sessions *Sessions
+	logger   *log.Logger
+
+	ctx    context.Context
+	cancel func()
+	wg     sync.WaitGroup
+}
+
+// NewConnClient creates a new ConnClient. Parameter 'logger' can be nil.
+func NewConnClient(opts ConnOptions, logger *log.Logger) *ConnClient {
+	if logger == nil {
+		logger = log.Default()
+	}
+
+	id := opts.ClientID
+	if id == "" {
+		id = RandomID()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	c := &ConnClient{
+		id:       id,
+		sessions: NewSessions(opts.resumeTimeout()),
+		logger:   logger,
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+
+	c.ServerClient = NewServerClient(Config{
+		Delivery:      clientDelivery{c.sessions},
+		ReplyTo:       id,
+		ResultStore:   opts.ResultStore,
+		OrphanHandler: opts.OrphanHandler,
+		Logger:        logger,
+	})
+
+	return c
+}
+
+// ID returns the ID with which the client identifies itself to the server.
+func (c *ConnClient) ID() string {
+	return c.id
+}
+
+// Keep keeps a connection open in the background. Function connect opens the connection,
+// it's called again after a delay when the connection fails or breaks. If replace is true,
+// the session of a new connection replaces the sessions of the previous ones, otherwise it's added
+// to them, like to a pool of connections. Parameter 'name' describes the server in log messages.
+func (c *ConnClient) Keep(name string, connect func(ctx context.Context) (*Session, error), replace bool) {
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		c.keep(name, connect, replace)
+	}()
+}
+
+func (c *ConnClient) keep(name string, connect func(ctx context.Context) (*Session, error), replace bool) {
+	delay := minReconnectDelay
+
+	for {
+		session, err := connect(c.ctx)
+		if err == nil {
+			delay = minReconnectDelay
+
+			add := c.sessions.Add
+			if replace {
+				add = c.sessions.Replace
+			}
+
+			// the client can be closed while connecting, then the session isn't added
+			if add("", session) {
+				err = session.Run()
+				c.sessions.Remove("", session)
+			}
+		}
+
+		if c.ctx.Err() != nil {
+			return
+		}
+
+		if err != nil {
+			c.logger.Printf("connection to %s failed: %s", name, err.Error())
+		}
+
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+
+		delay *= 2
+		if delay > maxReconnectDelay {
+			delay = maxReconnectDelay
+		}
+	}
+}
+
+// Close closes the connections and stops reconnecting.
+func (c *ConnClient) Close() error {
+	c.cancel()
+	c.sessions.CloseAll()
+	c.wg.Wait()
+	return nil
+}
+
+// clientDelivery sends all envelopes to the server over the client's connections.
+type clientDelivery struct {
+	sessions *Sessions
+}
+
+func (d clientDelivery) Deliver(ctx context.Context, _ string, e *Envelope) error {
+	return d.sessions.Deliver(ctx, "", e)
+}
+
+// RandomID returns a random 128-bit ID encoded in hex.
+func RandomID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,98 @@
+package transport
+
+import (
+	"io"
+	"time"
+
+	"github.com/drone/liteproto/liteproto"
+)
+
+// Envelope is a message exchanged by ServerClients: either a task request or a task response.
+type Envelope struct {
+	// ID is the ID of the task.
+	ID string `json:"id"`
+
+	// Type is the type of the task.
+	Type string `json:"type"`
+
+	// Status is the status of a response. It's empty for requests.
+	Status string `json:"status,omitempty"`
+
+	// Data holds arbitrary byte data payload.
+	Data []byte `json:"data,omitempty"`
+
+	// Deadline is the deadline of a request. Zero value means no deadline.
+	Deadline time.Time `json:"deadline,omitempty"`
+
+	// ReplyTo is the address to which responses to a request should be sent. Empty means the default address.
+	ReplyTo string `json:"reply_to,omitempty"`
+
+	// Metadata holds the metadata of a request.
+	Metadata map[string]string `json:"metadata,omitempty"`
+
+	// The fields below are not sent to remote servers. They pass details between a ServerClient
+	// and its transport, transports that don't use them ignore them.
+
+	// End is set by a Runner on the requests of calls that await responses. A Delivery can use it
+	// to run functions when the call ends.
+	End *CallEnd `json:"-"`
+
+	// Finish is set by a transport on a received request. If not nil, ServerClient.Receive calls it
+	// when the execer of the request returns, or right away for reserved task types.
+	// It isn't called if Receive returns an error.
+	Finish func() `json:"-"`
+
+	// Encoding is set by a transport on a received request to the encoding the caller used.
+	// The responses to the request, and the calls made by its execer, are delivered with the same
+	// Encoding. Its format is defined by the transport, empty means the default encoding.
+	Encoding string `json:"-"`
+
+	// Payload, if not nil, is sent by the transport in place of Data, without holding it in memory.
+	// Transports that don't support streaming ignore it.
+	Payload io.Reader `json:"-"`
+
+	// Owner is set by a transport on a received response to the name of the cluster member that owns
+	// the subscription for the response, see ClusterPubSub.
+	Owner string `json:"-"`
+
+	// Relayed is set by a transport on a received response relayed by another cluster member,
+	// see ClusterPubSub.
+	Relayed bool `json:"-"`
+}
+
+// IsRequest reports whether the envelope holds a task request.
+func (e *Envelope) IsRequest() bool {
+	return e.Status == ""
+}
+
+// Request returns the task request held by the envelope.
+func (e *Envelope) Request() liteproto.TaskRequest {
+	return liteproto.TaskRequest{ID: e.ID, Type: e.Type, Data: e.Data, Metadata: e.Metadata}
+}
+
+// Response returns the task response held by the envelope.
+func (e *Envelope) Response() liteproto.TaskResponse {
+	return liteproto.TaskResponse{ID: e.ID, Type: e.Type, Status: e.Status, Data: e.Data}
+}
+
+// RequestEnvelope creates an envelope for a task request.
+func RequestEnvelope(r liteproto.TaskRequest, deadline time.Time, replyTo string) *Envelope {
+	return &Envelope{
+		ID:       r.ID,
+		Type:     r.Type,
+		Data:     r.Data,
+		Deadline: deadline,
+		ReplyTo:  replyTo,
+		Metadata: r.Metadata,
+	}
+}
+
+// ResponseEnvelope creates an envelope for a task response.
+func ResponseEnvelope(r liteproto.TaskResponse) *Envelope {
+	return &Envelope{
+		ID:     r.ID,
+		Type:   r.Type,
+		Status: r.Status,
+		Data:   r.Data,
+	}
+}
@@ -0,0 +1,285 @@
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/drone/liteproto/liteproto"
+)
+
+// ServerFeeder is a helper object that handles requests for task execution
+// and relays them to one of the registered task executors.
+type ServerFeeder struct {
+	// execers can be registered while requests are fed, for example by liteprotostdio
+	// when a restarted plugin advertises new task types
+	execerMap     map[string]interface{}
+	execerDefault liteproto.ExecerWithResponder
+	execerLock    sync.RWMutex
+
+	schemas          map[string]liteproto.TypeSchemas
+	responseSchemas  bool
+	responderFactory ResponderFactory
+	resultStore      liteproto.ResultStore
+	resultLocks      keyedMutex
+	logger           *log.Logger
+}
+
+// NewServerFeeder creates new ServerFeeder objects. Parameter store can be nil.
+// If it's not, all responses sent by the registered execers are kept in it
+// and can be replayed to callers with TypeReplay requests.
+func NewServerFeeder(factory ResponderFactory, store liteproto.ResultStore, logger *log.Logger) (sf *ServerFeeder) {
+	return &ServerFeeder{
+		execerMap:        map[string]interface{}{},
+		schemas:          map[string]liteproto.TypeSchemas{},
+		responderFactory: factory,
+		resultStore:      store,
+		logger:           logger,
+	}
+}
+
+// Register assigns an liteproto.Execer to run tasks of a given type.
+// This method is a part of liteproto.Server interface implementation.
+func (sf *ServerFeeder) Register(typ string, execer liteproto.Execer) {
+	sf.execerLock.Lock()
+	sf.execerMap[typ] = execer
+	sf.execerLock.Unlock()
+}
+
+// RegisterWithResponder assigns an liteproto.ExecerWithResponder to run tasks of a given type.
+// This method is a part of liteproto.Server interface implementation.
+func (sf *ServerFeeder) RegisterWithResponder(typ string, execer liteproto.ExecerWithResponder) {
+	sf.execerLock.Lock()
+	sf.execerMap[typ] = execer
+	sf.execerLock.Unlock()
+}
+
+// RegisterCatchAll assigns an liteproto.ExecerWithResponder to run all tasks
+// that are not already assigned to some other Execer.
+// This method is a part of liteproto.Server interface implementation.
+func (sf *ServerFeeder) RegisterCatchAll(execer liteproto.ExecerWithResponder) {
+	sf.execerLock.Lock()
+	sf.execerDefault = execer
+	sf.execerLock.Unlock()
+}
+
+// RegisterSchema attaches schemas to a task type. Requests with invalid payload are rejected by Feed
+// with a *liteproto.ValidationError, responses with invalid payload are not sent.
+// This method implements liteproto.SchemaServer interface.
+func (sf *ServerFeeder) RegisterSchema(typ string, schemas liteproto.TypeSchemas) {
+	sf.schemas[typ] = schemas
+	if schemas.Response != nil {
+		sf.responseSchemas = true
+	}
+}
+
+// Feed accepts requests for task execution. Parameter deadline should be zero time if it's not needed.
+// Parameter replyTo is passed to the ResponderFactory.
+// This method implements Feeder interface.
+func (sf *ServerFeeder) Feed(ctx context.Context, r liteproto.TaskRequest, deadline time.Time, replyTo string) error {
+	return sf.FeedEnvelope(ctx, RequestEnvelope(r, deadline, replyTo))
+}
+
+// FeedEnvelope is like Feed, but it takes a received request envelope. Its Finish function, if not nil,
+// is called when the execer returns, or right away for reserved task types; it's not called if FeedEnvelope
+// returns an error. The responses and calls made by the execer get the Encoding of the envelope.
+func (sf *ServerFeeder) FeedEnvelope(ctx context.Context, request *Envelope) error {
+	r, deadline := request.Request(), request.Deadline
+
+	finish := request.Finish
+	if finish == nil {
+		finish = func() {}
+	}
+
+	if r.Type == liteproto.TypePing {
+		finish()
+		return nil
+	}
+
+	if r.Type == liteproto.TypeReplay && sf.resultStore != nil {
+		return finished(sf.replay(ctx, request), finish)
+	}
+
+	if r.Type == liteproto.TypeSchema {
+		return finished(sf.describe(ctx, request), finish)
+	}
+
+	sf.execerLock.RLock()
+	execer, ok := sf.execerMap[r.Type]
+	if !ok && sf.execerDefault != nil {
+		execer, ok = sf.execerDefault, true
+	}
+	sf.execerLock.RUnlock()
+
+	if !ok {
+		return liteproto.ErrUnknownType
+	}
+
+	if schema := sf.schemas[r.Type].Request; schema != nil {
+		if err := schema.Validate(r.Data); err != nil {
+			err.(*liteproto.ValidationError).Type = r.Type
+			return err
+		}
+	}
+
+	var (
+		ctxJob     context.Context
+		cancelFunc func()
+	)
+
+	if !deadline.IsZero() {
+		now := time.Now()
+		if deadline.Before(now) {
+			return context.DeadlineExceeded
+		}
+
+		ctxJob, cancelFunc = context.WithDeadline(ctx, deadline)
+	} else {
+		ctxJob = ctx
+		cancelFunc = func() {}
+	}
+
+	switch execer := execer.(type) {
+	case liteproto.Execer:
+		go func(ctx context.Context, r *liteproto.TaskRequest) {
+			defer finish()
+			defer sf.panicRecovery(cancelFunc)
+
+			execer.Exec(ctx, *r, sf.responderFactory.Client(request))
+		}(ctxJob, &r)
+
+	case liteproto.ExecerWithResponder:
+		go func(ctx context.Context, r *liteproto.TaskRequest) {
+			defer finish()
+			defer sf.panicRecovery(cancelFunc)
+
+			execer.Exec(ctx, *r, sf.makeResponder(request))
+		}(ctxJob, &r)
+	default:
+		cancelFunc()
+		finish()
+	}
+
+	return nil
+}
+
+// finished calls the finish function if err is nil.
+func finished(err error, finish func()) error {
+	if err == nil {
+		finish()
+	}
+	return err
+}
+
+func (sf *ServerFeeder) makeResponder(request *Envelope) liteproto.ResponderClient {
+	responder := sf.responderFactory.MakeResponder(request)
+	if sf.resultStore != nil {
+		responder = &recordingResponder{
+			ResponderClient: responder,
+			sf:              sf,
+			id:              request.ID,
+			defType:         request.Type,
+		}
+	}
+
+	if sf.responseSchemas {
+		responder = &validatingResponder{
+			ResponderClient: responder,
+			sf:              sf,
+			defType:         request.Type,
+		}
+	}
+
+	return responder
+}
+
+// describe sends the schemas of the requested task types.
+func (sf *ServerFeeder) describe(ctx context.Context, request *Envelope) error {
+	r := request.Request()
+
+	var schemaRequest liteproto.SchemaRequest
+	if len(r.Data) > 0 {
+		if err := json.Unmarshal(r.Data, &schemaRequest); err != nil {
+			return err
+		}
+	}
+
+	schemas := map[string]liteproto.TypeSchemas{}
+	if len(schemaRequest.Types) == 0 {
+		for typ, s := range sf.schemas {
+			schemas[typ] = s
+		}
+	} else {
+		for _, typ := range schemaRequest.Types {
+			if s, ok := sf.schemas[typ]; ok {
+				schemas[typ] = s
+			}
+		}
+	}
+
+	data, err := json.Marshal(schemas)
+	if err != nil {
+		return err
+	}
+
+	go func(ctx context.Context) {
+		defer sf.panicRecovery(func() {})
+
+		responder := sf.responderFactory.MakeResponder(request)
+		if err := responder.Respond(ctx, liteproto.StatusOK, data); err != nil && sf.logger != nil {
+			sf.logger.Printf("failed to send schemas for ID=%s: %s", r.ID, err.Error())
+		}
+	}(ctx)
+
+	return nil
+}
+
+// replay sends again the stored responses of a task. The responses are sent asynchronously,
+// but while holding the task's lock, so that the responses that the task sends in the meantime
+// follow the replayed ones.
+func (sf *ServerFeeder) replay(ctx context.Context, request *Envelope) error {
+	r := request.Request()
+
+	var replayRequest liteproto.ReplayRequest
+	if len(r.Data) > 0 {
+		if err := json.Unmarshal(r.Data, &replayRequest); err != nil {
+			return err
+		}
+	}
+
+	unlock := sf.resultLocks.lock(r.ID)
+
+	responses, err := sf.resultStore.Load(r.ID, replayRequest.From)
+	if err != nil {
+		unlock()
+		return err
+	}
+
+	go func(ctx context.Context) {
+		defer sf.panicRecovery(unlock)
+
+		responder := sf.responderFactory.MakeResponder(request)
+		for _, response := range responses {
+			err := responder.RespondWithType(ctx, response.Type, response.Status, response.Data)
+			if err != nil {
+				if sf.logger != nil {
+					sf.logger.Printf("failed to replay response for ID=%s: %s", r.ID, err.Error())
+				}
+				return
+			}
+		}
+	}(ctx)
+
+	return nil
+}
+
+func (sf *ServerFeeder) panicRecovery(cancel func()) {
+	if r := recover(); r != nil && sf.logger != nil {
+		sf.logger.Printf("PANIC: %v\n%s", r, debug.Stack())
+	}
+
+	cancel()
+}
@@ -0,0 +1,77 @@
+// Package transport is the service provider interface for liteproto transports.
+//
+// It contains the building blocks of a liteproto.ServerClient: a Runner that makes calls and awaits
+// responses, a ServerFeeder that relays requests to registered execers, response publishers/subscribers,
+// responders and a Balancer. ServerClient assembles them into a complete liteproto.ServerClient.
+//
+// A transport only needs to supply framing and delivery: an implementation of Delivery interface
+// that sends Envelope objects to remote addresses, and a receiving side that decodes envelopes
+// and passes them to ServerClient.Receive. Package liteprotohttp is the reference implementation.
+package transport
+
+import (
+	"context"
+	"time"
+
+	"github.com/drone/liteproto/liteproto"
+)
+
+// Delivery sends envelopes to remote addresses. The format of addresses is defined by the transport.
+// An empty address means the default remote address of the transport.
+type Delivery interface {
+	Deliver(ctx context.Context, addr string, e *Envelope) error
+}
+
+// Caller makes a call to a remote server to execute a task. It is used by a Runner to make the call.
+// This enables abstraction of remote server calls.
+type Caller interface {
+	Call(ctx context.Context, request *Envelope) (err error)
+}
+
+// Feeder accepts new task execution requests. Deadline parameter
+// should be zero time if it's not needed (equal to time.Time{}).
+// Parameter replyTo is the address to which the responses should be sent,
+// an empty string means the default address of the transport.
+type Feeder interface {
+	Feed(ctx context.Context, request liteproto.TaskRequest, deadline time.Time, replyTo string) error
+}
+
+// ResponsePub is publisher part of response publisher/subscriber interface.
+// The context can limit how long Publish waits for a slow subscriber.
+// Publish returns liteproto.ErrNotSubscribed if nobody awaits responses with the ID.
+type ResponsePub interface {
+	Publish(ctx context.Context, response liteproto.TaskResponse) (err error)
+}
+
+// ResponseSub is subscriber part of response publisher/subscriber interface.
+type ResponseSub interface {
+	Subscribe(id string) (responseData <-chan liteproto.TaskResponse, err error)
+	Unsubscribe(id string) (err error)
+}
+
+// EnvelopePub is implemented by publishers that use the fields of received response envelopes
+// which TaskResponse doesn't have, like ClusterPubSub. ServerClient.Receive uses it instead of Publish.
+type EnvelopePub interface {
+	PublishEnvelope(ctx context.Context, response *Envelope) (err error)
+}
+
+// ResponsePubSub is response publisher/subscriber interface.
+type ResponsePubSub interface {
+	ResponsePub
+	ResponseSub
+}
+
+// ResponderFactory is a generator of ResponderClient objects.
+// Parameter request is the received request. Its ReplyTo field is the address of the caller,
+// an empty string means the default address of the transport.
+type ResponderFactory interface {
+	Client(request *Envelope) liteproto.Client
+	MakeResponder(request *Envelope) liteproto.ResponderClient
+}
+
+// EnvelopeResponder is implemented by the ResponderClients that a ServerClient passes to execers.
+// RespondEnvelope sends a response envelope built by the transport, which can set fields that
+// TaskResponse doesn't have, like Payload. The responder sets the ID, an empty Type means the type of the request.
+type EnvelopeResponder interface {
+	RespondEnvelope(ctx context.Context, response *Envelope) (err error)
+}
@@ -0,0 +1,187 @@
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/drone/liteproto/liteproto"
+)
+
+// DefaultBufferSize is the number of responses buffered per subscription if PubSub.BufferSize is not set.
+const DefaultBufferSize = 10
+
+// PubSub is a simple implementation of publisher/subscriber interface
+// that stores all subscribers into a in-memory map.
+// It doesn't support horizontal scaling of servers.
+//
+// The mutex guards only the map of subscribers. Responses are delivered
+// outside of it, so a slow subscriber blocks only publishing to itself.
+type PubSub struct {
+	// BufferSize is the number of responses that each subscription can buffer.
+	BufferSize int
+
+	// Overflow is the policy applied to a response when the subscription's buffer is full.
+	Overflow liteproto.OverflowPolicy
+
+	// BlockTimeout is the longest time Publish waits with OverflowBlock policy.
+	// Zero value means that it waits until there is room, the subscription is removed or the context is done.
+	BlockTimeout time.Duration
+
+	subscribers map[string]*subscription
+	dropped     uint64
+	sync.Mutex
+}
+
+type subscription struct {
+	ch       chan liteproto.TaskResponse
+	done     chan struct{} // closed when the subscription is removed
+	doneOnce sync.Once
+	failed   bool
+	mx       sync.Mutex // serializes publishing to the subscription
+}
+
+func (q *PubSub) Subscribe(id string) (responseCh <-chan liteproto.TaskResponse, err error) {
+	q.Lock()
+	defer q.Unlock()
+
+	if q.subscribers == nil {
+		q.subscribers = make(map[string]*subscription)
+	}
+
+	_, ok := q.subscribers[id]
+	if ok {
+		err = liteproto.ErrAlreadySubscribed
+		return
+	}
+
+	size := q.BufferSize
+	if size <= 0 {
+		size = DefaultBufferSize
+	}
+
+	sub := &subscription{
+		ch:   make(chan liteproto.TaskResponse, size),
+		done: make(chan struct{}),
+	}
+	q.subscribers[id] = sub
+
+	responseCh = sub.ch
+
+	return
+}
+
+func (q *PubSub) Unsubscribe(id string) (err error) {
+	q.Lock()
+	defer q.Unlock()
+
+	sub, ok := q.subscribers[id]
+	if !ok {
+		err = liteproto.ErrNotSubscribed
+		return
+	}
+
+	delete(q.subscribers, id)
+	sub.doneOnce.Do(func() { close(sub.done) })
+
+	return
+}
+
+func (q *PubSub) Publish(ctx context.Context, response liteproto.TaskResponse) (err error) {
+	q.Lock()
+	sub, ok := q.subscribers[response.ID]
+	q.Unlock()
+
+	if !ok {
+		err = liteproto.ErrNotSubscribed
+		return
+	}
+
+	sub.mx.Lock()
+	defer sub.mx.Unlock()
+
+	// the subscription could be removed while waiting for the mutex, nobody reads the buffer anymore
+	select {
+	case <-sub.done:
+		err = liteproto.ErrNotSubscribed
+		return
+	default:
+	}
+
+	if sub.failed {
+		q.drop(1)
+		return
+	}
+
+	// fast path, there is room in the buffer
+	select {
+	case sub.ch <- response:
+		return
+	default:
+	}
+
+	switch q.Overflow {
+	case liteproto.OverflowDropNewest:
+		q.drop(1)
+
+	case liteproto.OverflowDropOldest:
+		for {
+			select {
+			case sub.ch <- response:
+				return
+			default:
+			}
+
+			select {
+			case <-sub.ch:
+				q.drop(1)
+			default:
+			}
+		}
+
+	case liteproto.OverflowFail:
+		// make room for the error response, the subscriber can only take responses out of the buffer
+		select {
+		case <-sub.ch:
+			q.drop(1)
+		default:
+		}
+
+		data, _ := json.Marshal(liteproto.ErrResponseOverflow.Error())
+		sub.ch <- liteproto.TaskResponse{ID: response.ID, Type: response.Type, Status: liteproto.StatusError, Data: data}
+		sub.failed = true
+		close(sub.ch)
+		q.drop(1)
+
+	default:
+		var timeout <-chan time.Time
+		if q.BlockTimeout > 0 {
+			timer := time.NewTimer(q.BlockTimeout)
+			defer timer.Stop()
+			timeout = timer.C
+		}
+
+		select {
+		case sub.ch <- response:
+		case <-sub.done:
+			q.drop(1)
+		case <-ctx.Done():
+			q.drop(1)
+		case <-timeout:
+			q.drop(1)
+		}
+	}
+
+	return
+}
+
+// Dropped returns the number of responses dropped because their subscribers didn't keep up.
+func (q *PubSub) Dropped() uint64 {
+	return atomic.LoadUint64(&q.dropped)
+}
+
+func (q *PubSub) drop(n uint64) {
+	atomic.AddUint64(&q.dropped, n)
+}
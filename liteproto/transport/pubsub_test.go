@@ -0,0 +1,138 @@
+package transport
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/drone/liteproto/liteproto"
+)
+
+func TestPubSubPublishUnsubscribed(t *testing.T) {
+	q := &PubSub{}
+
+	ch, err := q.Subscribe("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// hold the subscription, so that Publish finds it and waits for it while it's removed
+	sub := q.subscribers["1"]
+	sub.mx.Lock()
+
+	published := make(chan error)
+	go func() {
+		published <- q.Publish(context.Background(), liteproto.TaskResponse{ID: "1", Status: liteproto.StatusSuccess})
+	}()
+
+	// give Publish time to get the subscription, it's not observable
+	time.Sleep(10 * time.Millisecond)
+
+	if err = q.Unsubscribe("1"); err != nil {
+		t.Fatal(err)
+	}
+	sub.mx.Unlock()
+
+	if err = <-published; err != liteproto.ErrNotSubscribed {
+		t.Errorf("expected ErrNotSubscribed, got %v", err)
+	}
+
+	if len(ch) != 0 {
+		t.Errorf("expected no responses in the buffer of a removed subscription, got %d", len(ch))
+	}
+}
+
+func TestPubSubOverflow(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   liteproto.OverflowPolicy
+		expected string // the buffered responses; "!" is the error response, "." means the stream was closed
+		dropped  uint64
+	}{
+		{"block with timeout", liteproto.OverflowBlock, "12", 2},
+		{"drop oldest", liteproto.OverflowDropOldest, "34", 2},
+		{"drop newest", liteproto.OverflowDropNewest, "12", 2},
+		{"fail", liteproto.OverflowFail, "2!.", 3},
+	}
+
+	for _, test := range tests {
+		q := &PubSub{BufferSize: 2, Overflow: test.policy, BlockTimeout: 10 * time.Millisecond}
+
+		ch, err := q.Subscribe("1")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// nobody reads the responses until all are published
+		for _, data := range []string{"1", "2", "3", "4"} {
+			if err = q.Publish(context.Background(), liteproto.TaskResponse{ID: "1", Status: liteproto.StatusOK, Data: []byte(data)}); err != nil {
+				t.Errorf("%s: expected the response to be accepted, got %v", test.name, err)
+			}
+		}
+
+		got := ""
+		for done := false; !done; {
+			select {
+			case r, ok := <-ch:
+				switch {
+				case !ok:
+					got += "."
+					done = true
+				case r.Status == liteproto.StatusError:
+					got += "!"
+				default:
+					got += string(r.Data)
+				}
+			default:
+				done = true
+			}
+		}
+
+		if got != test.expected {
+			t.Errorf("%s: expected responses %q, got %q", test.name, test.expected, got)
+		}
+		if n := q.Dropped(); n != test.dropped {
+			t.Errorf("%s: expected %d dropped responses, got %d", test.name, test.dropped, n)
+		}
+	}
+}
+
+// TestPubSubBlock checks that with OverflowBlock policy Publish waits until the subscriber makes room.
+func TestPubSubBlock(t *testing.T) {
+	q := &PubSub{BufferSize: 1}
+
+	ch, err := q.Subscribe("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	published := make(chan error, 2)
+	go func() {
+		for i := 0; i < 2; i++ {
+			published <- q.Publish(context.Background(), liteproto.TaskResponse{ID: "1", Status: liteproto.StatusOK})
+		}
+	}()
+
+	<-published
+	select {
+	case err = <-published:
+		t.Fatalf("expected Publish to wait for room in the buffer, got %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	<-ch
+	if err = <-published; err != nil {
+		t.Errorf("expected the response to be published, got %v", err)
+	}
+
+	// the context ends the wait, the response is dropped
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if err = q.Publish(ctx, liteproto.TaskResponse{ID: "1", Status: liteproto.StatusOK}); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+	if n := q.Dropped(); n != 1 {
+		t.Errorf("expected 1 dropped response, got %d", n)
+	}
+}
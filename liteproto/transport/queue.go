@@ -0,0 +1,237 @@
+package transport
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrUnknownLease is returned by Queue.Ack when the lease doesn't exist, it was already acknowledged or expired.
+var ErrUnknownLease = errors.New("unknown lease")
+
+// ErrQueueClosed is returned by Queue methods after the queue was closed.
+var ErrQueueClosed = errors.New("queue closed")
+
+// Queue is a Delivery that holds envelopes in named queues until remote workers pull them,
+// for workers that can't accept inbound connections. The address of the Delivery is the queue name.
+//
+// A pulled envelope is leased to the worker. The worker acknowledges the lease when it finished
+// processing the envelope, and extends the lease while it's processing it. If the lease is neither
+// acknowledged nor extended within the lease timeout, for example because the worker vanished
+// or the pull response was lost, the envelope is put back to the front of the queue.
+// Envelopes whose deadline expired are dropped.
+type Queue struct {
+	leaseTimeout time.Duration
+
+	queues  map[string][]*Envelope
+	leases  map[string]*lease
+	changed chan struct{} // closed and replaced whenever an envelope is queued
+	closed  bool
+	mx      sync.Mutex
+}
+
+type lease struct {
+	queue    string
+	envelope *Envelope
+	timer    *time.Timer
+}
+
+// Lease is an envelope pulled from a Queue.
+type Lease struct {
+	ID       string
+	Envelope *Envelope
+}
+
+// NewQueue creates a new Queue with the provided lease timeout.
+func NewQueue(leaseTimeout time.Duration) *Queue {
+	return &Queue{
+		leaseTimeout: leaseTimeout,
+		queues:       map[string][]*Envelope{},
+		leases:       map[string]*lease{},
+		changed:      make(chan struct{}),
+	}
+}
+
+// Deliver appends the envelope to the queue named addr.
+func (q *Queue) Deliver(_ context.Context, addr string, e *Envelope) error {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+
+	if q.closed {
+		return ErrQueueClosed
+	}
+
+	q.queues[addr] = append(q.queues[addr], e)
+	q.notify()
+
+	return nil
+}
+
+// Fetch leases up to max envelopes from the named queue. If the queue is empty,
+// it waits until an envelope is queued or the context is done, in which case it returns no leases.
+func (q *Queue) Fetch(ctx context.Context, name string, max int) ([]Lease, error) {
+	if max < 1 {
+		max = 1
+	}
+
+	for {
+		q.mx.Lock()
+
+		if q.closed {
+			q.mx.Unlock()
+			return nil, ErrQueueClosed
+		}
+
+		var leases []Lease
+
+		now := time.Now()
+		queue := q.queues[name]
+		for len(queue) > 0 && len(leases) < max {
+			e := queue[0]
+			queue[0] = nil
+			queue = queue[1:]
+
+			if !e.Deadline.IsZero() && now.After(e.Deadline) {
+				continue
+			}
+
+			leases = append(leases, Lease{ID: q.lease(name, e), Envelope: e})
+		}
+
+		if len(queue) == 0 {
+			delete(q.queues, name)
+		} else {
+			q.queues[name] = queue
+		}
+
+		changed := q.changed
+		q.mx.Unlock()
+
+		if len(leases) > 0 {
+			return leases, nil
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return nil, nil
+		}
+	}
+}
+
+// Ack acknowledges a lease, so its envelope won't be queued again.
+func (q *Queue) Ack(id string) error {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+
+	l, ok := q.leases[id]
+	if !ok {
+		return ErrUnknownLease
+	}
+
+	l.timer.Stop()
+	delete(q.leases, id)
+
+	return nil
+}
+
+// Extend restarts the lease timeout of a lease, so that its envelope isn't queued again while it's processed.
+func (q *Queue) Extend(id string) error {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+
+	l, ok := q.leases[id]
+	if !ok || !l.timer.Stop() {
+		return ErrUnknownLease
+	}
+
+	l.timer.Reset(q.leaseTimeout)
+
+	return nil
+}
+
+// LeaseQueue returns the name of the queue from which the envelope of a lease was fetched.
+// It reports false if the lease doesn't exist.
+func (q *Queue) LeaseQueue(id string) (name string, ok bool) {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+
+	l, ok := q.leases[id]
+	if !ok {
+		return "", false
+	}
+
+	return l.queue, true
+}
+
+// LeaseTimeout returns the lease timeout of the queue.
+func (q *Queue) LeaseTimeout() time.Duration {
+	return q.leaseTimeout
+}
+
+// Len returns the number of envelopes waiting in the named queue, leased envelopes are not counted.
+func (q *Queue) Len(name string) int {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+
+	return len(q.queues[name])
+}
+
+// Close drops all queued envelopes and wakes up waiting Fetch calls.
+func (q *Queue) Close() {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+
+	if q.closed {
+		return
+	}
+
+	q.closed = true
+
+	for id, l := range q.leases {
+		l.timer.Stop()
+		delete(q.leases, id)
+	}
+	q.queues = map[string][]*Envelope{}
+
+	close(q.changed)
+}
+
+// lease must be called with the mutex locked.
+func (q *Queue) lease(name string, e *Envelope) string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	id := hex.EncodeToString(b)
+
+	q.leases[id] = &lease{
+		queue:    name,
+		envelope: e,
+		timer:    time.AfterFunc(q.leaseTimeout, func() { q.expire(id) }),
+	}
+
+	return id
+}
+
+func (q *Queue) expire(id string) {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+
+	l, ok := q.leases[id]
+	if !ok {
+		return
+	}
+
+	delete(q.leases, id)
+
+	q.queues[l.queue] = append([]*Envelope{l.envelope}, q.queues[l.queue]...)
+	q.notify()
+}
+
+// notify must be called with the mutex locked.
+func (q *Queue) notify() {
+	close(q.changed)
+	q.changed = make(chan struct{})
+}
@@ -0,0 +1,127 @@
+package transport
+
+import (
+	"context"
+	"sync"
+
+	"github.com/drone/liteproto/liteproto"
+)
+
+// recordingResponder is a liteproto.ResponderClient that stores every response
+// into the ServerFeeder's result store before sending it.
+type recordingResponder struct {
+	liteproto.ResponderClient
+	sf      *ServerFeeder
+	id      string
+	defType string
+}
+
+func (r *recordingResponder) Respond(ctx context.Context, status string, data []byte) error {
+	return r.RespondWithType(ctx, r.defType, status, data)
+}
+
+func (r *recordingResponder) RespondWithType(ctx context.Context, newType, status string, data []byte) error {
+	return r.RespondEnvelope(ctx, &Envelope{Type: newType, Status: status, Data: data})
+}
+
+// RespondEnvelope stores the response without its Payload.
+func (r *recordingResponder) RespondEnvelope(ctx context.Context, e *Envelope) error {
+	if e.Status == "" {
+		panic("status can't be empty")
+	}
+
+	if e.Type == "" {
+		e.Type = r.defType
+	}
+
+	unlock := r.sf.resultLocks.lock(r.id)
+	defer unlock()
+
+	err := r.sf.resultStore.Append(liteproto.TaskResponse{ID: r.id, Type: e.Type, Status: e.Status, Data: e.Data})
+	if err != nil && r.sf.logger != nil {
+		r.sf.logger.Printf("failed to store response for ID=%s: %s", r.id, err.Error())
+	}
+
+	return respondEnvelope(ctx, r.ResponderClient, e)
+}
+
+// validatingResponder is a liteproto.ResponderClient that validates every response
+// with the response schema of its type before sending it. Responses with StatusError are not validated.
+type validatingResponder struct {
+	liteproto.ResponderClient
+	sf      *ServerFeeder
+	defType string
+}
+
+func (r *validatingResponder) Respond(ctx context.Context, status string, data []byte) error {
+	return r.RespondWithType(ctx, r.defType, status, data)
+}
+
+func (r *validatingResponder) RespondWithType(ctx context.Context, newType, status string, data []byte) error {
+	return r.RespondEnvelope(ctx, &Envelope{Type: newType, Status: status, Data: data})
+}
+
+// RespondEnvelope validates the Data of the response, a Payload can't be validated.
+func (r *validatingResponder) RespondEnvelope(ctx context.Context, e *Envelope) error {
+	if e.Type == "" {
+		e.Type = r.defType
+	}
+
+	if schema := r.sf.schemas[e.Type].Response; schema != nil && e.Status != liteproto.StatusError {
+		if err := schema.Validate(e.Data); err != nil {
+			err.(*liteproto.ValidationError).Type = e.Type
+			return err
+		}
+	}
+
+	return respondEnvelope(ctx, r.ResponderClient, e)
+}
+
+// respondEnvelope sends the response with the responder. If it isn't an EnvelopeResponder,
+// the fields of the envelope that TaskResponse doesn't have are dropped.
+func respondEnvelope(ctx context.Context, rc liteproto.ResponderClient, e *Envelope) error {
+	if responder, ok := rc.(EnvelopeResponder); ok {
+		return responder.RespondEnvelope(ctx, e)
+	}
+
+	return rc.RespondWithType(ctx, e.Type, e.Status, e.Data)
+}
+
+// keyedMutex is a set of mutexes identified by a string key.
+// A mutex exists only while it's locked or awaited.
+type keyedMutex struct {
+	locks map[string]*keyedMutexEntry
+	mx    sync.Mutex
+}
+
+type keyedMutexEntry struct {
+	sync.Mutex
+	refs int
+}
+
+func (km *keyedMutex) lock(key string) (unlock func()) {
+	km.mx.Lock()
+	if km.locks == nil {
+		km.locks = map[string]*keyedMutexEntry{}
+	}
+	entry, ok := km.locks[key]
+	if !ok {
+		entry = &keyedMutexEntry{}
+		km.locks[key] = entry
+	}
+	entry.refs++
+	km.mx.Unlock()
+
+	entry.Lock()
+
+	return func() {
+		entry.Unlock()
+
+		km.mx.Lock()
+		entry.refs--
+		if entry.refs == 0 {
+			delete(km.locks, key)
+		}
+		km.mx.Unlock()
+	}
+}
@@ -1,7 +1,8 @@
-package internal
+package transport
 
 import (
 	"context"
+	"sync"
 	"time"
 
 	"github.com/drone/liteproto/liteproto"
@@ -28,26 +29,40 @@ type Runner struct {
 // The stop channel should be closed by the caller when no further responses are expected.
 // If the function returns an error both channels will be nil.
 func (rq *Runner) Run(ctx context.Context, r liteproto.TaskRequest, deadline time.Time) (<-chan liteproto.TaskResponse, chan<- struct{}, error) {
-	if r.ID == "" {
+	return rq.RunEnvelope(ctx, RequestEnvelope(r, deadline, ""))
+}
+
+// RunEnvelope is like Run, but the call is made with a request envelope built by the transport,
+// which can set fields that TaskRequest doesn't have, like Payload. The Runner sets the End field.
+func (rq *Runner) RunEnvelope(ctx context.Context, e *Envelope) (<-chan liteproto.TaskResponse, chan<- struct{}, error) {
+	if e.ID == "" {
 		panic("ID must not be empty")
 	}
 
-	if r.Type == "" {
+	if e.Type == "" {
 		panic("Type must not be empty")
 	}
 
+	deadline := e.Deadline
 	if !deadline.IsZero() && deadline.Before(time.Now()) {
 		return nil, nil, context.DeadlineExceeded
 	}
 
-	var err error
-	err = rq.caller.Call(ctx, r, deadline)
+	// subscribe before making the call, otherwise a response that arrives
+	// before the subscription is in place would be lost.
+
+	outChan, err := rq.respSub.Subscribe(e.ID)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	outChan, err := rq.respSub.Subscribe(r.ID)
+	end := &CallEnd{}
+	e.End = end
+
+	err = rq.caller.Call(ctx, e)
 	if err != nil {
+		end.end()
+		_ = rq.respSub.Unsubscribe(e.ID)
 		return nil, nil, err
 	}
 
@@ -66,9 +81,10 @@ func (rq *Runner) Run(ctx context.Context, r liteproto.TaskRequest, deadline tim
 
 	go func(ctx context.Context) {
 		defer func() {
-			_ = rq.respSub.Unsubscribe(r.ID)
+			_ = rq.respSub.Unsubscribe(e.ID)
 			close(responseChan)
 			cancelFunc()
+			end.end()
 		}()
 
 		for {
@@ -81,10 +97,55 @@ func (rq *Runner) Run(ctx context.Context, r liteproto.TaskRequest, deadline tim
 				if !ok {
 					return
 				}
-				responseChan <- responseData
+
+				if responseData.Status == liteproto.StatusSuccess || responseData.Status == liteproto.StatusError {
+					end.end()
+				}
+
+				// the caller might have stopped reading without closing the stop channel
+				select {
+				case responseChan <- responseData:
+				case <-ctx.Done():
+					return
+				case <-stopChan:
+					return
+				}
 			}
 		}
 	}(ctxJob)
 
 	return responseChan, stopChan, nil
 }
+
+// CallEnd holds the functions that run when a call made by a Runner ends: after a response
+// with StatusSuccess or StatusError, or when the caller stops awaiting the responses.
+// Deliveries find it in the End field of the request envelope.
+type CallEnd struct {
+	funcs []func()
+	ended bool
+	mx    sync.Mutex
+}
+
+// Add makes f run when the call ends. If the call already ended, f runs right away.
+func (c *CallEnd) Add(f func()) {
+	c.mx.Lock()
+	if !c.ended {
+		c.funcs = append(c.funcs, f)
+		c.mx.Unlock()
+		return
+	}
+	c.mx.Unlock()
+
+	f()
+}
+
+func (c *CallEnd) end() {
+	c.mx.Lock()
+	funcs := c.funcs
+	c.funcs, c.ended = nil, true
+	c.mx.Unlock()
+
+	for _, f := range funcs {
+		f()
+	}
+}
@@ -0,0 +1,126 @@
+package transport
+
+import (
+	"context"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/drone/liteproto/liteproto"
+)
+
+// callerFunc is a Caller implemented by a function.
+type callerFunc func(ctx context.Context, e *Envelope) error
+
+func (f callerFunc) Call(ctx context.Context, e *Envelope) error {
+	return f(ctx, e)
+}
+
+// TestRunnerEarlyResponse checks that a response published before the call returns isn't lost.
+func TestRunnerEarlyResponse(t *testing.T) {
+	pubsub := &PubSub{}
+
+	runner := NewRunner(callerFunc(func(ctx context.Context, e *Envelope) error {
+		return pubsub.Publish(ctx, liteproto.TaskResponse{ID: e.ID, Type: e.Type, Status: liteproto.StatusOK})
+	}), pubsub)
+
+	response, stop, err := runner.Run(context.Background(), liteproto.TaskRequest{ID: "1", Type: "t"}, time.Now().Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer close(stop)
+
+	if r, ok := <-response; !ok || r.Status != liteproto.StatusOK {
+		t.Errorf("expected the early response, got %+v", r)
+	}
+}
+
+func TestRunnerCallFailed(t *testing.T) {
+	pubsub := &PubSub{}
+
+	runner := NewRunner(callerFunc(func(context.Context, *Envelope) error {
+		return liteproto.ErrUnknownType
+	}), pubsub)
+
+	if _, _, err := runner.Run(context.Background(), liteproto.TaskRequest{ID: "1", Type: "t"}, time.Time{}); err != liteproto.ErrUnknownType {
+		t.Fatalf("expected ErrUnknownType, got %v", err)
+	}
+
+	if err := pubsub.Publish(context.Background(), liteproto.TaskResponse{ID: "1"}); err != liteproto.ErrNotSubscribed {
+		t.Errorf("expected the subscription to be removed, got %v", err)
+	}
+}
+
+// TestRunnerNoLeak checks that the goroutine forwarding the responses ends and the subscription is removed
+// when the caller stops reading the responses without closing the stop channel.
+func TestRunnerNoLeak(t *testing.T) {
+	tests := []struct {
+		name string
+		run  func(runner *Runner) (response <-chan liteproto.TaskResponse, stop chan<- struct{}, end func())
+	}{
+		{
+			name: "abandoned",
+			run: func(runner *Runner) (<-chan liteproto.TaskResponse, chan<- struct{}, func()) {
+				response, stop, _ := runner.Run(context.Background(), liteproto.TaskRequest{ID: "1", Type: "t"}, time.Time{})
+				return response, stop, func() { close(stop) }
+			},
+		},
+		{
+			name: "expired",
+			run: func(runner *Runner) (<-chan liteproto.TaskResponse, chan<- struct{}, func()) {
+				response, stop, _ := runner.Run(context.Background(), liteproto.TaskRequest{ID: "1", Type: "t"}, time.Now().Add(50*time.Millisecond))
+				return response, stop, func() {}
+			},
+		},
+		{
+			name: "cancelled",
+			run: func(runner *Runner) (<-chan liteproto.TaskResponse, chan<- struct{}, func()) {
+				ctx, cancel := context.WithCancel(context.Background())
+				response, stop, _ := runner.Run(ctx, liteproto.TaskRequest{ID: "1", Type: "t"}, time.Time{})
+				return response, stop, cancel
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pubsub := &PubSub{BufferSize: 1}
+			runner := NewRunner(callerFunc(func(context.Context, *Envelope) error {
+				return nil
+			}), pubsub)
+
+			before := runtime.NumGoroutine()
+
+			response, _, end := test.run(runner)
+
+			// the caller reads the first response only, the second one blocks the forwarding goroutine
+			for i := 0; i < 2; i++ {
+				if err := pubsub.Publish(context.Background(), liteproto.TaskResponse{ID: "1", Status: liteproto.StatusOK}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			<-response
+
+			end()
+
+			waitFor(t, func() bool {
+				return runtime.NumGoroutine() <= before
+			})
+
+			if err := pubsub.Publish(context.Background(), liteproto.TaskResponse{ID: "1"}); err != liteproto.ErrNotSubscribed {
+				t.Errorf("expected the subscription to be removed, got %v", err)
+			}
+		})
+	}
+}
+
+// waitFor waits up to a second for the condition to become true.
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+
+	for deadline := time.Now().Add(time.Second); !condition(); time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+	}
+}
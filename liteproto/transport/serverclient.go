@@ -0,0 +1,174 @@
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/drone/liteproto/liteproto"
+)
+
+// Config holds the parts of a ServerClient supplied by a transport and optional settings.
+type Config struct {
+	// Delivery sends requests and responses to remote servers. It's required.
+	Delivery Delivery
+
+	// Addr is the address to which calls are delivered. Empty means the default address of the Delivery.
+	Addr string
+
+	// ReplyTo is the address of this ServerClient that is advertised to remote servers in every request.
+	ReplyTo string
+
+	// PubSub distributes received responses to the awaiting calls. If nil, a PubSub with default settings is used.
+	PubSub ResponsePubSub
+
+	// ResultStore, if not nil, keeps all responses sent by the registered execers, so they can be replayed.
+	ResultStore liteproto.ResultStore
+
+	// OrphanHandler, if not nil, is called with every received response that nobody awaits.
+	OrphanHandler func(response liteproto.TaskResponse)
+
+	// Logger is used for logging panics that occur during execution of tasks. If nil, log.Default() is used.
+	Logger *log.Logger
+}
+
+// ServerClient is a transport independent implementation of liteproto.ServerClient
+// assembled from the building blocks of this package.
+type ServerClient struct {
+	delivery      Delivery
+	addr          string
+	replyTo       string
+	pubsub        ResponsePubSub
+	client        *client
+	sf            *ServerFeeder
+	orphanHandler func(response liteproto.TaskResponse)
+}
+
+// NewServerClient creates a new ServerClient.
+func NewServerClient(cfg Config) *ServerClient {
+	if cfg.Delivery == nil {
+		panic("Delivery must not be nil")
+	}
+
+	if cfg.PubSub == nil {
+		cfg.PubSub = &PubSub{}
+	}
+
+	if cfg.Logger == nil {
+		cfg.Logger = log.Default()
+	}
+
+	sc := &ServerClient{
+		delivery:      cfg.Delivery,
+		addr:          cfg.Addr,
+		replyTo:       cfg.ReplyTo,
+		pubsub:        cfg.PubSub,
+		orphanHandler: cfg.OrphanHandler,
+	}
+
+	sc.client = newClient(NewCaller(cfg.Delivery, cfg.Addr, cfg.ReplyTo), cfg.PubSub)
+	sc.sf = NewServerFeeder(responderFactory{sc: sc}, cfg.ResultStore, cfg.Logger)
+
+	// make sure it implements ServerClient, Resubscriber and SchemaServer interfaces
+	var e liteproto.ServerClient = sc
+	var rs liteproto.Resubscriber = sc
+	var ss liteproto.SchemaServer = sc
+	_, _, _ = e, rs, ss
+
+	return sc
+}
+
+func (sc *ServerClient) Register(t string, execer liteproto.Execer) {
+	sc.sf.Register(t, execer)
+}
+
+func (sc *ServerClient) RegisterWithResponder(t string, execer liteproto.ExecerWithResponder) {
+	sc.sf.RegisterWithResponder(t, execer)
+}
+
+func (sc *ServerClient) RegisterSchema(t string, schemas liteproto.TypeSchemas) {
+	sc.sf.RegisterSchema(t, schemas)
+}
+
+func (sc *ServerClient) RegisterCatchAll(execer liteproto.ExecerWithResponder) {
+	sc.sf.RegisterCatchAll(execer)
+}
+
+func (sc *ServerClient) Call(ctx context.Context, r liteproto.TaskRequest) (err error) {
+	return sc.client.Call(ctx, r)
+}
+
+func (sc *ServerClient) CallWithResponse(ctx context.Context, r liteproto.TaskRequest) (response <-chan liteproto.TaskResponse, stop chan<- struct{}, err error) {
+	return sc.client.CallWithResponse(ctx, r)
+}
+
+func (sc *ServerClient) CallWithDeadline(ctx context.Context, r liteproto.TaskRequest, deadline time.Time) (response <-chan liteproto.TaskResponse, stop chan<- struct{}, err error) {
+	return sc.client.CallWithDeadline(ctx, r, deadline)
+}
+
+// CallEnvelope is like CallWithDeadline, but the call is made with a request envelope built by the transport,
+// which can set fields that TaskRequest doesn't have, like Payload.
+func (sc *ServerClient) CallEnvelope(ctx context.Context, request *Envelope) (response <-chan liteproto.TaskResponse, stop chan<- struct{}, err error) {
+	return sc.client.runner.RunEnvelope(ctx, request)
+}
+
+// Resubscribe asks the remote server to replay the responses of the task with the provided ID.
+// The remote server must have a ResultStore.
+func (sc *ServerClient) Resubscribe(ctx context.Context, id string, from int, deadline time.Time) (response <-chan liteproto.TaskResponse, stop chan<- struct{}, err error) {
+	data, err := json.Marshal(liteproto.ReplayRequest{From: from})
+	if err != nil {
+		return
+	}
+
+	return sc.client.runner.Run(ctx, liteproto.TaskRequest{ID: id, Type: liteproto.TypeReplay, Data: data}, deadline)
+}
+
+// ClientFor returns a client that delivers calls with the provided Delivery to the provided address.
+// Responses to the calls are received by this ServerClient, so it can be used for calling other peers.
+func (sc *ServerClient) ClientFor(delivery Delivery, addr string) liteproto.Client {
+	return newClient(NewCaller(delivery, addr, sc.replyTo), sc.pubsub)
+}
+
+// Receive processes an envelope received by the transport. A request is relayed to the registered execer
+// and a response is published to the awaiting call. The returned error tells the transport how to reply:
+//   - liteproto.ErrUnknownType if there is no execer for the request type,
+//   - liteproto.ErrNoResults if a replay was requested, but there are no stored responses,
+//   - context.DeadlineExceeded if the request deadline already expired,
+//   - *liteproto.ValidationError if the request payload doesn't match the schema of its type,
+//   - liteproto.ErrNotSubscribed if nobody awaits the response; the OrphanHandler is called before returning.
+//
+// A request is fed with ServerFeeder.FeedEnvelope, the context isn't passed to execers.
+// A response is published with PublishEnvelope if the PubSub implements EnvelopePub.
+func (sc *ServerClient) Receive(ctx context.Context, e *Envelope) error {
+	if e.IsRequest() {
+		return sc.sf.FeedEnvelope(context.Background(), e)
+	}
+
+	response := e.Response()
+
+	var err error
+	if pub, ok := sc.pubsub.(EnvelopePub); ok {
+		err = pub.PublishEnvelope(ctx, e)
+	} else {
+		err = sc.pubsub.Publish(ctx, response)
+	}
+
+	if err == liteproto.ErrNotSubscribed && !e.Relayed && sc.orphanHandler != nil {
+		sc.orphanHandler(response)
+	}
+
+	return err
+}
+
+// SessionReceiver returns a Receiver for Sessions that passes envelopes to Receive.
+// Like the HTTP handler, it accepts responses that nobody awaits, after they were passed to the OrphanHandler.
+func (sc *ServerClient) SessionReceiver() Receiver {
+	return func(ctx context.Context, e *Envelope) error {
+		err := sc.Receive(ctx, e)
+		if err == liteproto.ErrNotSubscribed {
+			return nil
+		}
+		return err
+	}
+}
@@ -0,0 +1,292 @@
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+
+	"github.com/drone/liteproto/liteproto"
+)
+
+// ErrSessionClosed is returned by Session.Send when the session's connection is closed or broken
+// before the envelope was written to it.
+var ErrSessionClosed = errors.New("session closed")
+
+// ErrNotAcknowledged is returned by Session.Send when the session's connection is closed or broken
+// after the envelope was written to it, but before it was acknowledged. The other side may have received it.
+var ErrNotAcknowledged = errors.New("session closed before the envelope was acknowledged")
+
+// FrameConn is a bidirectional connection that carries frames, i.e. byte slices with preserved boundaries.
+// ReadFrame is called from a single goroutine and WriteFrame calls are serialized by the Session.
+// WriteFrame returns ErrFrameTooLarge without writing anything if the frame exceeds a size limit,
+// the connection must remain usable in that case.
+type FrameConn interface {
+	ReadFrame() ([]byte, error)
+	WriteFrame(frame []byte) error
+	Close() error
+}
+
+// Receiver processes envelopes received by a Session. ServerClient.Receive is usually used.
+type Receiver func(ctx context.Context, e *Envelope) error
+
+// Session multiplexes envelopes in both directions over a single FrameConn.
+// Every sent envelope is acknowledged by the other side with the error returned by its Receiver,
+// so Send reports errors like liteproto.ErrUnknownType just like a request-response transport.
+//
+// Sessions are flow controlled: at most MaxInFlight sent envelopes can await acknowledgement,
+// further Send calls wait. This way the receiving side never buffers more than that.
+type Session struct {
+	conn    FrameConn
+	receive Receiver
+	window  chan struct{}
+
+	seq     uint64
+	pending map[uint64]chan error
+	mx      sync.Mutex // guards seq and pending
+	writeMx sync.Mutex
+
+	ctx       context.Context
+	cancel    func()
+	closeOnce sync.Once
+}
+
+// frame is the unit of communication of a Session. Kind is either "msg" or "ack".
+type frame struct {
+	Kind     string    `json:"k"`
+	Seq      uint64    `json:"seq"`
+	Envelope *Envelope `json:"env,omitempty"`
+	Code     string    `json:"code,omitempty"`
+	Error    string    `json:"err,omitempty"`
+}
+
+const (
+	frameMessage = "msg"
+	frameAck     = "ack"
+)
+
+// NewSession creates a new Session. Run must be called to start receiving frames.
+func NewSession(conn FrameConn, receive Receiver) *Session {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &Session{
+		conn:    conn,
+		receive: receive,
+		window:  make(chan struct{}, MaxInFlight),
+		pending: map[uint64]chan error{},
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+}
+
+// MaxInFlight is the number of envelopes a Session can send before it must wait for acknowledgements.
+// It's also the number of received envelopes that can wait for the Receiver, so acknowledgements
+// are always processed, even while the Receiver is busy.
+const MaxInFlight = 128
+
+// Run reads frames from the connection until it fails or the session is closed.
+// Envelopes are passed to the Receiver one by one, in the order they were sent.
+// When Run returns, the session is closed and all pending Send calls fail with ErrSessionClosed
+// or ErrNotAcknowledged.
+func (s *Session) Run() error {
+	defer s.Close()
+
+	inbound := make(chan frame, MaxInFlight)
+	defer close(inbound)
+
+	go func() {
+		for f := range inbound {
+			ack := frame{Kind: frameAck, Seq: f.Seq}
+			if err := s.receive(s.ctx, f.Envelope); err != nil {
+				ack.Code, ack.Error = ErrorCode(err), ErrorMessage(err)
+			}
+
+			_ = s.write(&ack)
+		}
+	}()
+
+	for {
+		data, err := s.conn.ReadFrame()
+		if err != nil {
+			select {
+			case <-s.ctx.Done():
+				return nil
+			default:
+				return err
+			}
+		}
+
+		var f frame
+		if err = json.Unmarshal(data, &f); err != nil {
+			return err
+		}
+
+		switch f.Kind {
+		case frameMessage:
+			if f.Envelope == nil {
+				continue
+			}
+
+			select {
+			case inbound <- f:
+			case <-s.ctx.Done():
+				return nil
+			}
+
+		case frameAck:
+			s.mx.Lock()
+			ch, ok := s.pending[f.Seq]
+			delete(s.pending, f.Seq)
+			s.mx.Unlock()
+
+			if ok {
+				ch <- CodeError(f.Code, f.Error)
+			}
+		}
+	}
+}
+
+// Send sends an envelope and waits until the other side acknowledges it.
+// It returns the error returned by the other side's Receiver, or ErrFrameTooLarge
+// if the encoded envelope is too large for the connection.
+func (s *Session) Send(ctx context.Context, e *Envelope) error {
+	select {
+	case s.window <- struct{}{}:
+		defer func() { <-s.window }()
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-s.ctx.Done():
+		return ErrSessionClosed
+	}
+
+	ch := make(chan error, 1)
+
+	s.mx.Lock()
+	s.seq++
+	seq := s.seq
+	s.pending[seq] = ch
+	s.mx.Unlock()
+
+	defer func() {
+		s.mx.Lock()
+		delete(s.pending, seq)
+		s.mx.Unlock()
+	}()
+
+	if err := s.write(&frame{Kind: frameMessage, Seq: seq, Envelope: e}); err != nil {
+		return err
+	}
+
+	select {
+	case err := <-ch:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-s.ctx.Done():
+		return ErrNotAcknowledged
+	}
+}
+
+// Close closes the session and its connection.
+func (s *Session) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		s.cancel()
+		err = s.conn.Close()
+	})
+	return err
+}
+
+// Done returns a channel that's closed when the session is closed.
+func (s *Session) Done() <-chan struct{} {
+	return s.ctx.Done()
+}
+
+func (s *Session) write(f *frame) error {
+	data, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case <-s.ctx.Done():
+		return ErrSessionClosed
+	default:
+	}
+
+	s.writeMx.Lock()
+	defer s.writeMx.Unlock()
+
+	if err = s.conn.WriteFrame(data); err == ErrFrameTooLarge {
+		// nothing was written, the connection is still usable
+		return err
+	} else if err != nil {
+		s.Close()
+		return ErrSessionClosed
+	}
+
+	return nil
+}
+
+// RemoteError is an error returned by the other side of a connection that has no local equivalent.
+type RemoteError struct {
+	Message string
+}
+
+func (e RemoteError) Error() string {
+	return e.Message
+}
+
+var errorCodes = map[error]string{
+	liteproto.ErrUnknownType:   "unknown_type",
+	liteproto.ErrNoResults:     "no_results",
+	liteproto.ErrNotSubscribed: "not_subscribed",
+	context.DeadlineExceeded:   "deadline_exceeded",
+}
+
+const codeInvalidPayload = "invalid_payload"
+
+// ErrorCode returns a code of an error that can be sent over the wire. CodeError reverses it.
+func ErrorCode(err error) string {
+	if _, ok := err.(*liteproto.ValidationError); ok {
+		return codeInvalidPayload
+	}
+	if code, ok := errorCodes[err]; ok {
+		return code
+	}
+	return "error"
+}
+
+// ErrorMessage returns a message of an error that is sent over the wire with its code.
+// Validation errors are sent as JSON, so that CodeError can restore them.
+func ErrorMessage(err error) string {
+	if verr, ok := err.(*liteproto.ValidationError); ok {
+		if data, jsonErr := json.Marshal(verr); jsonErr == nil {
+			return string(data)
+		}
+	}
+	return err.Error()
+}
+
+// CodeError returns the error for a code returned by ErrorCode. Unknown errors are returned as RemoteError.
+// It returns nil if both the code and the message are empty.
+func CodeError(code, message string) error {
+	if code == "" && message == "" {
+		return nil
+	}
+
+	if code == codeInvalidPayload {
+		verr := &liteproto.ValidationError{}
+		if err := json.Unmarshal([]byte(message), verr); err == nil {
+			return verr
+		}
+	}
+
+	for err, c := range errorCodes {
+		if c == code {
+			return err
+		}
+	}
+
+	return RemoteError{Message: message}
+}
@@ -0,0 +1,58 @@
+package transport
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/drone/liteproto/liteproto"
+)
+
+func TestErrorCodeValidationError(t *testing.T) {
+	sent := &liteproto.ValidationError{
+		Type: "build",
+		Errors: []liteproto.FieldError{
+			{Path: "/name", Message: "must be of type string"},
+			{Path: "", Message: "must have required property \"id\""},
+		},
+	}
+
+	code, message := ErrorCode(sent), ErrorMessage(sent)
+	if code != codeInvalidPayload {
+		t.Fatalf("expected code %q, got %q", codeInvalidPayload, code)
+	}
+
+	received, ok := CodeError(code, message).(*liteproto.ValidationError)
+	if !ok {
+		t.Fatalf("expected *ValidationError, got %T", CodeError(code, message))
+	}
+	if !reflect.DeepEqual(received, sent) {
+		t.Errorf("expected %+v, got %+v", sent, received)
+	}
+}
+
+func TestErrorCode(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected error
+	}{
+		{err: liteproto.ErrUnknownType, expected: liteproto.ErrUnknownType},
+		{err: liteproto.ErrNotSubscribed, expected: liteproto.ErrNotSubscribed},
+		{err: errors.New("disk full"), expected: RemoteError{Message: "disk full"}},
+	}
+
+	for _, test := range tests {
+		if got := CodeError(ErrorCode(test.err), ErrorMessage(test.err)); got != test.expected {
+			t.Errorf("%v: expected %v, got %v", test.err, test.expected, got)
+		}
+	}
+
+	if err := CodeError("", ""); err != nil {
+		t.Errorf("expected nil for an empty code, got %v", err)
+	}
+
+	// a malformed validation error is still returned as an error
+	if err := CodeError(codeInvalidPayload, "not json"); err == nil {
+		t.Error("expected an error for a malformed validation error")
+	}
+}
@@ -0,0 +1,233 @@
+package transport
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/drone/liteproto/liteproto"
+)
+
+// Sessions holds sessions of connected peers, a peer can have several sessions, like a pool of connections.
+// While a peer is disconnected, deliveries to it wait for it to reconnect, so pending calls resume
+// on the new connection.
+type Sessions struct {
+	sessions map[string][]*Session
+	keys     map[string]string // keys of peers whose sessions were added with AddWithKey
+	changed  chan struct{}     // closed and replaced whenever a session is added
+	closed   bool              // set by CloseAll, sessions added afterwards are closed
+	mx       sync.Mutex
+
+	next          uint64 // round robin counter
+	resumeTimeout time.Duration
+}
+
+// NewSessions creates a new Sessions. Parameter resumeTimeout is the longest time
+// a delivery waits for a disconnected peer.
+func NewSessions(resumeTimeout time.Duration) *Sessions {
+	return &Sessions{
+		sessions:      map[string][]*Session{},
+		keys:          map[string]string{},
+		changed:       make(chan struct{}),
+		resumeTimeout: resumeTimeout,
+	}
+}
+
+// Add adds a session of the peer. It reports whether it was added. After CloseAll,
+// sessions are closed instead of added, so that a connection established while closing doesn't linger.
+func (r *Sessions) Add(id string, s *Session) bool {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	if r.closed {
+		_ = s.Close()
+		return false
+	}
+
+	r.sessions[id] = append(r.sessions[id], s)
+
+	close(r.changed)
+	r.changed = make(chan struct{})
+
+	return true
+}
+
+// AddIfAbsent adds the session of the peer only if the peer has no sessions. It reports whether it was added.
+// Like Add, it closes the session after CloseAll.
+func (r *Sessions) AddIfAbsent(id string, s *Session) bool {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	if r.closed {
+		_ = s.Close()
+		return false
+	}
+
+	if len(r.sessions[id]) > 0 {
+		return false
+	}
+
+	r.sessions[id] = []*Session{s}
+	delete(r.keys, id)
+
+	close(r.changed)
+	r.changed = make(chan struct{})
+
+	return true
+}
+
+// AddWithKey adds a session of the peer if the peer has no sessions or its sessions were added
+// with the same key. This way a peer can have a pool of connections, but a connection that presents
+// the ID of another peer can't join its pool. It reports whether the session was added,
+// like Add it closes the session after CloseAll.
+func (r *Sessions) AddWithKey(id, key string, s *Session) bool {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	if r.closed {
+		_ = s.Close()
+		return false
+	}
+
+	if len(r.sessions[id]) > 0 && (key == "" || r.keys[id] != key) {
+		return false
+	}
+
+	r.sessions[id] = append(r.sessions[id], s)
+	r.keys[id] = key
+
+	close(r.changed)
+	r.changed = make(chan struct{})
+
+	return true
+}
+
+// Connected reports whether the peer has any sessions.
+func (r *Sessions) Connected(id string) bool {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	return len(r.sessions[id]) > 0
+}
+
+// Replace makes the session the only session of the peer, the previous sessions are closed.
+// It's useful when a peer has a single connection and reconnects before the old connection is found broken.
+// It reports whether the session was added, like Add it closes the session after CloseAll.
+func (r *Sessions) Replace(id string, s *Session) bool {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	if r.closed {
+		_ = s.Close()
+		return false
+	}
+
+	for _, old := range r.sessions[id] {
+		_ = old.Close()
+	}
+
+	r.sessions[id] = []*Session{s}
+	delete(r.keys, id)
+
+	close(r.changed)
+	r.changed = make(chan struct{})
+
+	return true
+}
+
+// Remove removes a session of the peer.
+func (r *Sessions) Remove(id string, s *Session) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	sessions := r.sessions[id]
+	for i := range sessions {
+		if sessions[i] == s {
+			sessions = append(sessions[:i:i], sessions[i+1:]...)
+			break
+		}
+	}
+
+	if len(sessions) == 0 {
+		delete(r.sessions, id)
+		delete(r.keys, id)
+	} else {
+		r.sessions[id] = sessions
+	}
+}
+
+// Peers returns IDs of all connected peers.
+func (r *Sessions) Peers() []string {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	ids := make([]string, 0, len(r.sessions))
+	for id := range r.sessions {
+		ids = append(ids, id)
+	}
+
+	return ids
+}
+
+// CloseAll closes all sessions. Sessions added afterwards are closed right away.
+func (r *Sessions) CloseAll() {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	r.closed = true
+
+	for id, sessions := range r.sessions {
+		for _, s := range sessions {
+			_ = s.Close()
+		}
+		delete(r.sessions, id)
+		delete(r.keys, id)
+	}
+}
+
+// Deliver sends the envelope over one of the sessions of the peer. If the peer is not connected,
+// or the connection breaks before the envelope is sent, it waits up to the resume timeout
+// for the peer to reconnect and tries again. A response is sent again also if the connection breaks
+// after it was sent, but before it was acknowledged, so a response can be received more than once.
+// A request is not, so that a task isn't executed twice, ErrNotAcknowledged is returned instead.
+// It returns liteproto.ErrUnknownPeer if the peer doesn't connect in time, and ErrFrameTooLarge
+// without retrying if the envelope is too large to be sent.
+func (r *Sessions) Deliver(ctx context.Context, id string, e *Envelope) error {
+	_, err := r.DeliverOn(ctx, id, e)
+	return err
+}
+
+// DeliverOn is like Deliver, but it also returns the session the envelope was last sent over,
+// nil if none. The session's Done channel tells the caller when responses can't arrive over it anymore.
+func (r *Sessions) DeliverOn(ctx context.Context, id string, e *Envelope) (*Session, error) {
+	timer := time.NewTimer(r.resumeTimeout)
+	defer timer.Stop()
+
+	for {
+		r.mx.Lock()
+		sessions := r.sessions[id]
+		changed := r.changed
+		r.mx.Unlock()
+
+		if len(sessions) > 0 {
+			s := sessions[atomic.AddUint64(&r.next, 1)%uint64(len(sessions))]
+
+			err := s.Send(ctx, e)
+			if err != ErrSessionClosed && (err != ErrNotAcknowledged || e.IsRequest()) {
+				return s, err
+			}
+
+			r.Remove(id, s)
+			continue
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-timer.C:
+			return nil, liteproto.ErrUnknownPeer
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package transport
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+)
+
+// ErrFrameTooLarge is returned when a received or sent frame is larger than the allowed maximum.
+var ErrFrameTooLarge = errors.New("frame too large")
+
+// DefaultMaxFrameSize is the default maximum size of a frame read by a stream FrameConn.
+const DefaultMaxFrameSize = 64 << 20
+
+// NewStreamConn creates a FrameConn that carries frames over a byte stream, such as a TCP connection
+// or a pipe. Each frame is prefixed with its length as 4-byte big-endian integer. Frames larger than
+// maxFrameSize are rejected with ErrFrameTooLarge, zero value means DefaultMaxFrameSize.
+func NewStreamConn(rwc io.ReadWriteCloser, maxFrameSize int) FrameConn {
+	if maxFrameSize <= 0 {
+		maxFrameSize = DefaultMaxFrameSize
+	}
+
+	return &streamConn{
+		rwc:          rwc,
+		r:            bufio.NewReader(rwc),
+		w:            bufio.NewWriter(rwc),
+		maxFrameSize: maxFrameSize,
+	}
+}
+
+type streamConn struct {
+	rwc          io.ReadWriteCloser
+	r            *bufio.Reader
+	w            *bufio.Writer
+	wmx          sync.Mutex
+	maxFrameSize int
+}
+
+func (c *streamConn) ReadFrame() ([]byte, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(c.r, header[:]); err != nil {
+		return nil, err
+	}
+
+	size := binary.BigEndian.Uint32(header[:])
+	if uint64(size) > uint64(c.maxFrameSize) {
+		return nil, ErrFrameTooLarge
+	}
+
+	frame := make([]byte, size)
+	if _, err := io.ReadFull(c.r, frame); err != nil {
+		return nil, err
+	}
+
+	return frame, nil
+}
+
+func (c *streamConn) WriteFrame(frame []byte) error {
+	if len(frame) > c.maxFrameSize {
+		return ErrFrameTooLarge
+	}
+
+	c.wmx.Lock()
+	defer c.wmx.Unlock()
+
+	var header [4]byte
+	binary.BigEndian.PutUint32(header[:], uint32(len(frame)))
+
+	if _, err := c.w.Write(header[:]); err != nil {
+		return err
+	}
+	if _, err := c.w.Write(frame); err != nil {
+		return err
+	}
+
+	return c.w.Flush()
+}
+
+func (c *streamConn) Close() error {
+	return c.rwc.Close()
+}